@@ -52,7 +52,8 @@ const (
 	Version = "1.0.0"
 )
 
-// Package-level convenience functions
+// Package-level convenience functions. They use the service bound to their
+// context with ContextWithService, or else the default service.
 
 // Initialize initializes the audit module with the given configuration
 // and sets it as the default service for builders.
@@ -76,50 +77,56 @@ func InitializeWithDefaults(mongoURI, databaseName string) error {
 	return Initialize(config)
 }
 
-// LogAction is a convenience function to log an audit entry using the default service
+// LogAction is a convenience function to log an audit entry using the context's service
 func LogAction(ctx context.Context, entry AuditEntry) error {
-	if defaultService == nil {
-		return ErrNoServiceConfigured{}
+	service, err := serviceFor(ctx)
+	if err != nil {
+		return err
 	}
-	return defaultService.LogAction(ctx, entry)
+	return service.LogAction(ctx, entry)
 }
 
-// GetHistory is a convenience function to get audit history using the default service
+// GetHistory is a convenience function to get audit history using the context's service
 func GetHistory(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
-	if defaultService == nil {
-		return nil, ErrNoServiceConfigured{}
+	service, err := serviceFor(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return defaultService.GetHistory(ctx, query)
+	return service.GetHistory(ctx, query)
 }
 
-// GetByID is a convenience function to get an audit entry by ID using the default service
+// GetByID is a convenience function to get an audit entry by ID using the context's service
 func GetByID(ctx context.Context, id string) (*AuditEntry, error) {
-	if defaultService == nil {
-		return nil, ErrNoServiceConfigured{}
+	service, err := serviceFor(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return defaultService.GetByID(ctx, id)
+	return service.GetByID(ctx, id)
 }
 
-// GetResourceHistory is a convenience function to get resource history using the default service
+// GetResourceHistory is a convenience function to get resource history using the context's service
 func GetResourceHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
-	if defaultService == nil {
-		return nil, ErrNoServiceConfigured{}
+	service, err := serviceFor(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return defaultService.GetResourceHistory(ctx, resourceType, resourceID, limit)
+	return service.GetResourceHistory(ctx, resourceType, resourceID, limit)
 }
 
-// GetActorHistory is a convenience function to get actor history using the default service
+// GetActorHistory is a convenience function to get actor history using the context's service
 func GetActorHistory(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
-	if defaultService == nil {
-		return nil, ErrNoServiceConfigured{}
+	service, err := serviceFor(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return defaultService.GetActorHistory(ctx, actorID, actorType, limit)
+	return service.GetActorHistory(ctx, actorID, actorType, limit)
 }
 
 // Shutdown gracefully shuts down the default audit service
 func Shutdown(ctx context.Context) error {
-	if defaultService == nil {
+	service := DefaultService()
+	if service == nil {
 		return nil
 	}
-	return defaultService.Close(ctx)
+	return service.Close(ctx)
 }
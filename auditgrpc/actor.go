@@ -0,0 +1,96 @@
+package auditgrpc
+
+import (
+	"context"
+	"net"
+
+	"github.com/Doraverse-Workspace/audit"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Incoming metadata keys read by ActorFromMetadata
+const (
+	MetadataActorID   = "x-audit-actor-id"
+	MetadataActorType = "x-audit-actor-type"
+	MetadataActorName = "x-audit-actor-name"
+	MetadataSessionID = "x-audit-session-id"
+)
+
+// ActorFromContext resolves the actor of an RPC from the verified peer
+// identity: the verified TLS client certificate identifies a service actor,
+// and otherwise the peer address identifies an API client. Metadata sent by
+// the client is ignored, since any caller can set it; see
+// Config.TrustActorMetadata.
+func ActorFromContext(ctx context.Context) audit.Actor {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return audit.Actor{ID: "unknown", Type: audit.ActorTypeAPI}
+	}
+
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		for _, chain := range tlsInfo.State.VerifiedChains {
+			if len(chain) > 0 && chain[0].Subject.CommonName != "" {
+				return audit.Actor{
+					ID:   chain[0].Subject.CommonName,
+					Type: audit.ActorTypeService,
+					Name: chain[0].Subject.String(),
+				}
+			}
+		}
+	}
+
+	return audit.Actor{ID: PeerAddress(ctx), Type: audit.ActorTypeAPI}
+}
+
+// ActorFromMetadata returns the actor asserted by the client in the
+// x-audit-actor-* metadata keys, defaulting to a user actor when no type is
+// given. It returns false when no actor ID is given or the type is unknown.
+// The asserted actor is not verified.
+func ActorFromMetadata(ctx context.Context) (audit.Actor, bool) {
+	id := firstMetadata(ctx, MetadataActorID)
+	if id == "" {
+		return audit.Actor{}, false
+	}
+
+	actorType := audit.ActorType(firstMetadata(ctx, MetadataActorType))
+	switch actorType {
+	case "":
+		actorType = audit.ActorTypeUser
+	case audit.ActorTypeUser, audit.ActorTypeSystem, audit.ActorTypeService, audit.ActorTypeAPI, audit.ActorTypeAdmin:
+	default:
+		return audit.Actor{}, false
+	}
+
+	return audit.Actor{
+		ID:        id,
+		Type:      actorType,
+		Name:      firstMetadata(ctx, MetadataActorName),
+		SessionID: firstMetadata(ctx, MetadataSessionID),
+	}, true
+}
+
+// PeerAddress returns the host part of the RPC peer address, or an empty
+// string when no peer information is available
+func PeerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// firstMetadata returns the first incoming metadata value for a key
+func firstMetadata(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,229 @@
+// Package auditgrpc provides gRPC server interceptors that record an audit
+// entry for every RPC handled by the server.
+//
+// Basic usage:
+//
+//	interceptor := auditgrpc.NewInterceptor(service, auditgrpc.Config{
+//		Methods: map[string]auditgrpc.MethodMapping{
+//			"/users.v1.UserService/UpdateUser": {Action: audit.ActionUpdate, ResourceType: "user"},
+//			"/users.v1.UserService/*":          {Action: audit.ActionView, ResourceType: "user"},
+//		},
+//	})
+//
+//	server := grpc.NewServer(
+//		grpc.ChainUnaryInterceptor(interceptor.Unary()),
+//		grpc.ChainStreamInterceptor(interceptor.Stream()),
+//	)
+package auditgrpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Doraverse-Workspace/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// MethodMapping describes how an RPC maps onto an audit action and resource
+type MethodMapping struct {
+	Action       audit.AuditAction
+	ResourceType string
+
+	// ResourceID extracts the resource identifier from the request message.
+	// For streaming RPCs it receives the first message read from the client,
+	// or nil if the handler never read one. When nil or when it returns an
+	// empty string, the request's GetId() is used if present, otherwise the
+	// full method name.
+	ResourceID func(req any) string
+}
+
+// Config represents the configuration for the audit interceptors
+type Config struct {
+	// Methods maps full method names ("/package.Service/Method") to audit
+	// mappings. A "/package.Service/*" key matches every method of a service.
+	Methods map[string]MethodMapping
+
+	// DefaultMapping is used for methods absent from Methods. If nil,
+	// unmapped methods are not audited.
+	DefaultMapping *MethodMapping
+
+	// ActorFunc resolves the actor performing the RPC. Defaults to the
+	// actor asserted in metadata when TrustActorMetadata accepts it, and to
+	// ActorFromContext otherwise.
+	ActorFunc func(ctx context.Context) audit.Actor
+
+	// TrustActorMetadata decides whether the actor asserted by the client in
+	// the x-audit-actor-* metadata keys is recorded, e.g. by checking that
+	// the verified peer is a gateway allowed to act on behalf of users. If
+	// nil, the metadata is ignored.
+	TrustActorMetadata func(ctx context.Context, asserted audit.Actor) bool
+
+	// ErrorHandler is called when the audit entry cannot be logged. The RPC
+	// result is never affected by audit failures. Defaults to logging the
+	// error with the default slog logger.
+	ErrorHandler func(ctx context.Context, entry audit.AuditEntry, err error)
+}
+
+// Interceptor records audit entries for RPCs using an AuditService
+type Interceptor struct {
+	service audit.AuditService
+	config  Config
+}
+
+// NewInterceptor creates a new audit interceptor
+func NewInterceptor(service audit.AuditService, config Config) *Interceptor {
+	if config.ActorFunc == nil {
+		trust := config.TrustActorMetadata
+		config.ActorFunc = func(ctx context.Context) audit.Actor {
+			if trust != nil {
+				if asserted, ok := ActorFromMetadata(ctx); ok && trust(ctx, asserted) {
+					return asserted
+				}
+			}
+			return ActorFromContext(ctx)
+		}
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = logError
+	}
+	return &Interceptor{
+		service: service,
+		config:  config,
+	}
+}
+
+// Unary returns a unary server interceptor
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		mapping, ok := i.lookup(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		i.record(ctx, info.FullMethod, mapping, req, start, err)
+
+		return resp, err
+	}
+}
+
+// Stream returns a stream server interceptor
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		mapping, ok := i.lookup(info.FullMethod)
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		start := time.Now()
+		stream := &recordingStream{ServerStream: ss}
+		err := handler(srv, stream)
+		i.record(ss.Context(), info.FullMethod, mapping, stream.first, start, err)
+
+		return err
+	}
+}
+
+// lookup finds the mapping for a full method name
+func (i *Interceptor) lookup(fullMethod string) (MethodMapping, bool) {
+	if mapping, ok := i.config.Methods[fullMethod]; ok {
+		return mapping, true
+	}
+
+	if idx := strings.LastIndex(fullMethod, "/"); idx > 0 {
+		if mapping, ok := i.config.Methods[fullMethod[:idx]+"/*"]; ok {
+			return mapping, true
+		}
+	}
+
+	if i.config.DefaultMapping != nil {
+		return *i.config.DefaultMapping, true
+	}
+
+	return MethodMapping{}, false
+}
+
+// record builds and logs the audit entry for a completed RPC
+func (i *Interceptor) record(ctx context.Context, fullMethod string, mapping MethodMapping, req any, start time.Time, rpcErr error) {
+	st := status.Convert(rpcErr)
+
+	builder := audit.NewAuditBuilderWithService(i.service).
+		Action(mapping.Action).
+		Resource(mapping.ResourceType, resourceID(fullMethod, mapping, req), "").
+		IPAddress(PeerAddress(ctx)).
+		UserAgent(firstMetadata(ctx, "user-agent")).
+		Timestamp(start.UTC()).
+		Metadata("grpc_method", fullMethod).
+		Metadata("grpc_code", st.Code().String()).
+		Metadata("duration_ms", time.Since(start).Milliseconds()).
+		Success(rpcErr == nil)
+
+	actor := i.config.ActorFunc(ctx)
+	builder.ActorWithSession(actor.ID, actor.Type, actor.Name, actor.SessionID)
+
+	if rpcErr != nil {
+		msg := st.Message()
+		if msg == "" {
+			msg = st.Code().String()
+		}
+		builder.Error(errorMessage(msg))
+	}
+
+	// The RPC context may already be cancelled by the client; the audit
+	// entry must still be written.
+	logCtx := context.WithoutCancel(ctx)
+	if err := builder.Log(logCtx); err != nil {
+		i.config.ErrorHandler(logCtx, builder.Build(), err)
+	}
+}
+
+// logError is the default ErrorHandler
+func logError(ctx context.Context, entry audit.AuditEntry, err error) {
+	slog.ErrorContext(ctx, "failed to log audit entry",
+		slog.String("grpc_method", fmt.Sprint(entry.Metadata["grpc_method"])),
+		slog.String("actor_id", entry.Actor.ID),
+		slog.String("error", err.Error()))
+}
+
+// resourceID resolves the resource identifier for an RPC
+func resourceID(fullMethod string, mapping MethodMapping, req any) string {
+	if mapping.ResourceID != nil && req != nil {
+		if id := mapping.ResourceID(req); id != "" {
+			return id
+		}
+	}
+
+	if getter, ok := req.(interface{ GetId() string }); ok {
+		if id := getter.GetId(); id != "" {
+			return id
+		}
+	}
+
+	return fullMethod
+}
+
+// recordingStream captures the first message received from the client
+type recordingStream struct {
+	grpc.ServerStream
+	first any
+}
+
+func (s *recordingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.first == nil {
+		s.first = m
+	}
+	return err
+}
+
+// errorMessage adapts a status message to the error interface
+type errorMessage string
+
+func (e errorMessage) Error() string {
+	return string(e)
+}
@@ -0,0 +1,377 @@
+package auditgrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/Doraverse-Workspace/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// memoryRepository records inserted entries
+type memoryRepository struct {
+	audit.AuditRepository
+
+	mu      sync.Mutex
+	entries []audit.AuditEntry
+	err     error
+}
+
+func (r *memoryRepository) Insert(ctx context.Context, entry audit.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *memoryRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// logged returns the entries inserted so far
+func (r *memoryRepository) logged() []audit.AuditEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]audit.AuditEntry(nil), r.entries...)
+}
+
+// healthServer answers for the "db" service, fails "missing" with NotFound
+// and "denied" with PermissionDenied
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (healthServer) check(service string) (*healthpb.HealthCheckResponse, error) {
+	switch service {
+	case "missing":
+		return nil, status.Error(codes.NotFound, "unknown service")
+	case "denied":
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (h healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	return h.check(req.GetService())
+}
+
+func (h healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	resp, err := h.check(req.GetService())
+	if err != nil {
+		return err
+	}
+	return stream.Send(resp)
+}
+
+// healthService extracts the resource ID of health RPCs
+func healthService(req any) string {
+	if r, ok := req.(*healthpb.HealthCheckRequest); ok {
+		return r.GetService()
+	}
+	return ""
+}
+
+// startServer serves the health service through the interceptors over an
+// in-process connection and returns a client
+func startServer(t *testing.T, repo *memoryRepository, config Config) healthpb.HealthClient {
+	t.Helper()
+
+	interceptor := NewInterceptor(audit.NewServiceWithRepository(repo), config)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptor.Unary()),
+		grpc.ChainStreamInterceptor(interceptor.Stream()),
+	)
+	healthpb.RegisterHealthServer(server, healthServer{})
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+// healthConfig maps Check explicitly and Watch through the service wildcard
+func healthConfig() Config {
+	return Config{
+		Methods: map[string]MethodMapping{
+			healthpb.Health_Check_FullMethodName: {Action: audit.ActionView, ResourceType: "health", ResourceID: healthService},
+			"/grpc.health.v1.Health/*":           {Action: audit.ActionExport, ResourceType: "health_stream", ResourceID: healthService},
+		},
+	}
+}
+
+// watch runs a Watch RPC to completion and returns its final error
+func watch(client healthpb.HealthClient, ctx context.Context, service string) error {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	repo := &memoryRepository{}
+	client := startServer(t, repo, healthConfig())
+	ctx := context.Background()
+
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "db"}); err != nil {
+		t.Fatalf("Check(db) failed: %v", err)
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Check(missing) = %v, want NotFound", err)
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "denied"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Check(denied) = %v, want PermissionDenied", err)
+	}
+
+	entries := repo.logged()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	tests := []struct {
+		resourceID string
+		success    bool
+		errorMsg   string
+		code       string
+	}{
+		{"db", true, "", "OK"},
+		{"missing", false, "unknown service", "NotFound"},
+		{"denied", false, "PermissionDenied", "PermissionDenied"},
+	}
+	for i, tt := range tests {
+		entry := entries[i]
+		if entry.Action != audit.ActionView || entry.Resource.Type != "health" || entry.Resource.ID != tt.resourceID {
+			t.Errorf("entry %d: action %q resource %+v, want view health/%s", i, entry.Action, entry.Resource, tt.resourceID)
+		}
+		if entry.Success != tt.success || entry.ErrorMsg != tt.errorMsg {
+			t.Errorf("entry %d: success %v error %q, want %v %q", i, entry.Success, entry.ErrorMsg, tt.success, tt.errorMsg)
+		}
+		if entry.Metadata["grpc_code"] != tt.code || entry.Metadata["grpc_method"] != healthpb.Health_Check_FullMethodName {
+			t.Errorf("entry %d: metadata %v", i, entry.Metadata)
+		}
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	repo := &memoryRepository{}
+	client := startServer(t, repo, healthConfig())
+	ctx := context.Background()
+
+	if err := watch(client, ctx, "db"); err != nil {
+		t.Fatalf("Watch(db) failed: %v", err)
+	}
+	if err := watch(client, ctx, "denied"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Watch(denied) = %v, want PermissionDenied", err)
+	}
+
+	entries := repo.logged()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	ok, denied := entries[0], entries[1]
+	if ok.Action != audit.ActionExport || ok.Resource.Type != "health_stream" || ok.Resource.ID != "db" {
+		t.Errorf("wildcard mapping: action %q resource %+v", ok.Action, ok.Resource)
+	}
+	if !ok.Success || ok.ErrorMsg != "" {
+		t.Errorf("successful stream: success %v error %q", ok.Success, ok.ErrorMsg)
+	}
+	if denied.Success || denied.ErrorMsg != "PermissionDenied" || denied.Metadata["grpc_code"] != "PermissionDenied" {
+		t.Errorf("failed stream: success %v error %q metadata %v", denied.Success, denied.ErrorMsg, denied.Metadata)
+	}
+}
+
+func TestUnmappedMethod(t *testing.T) {
+	repo := &memoryRepository{}
+	client := startServer(t, repo, Config{
+		Methods: map[string]MethodMapping{
+			"/other.v1.Service/*": {Action: audit.ActionView, ResourceType: "other"},
+		},
+	})
+
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "db"}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if entries := repo.logged(); len(entries) != 0 {
+		t.Fatalf("unmapped method logged %d entries", len(entries))
+	}
+
+	// The full method name stands in for a missing resource ID
+	repo = &memoryRepository{}
+	client = startServer(t, repo, Config{DefaultMapping: &MethodMapping{Action: audit.ActionView, ResourceType: "rpc"}})
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	entries := repo.logged()
+	if len(entries) != 1 || entries[0].Resource.ID != healthpb.Health_Check_FullMethodName {
+		t.Fatalf("default mapping entries: %+v", entries)
+	}
+}
+
+func TestActorResolution(t *testing.T) {
+	asserted := metadata.Pairs(
+		MetadataActorID, "user-42",
+		MetadataActorType, string(audit.ActorTypeAdmin),
+		MetadataActorName, "Jane",
+		MetadataSessionID, "sess-1",
+	)
+	peerActor := audit.Actor{ID: "bufconn", Type: audit.ActorTypeAPI}
+
+	tests := []struct {
+		name  string
+		md    metadata.MD
+		trust func(ctx context.Context, asserted audit.Actor) bool
+		want  audit.Actor
+	}{
+		{
+			name: "peer address without metadata",
+			want: peerActor,
+		},
+		{
+			name: "metadata ignored by default",
+			md:   asserted,
+			want: peerActor,
+		},
+		{
+			name:  "metadata rejected by the trust hook",
+			md:    asserted,
+			trust: func(ctx context.Context, a audit.Actor) bool { return a.ID != "user-42" },
+			want:  peerActor,
+		},
+		{
+			name:  "metadata accepted by the trust hook",
+			md:    asserted,
+			trust: func(ctx context.Context, a audit.Actor) bool { return ActorFromContext(ctx) == peerActor },
+			want:  audit.Actor{ID: "user-42", Type: audit.ActorTypeAdmin, Name: "Jane", SessionID: "sess-1"},
+		},
+		{
+			name:  "unknown actor type falls back to the peer",
+			md:    metadata.Pairs(MetadataActorID, "user-42", MetadataActorType, "robot"),
+			trust: func(ctx context.Context, a audit.Actor) bool { return true },
+			want:  peerActor,
+		},
+		{
+			name:  "missing actor type defaults to user",
+			md:    metadata.Pairs(MetadataActorID, "user-42"),
+			trust: func(ctx context.Context, a audit.Actor) bool { return true },
+			want:  audit.Actor{ID: "user-42", Type: audit.ActorTypeUser},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryRepository{}
+			config := healthConfig()
+			config.TrustActorMetadata = tt.trust
+			client := startServer(t, repo, config)
+
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewOutgoingContext(ctx, tt.md)
+			}
+			if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "db"}); err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+
+			entries := repo.logged()
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			if entries[0].Actor != tt.want {
+				t.Errorf("actor = %+v, want %+v", entries[0].Actor, tt.want)
+			}
+		})
+	}
+}
+
+func TestActorFromVerifiedCertificate(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 443},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}},
+	})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataActorID, "spoofed"))
+
+	actor := ActorFromContext(ctx)
+	if actor.ID != "billing" || actor.Type != audit.ActorTypeService || actor.Name != cert.Subject.String() {
+		t.Errorf("actor = %+v, want the certificate's service actor", actor)
+	}
+
+	// Unverified certificates do not identify the peer
+	ctx = peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 443},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+		}},
+	})
+	if actor := ActorFromContext(ctx); actor.ID != "10.0.0.7" || actor.Type != audit.ActorTypeAPI {
+		t.Errorf("actor = %+v, want the peer address", actor)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	repo := &memoryRepository{err: errors.New("storage down")}
+	var (
+		mu     sync.Mutex
+		failed []error
+	)
+	config := healthConfig()
+	config.ErrorHandler = func(ctx context.Context, entry audit.AuditEntry, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, err)
+	}
+	client := startServer(t, repo, config)
+
+	// Audit failures never affect the RPC
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "db"}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 {
+		t.Fatalf("ErrorHandler called %d times, want 1", len(failed))
+	}
+}
@@ -0,0 +1,106 @@
+// Package auditotel integrates the audit module with OpenTelemetry. Entries
+// logged through an AuditBuilder pick up the trace and span IDs of the active
+// span on their own; this package wraps an AuditService so that entries passed
+// to LogAction directly pick them up too, and wraps services and repositories
+// to emit spans and metrics around audit operations and storage calls.
+//
+// Basic usage:
+//
+//	repo, err := audit.NewMongoRepository(config)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	repo, err = auditotel.WrapRepository(repo, auditotel.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	service, err := auditotel.WrapService(audit.NewServiceWithRepository(repo), auditotel.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	audit.SetDefaultService(service)
+package auditotel
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer and meter of this package
+const instrumentationName = "github.com/Doraverse-Workspace/audit/auditotel"
+
+// Config represents the configuration for the OpenTelemetry wrappers
+type Config struct {
+	// TracerProvider defaults to the global tracer provider
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider defaults to the global meter provider
+	MeterProvider metric.MeterProvider
+}
+
+// instruments holds the tracer and metric instruments shared by the wrappers
+type instruments struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	entries  metric.Int64Counter
+}
+
+// newInstruments creates the tracer and metric instruments
+func newInstruments(config Config) (*instruments, error) {
+	tp := config.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	mp := config.MeterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+
+	meter := mp.Meter(instrumentationName)
+
+	duration, err := meter.Float64Histogram("audit.operation.duration",
+		metric.WithDescription("Duration of audit service and repository operations"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := meter.Int64Counter("audit.entries",
+		metric.WithDescription("Number of audit entries logged"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &instruments{
+		tracer:   tp.Tracer(instrumentationName),
+		duration: duration,
+		entries:  entries,
+	}, nil
+}
+
+// start begins a span for an operation and returns a function that ends it,
+// recording the error and the operation duration
+func (in *instruments) start(ctx context.Context, operation string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := in.tracer.Start(ctx, operation, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		in.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("audit.operation", operation),
+			attribute.Bool("error", err != nil),
+		))
+	}
+}
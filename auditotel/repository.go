@@ -0,0 +1,88 @@
+package auditotel
+
+import (
+	"context"
+
+	"github.com/Doraverse-Workspace/audit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedRepository wraps an AuditRepository with tracing and metrics
+type tracedRepository struct {
+	repo audit.AuditRepository
+	in   *instruments
+}
+
+// WrapRepository wraps an AuditRepository so that every storage call emits a
+// client span and a duration metric
+func WrapRepository(repo audit.AuditRepository, config Config) (audit.AuditRepository, error) {
+	in, err := newInstruments(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracedRepository{
+		repo: repo,
+		in:   in,
+	}, nil
+}
+
+// Insert inserts a new audit entry
+func (r *tracedRepository) Insert(ctx context.Context, entry audit.AuditEntry) error {
+	ctx, end := r.in.start(ctx, "audit.repository.Insert", trace.SpanKindClient,
+		attribute.String("audit.action", string(entry.Action)))
+	err := r.repo.Insert(ctx, entry)
+	end(err)
+	return err
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *tracedRepository) FindByQuery(ctx context.Context, query audit.AuditQuery) (*audit.AuditQueryResult, error) {
+	ctx, end := r.in.start(ctx, "audit.repository.FindByQuery", trace.SpanKindClient)
+	result, err := r.repo.FindByQuery(ctx, query)
+	end(err)
+	return result, err
+}
+
+// FindByID finds an audit entry by its ID
+func (r *tracedRepository) FindByID(ctx context.Context, id string) (*audit.AuditEntry, error) {
+	ctx, end := r.in.start(ctx, "audit.repository.FindByID", trace.SpanKindClient)
+	entry, err := r.repo.FindByID(ctx, id)
+	end(err)
+	return entry, err
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *tracedRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]audit.AuditEntry, error) {
+	ctx, end := r.in.start(ctx, "audit.repository.FindByResource", trace.SpanKindClient)
+	entries, err := r.repo.FindByResource(ctx, resourceType, resourceID, limit)
+	end(err)
+	return entries, err
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *tracedRepository) FindByActor(ctx context.Context, actorID string, actorType audit.ActorType, limit int) ([]audit.AuditEntry, error) {
+	ctx, end := r.in.start(ctx, "audit.repository.FindByActor", trace.SpanKindClient)
+	entries, err := r.repo.FindByActor(ctx, actorID, actorType, limit)
+	end(err)
+	return entries, err
+}
+
+// EnsureIndexes creates necessary database indexes
+func (r *tracedRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, end := r.in.start(ctx, "audit.repository.EnsureIndexes", trace.SpanKindClient)
+	err := r.repo.EnsureIndexes(ctx)
+	end(err)
+	return err
+}
+
+// Unwrap returns the wrapped repository
+func (r *tracedRepository) Unwrap() audit.AuditRepository {
+	return r.repo
+}
+
+// Close closes the repository connection
+func (r *tracedRepository) Close(ctx context.Context) error {
+	return r.repo.Close(ctx)
+}
@@ -0,0 +1,126 @@
+package auditotel
+
+import (
+	"context"
+
+	"github.com/Doraverse-Workspace/audit"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedService wraps an AuditService with tracing and metrics
+type tracedService struct {
+	audit.AuditService
+	in *instruments
+}
+
+// WrapService wraps an AuditService so that every operation emits a span and
+// a duration metric, and logged entries without a trace ID inherit the trace
+// and span IDs of the span active in the caller's context.
+func WrapService(service audit.AuditService, config Config) (audit.AuditService, error) {
+	in, err := newInstruments(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracedService{
+		AuditService: service,
+		in:           in,
+	}, nil
+}
+
+// LogAction logs an audit entry
+func (s *tracedService) LogAction(ctx context.Context, entry audit.AuditEntry) error {
+	// Record the caller's span, not the span created for the audit write
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && entry.TraceID == "" {
+		entry.TraceID = sc.TraceID().String()
+		entry.SpanID = sc.SpanID().String()
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("audit.action", string(entry.Action)),
+		attribute.String("audit.actor.type", string(entry.Actor.Type)),
+		attribute.String("audit.resource.type", entry.Resource.Type),
+	}
+
+	ctx, end := s.in.start(ctx, "audit.LogAction", trace.SpanKindInternal, attrs...)
+	err := s.AuditService.LogAction(ctx, entry)
+	end(err)
+
+	s.in.entries.Add(ctx, 1, metric.WithAttributes(append(attrs,
+		attribute.Bool("audit.success", entry.Success),
+		attribute.Bool("error", err != nil),
+	)...))
+
+	return err
+}
+
+// LogActionInSession logs an audit entry within the caller's MongoDB
+// transaction, if the wrapped service supports it
+func (s *tracedService) LogActionInSession(sc mongo.SessionContext, entry audit.AuditEntry) error {
+	service, ok := s.AuditService.(audit.SessionService)
+	if !ok {
+		return audit.ErrTransactionsUnsupported{}
+	}
+
+	if span := trace.SpanContextFromContext(sc); span.IsValid() && entry.TraceID == "" {
+		entry.TraceID = span.TraceID().String()
+		entry.SpanID = span.SpanID().String()
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("audit.action", string(entry.Action)),
+		attribute.String("audit.actor.type", string(entry.Actor.Type)),
+		attribute.String("audit.resource.type", entry.Resource.Type),
+	}
+
+	// The span context must not replace sc, which carries the session
+	_, end := s.in.start(sc, "audit.LogActionInSession", trace.SpanKindInternal, attrs...)
+	err := service.LogActionInSession(sc, entry)
+	end(err)
+
+	s.in.entries.Add(sc, 1, metric.WithAttributes(append(attrs,
+		attribute.Bool("audit.success", entry.Success),
+		attribute.Bool("error", err != nil),
+	)...))
+
+	return err
+}
+
+// GetHistory retrieves audit history based on query parameters
+func (s *tracedService) GetHistory(ctx context.Context, query audit.AuditQuery) (*audit.AuditQueryResult, error) {
+	ctx, end := s.in.start(ctx, "audit.GetHistory", trace.SpanKindInternal,
+		attribute.Int("audit.query.limit", query.Limit),
+		attribute.Int("audit.query.offset", query.Offset))
+	result, err := s.AuditService.GetHistory(ctx, query)
+	end(err)
+	return result, err
+}
+
+// GetByID retrieves an audit entry by its ID
+func (s *tracedService) GetByID(ctx context.Context, id string) (*audit.AuditEntry, error) {
+	ctx, end := s.in.start(ctx, "audit.GetByID", trace.SpanKindInternal)
+	entry, err := s.AuditService.GetByID(ctx, id)
+	end(err)
+	return entry, err
+}
+
+// GetResourceHistory retrieves audit history for a specific resource
+func (s *tracedService) GetResourceHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]audit.AuditEntry, error) {
+	ctx, end := s.in.start(ctx, "audit.GetResourceHistory", trace.SpanKindInternal,
+		attribute.String("audit.resource.type", resourceType))
+	entries, err := s.AuditService.GetResourceHistory(ctx, resourceType, resourceID, limit)
+	end(err)
+	return entries, err
+}
+
+// GetActorHistory retrieves audit history for a specific actor
+func (s *tracedService) GetActorHistory(ctx context.Context, actorID string, actorType audit.ActorType, limit int) ([]audit.AuditEntry, error) {
+	ctx, end := s.in.start(ctx, "audit.GetActorHistory", trace.SpanKindInternal,
+		attribute.String("audit.actor.type", string(actorType)))
+	entries, err := s.AuditService.GetActorHistory(ctx, actorID, actorType, limit)
+	end(err)
+	return entries, err
+}
@@ -0,0 +1,159 @@
+package auditotel
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Doraverse-Workspace/audit"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryRepository records inserted entries
+type memoryRepository struct {
+	audit.AuditRepository
+
+	mu      sync.Mutex
+	entries []audit.AuditEntry
+}
+
+func (r *memoryRepository) Insert(ctx context.Context, entry audit.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+// last returns the last inserted entry
+func (r *memoryRepository) last(t *testing.T) audit.AuditEntry {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) == 0 {
+		t.Fatal("no entry logged")
+	}
+	return r.entries[len(r.entries)-1]
+}
+
+// newTracer returns a tracer provider exporting spans to memory
+func newTracer() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func TestBuilderInheritsActiveSpan(t *testing.T) {
+	tp, exporter := newTracer()
+	repo := &memoryRepository{}
+	service := audit.NewServiceWithRepository(repo)
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "handler")
+	caller := span.SpanContext()
+
+	newEntry := func() *audit.AuditBuilder {
+		return audit.NewAuditBuilderWithService(service).
+			Update().
+			User("user123", "").
+			Resource("document", "doc1", "").
+			Success(true)
+	}
+
+	if err := newEntry().Log(ctx); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	entry := repo.last(t)
+	if entry.TraceID != caller.TraceID().String() || entry.SpanID != caller.SpanID().String() {
+		t.Errorf("entry trace %s/%s, want %s/%s", entry.TraceID, entry.SpanID, caller.TraceID(), caller.SpanID())
+	}
+
+	// Explicit trace IDs are kept
+	if err := newEntry().Trace("explicit-trace", "explicit-span").Log(ctx); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if entry := repo.last(t); entry.TraceID != "explicit-trace" || entry.SpanID != "explicit-span" {
+		t.Errorf("explicit trace overwritten: %s/%s", entry.TraceID, entry.SpanID)
+	}
+
+	// A child of an entry of the same trace records the current span
+	parent := entry
+	parent.SpanID = ""
+	if err := newEntry().ChildOf(parent).Log(ctx); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if entry := repo.last(t); entry.TraceID != caller.TraceID().String() || entry.SpanID != caller.SpanID().String() {
+		t.Errorf("child entry trace %s/%s", entry.TraceID, entry.SpanID)
+	}
+
+	// Without an active span no IDs are set
+	if err := newEntry().Log(context.Background()); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if entry := repo.last(t); entry.TraceID != "" || entry.SpanID != "" {
+		t.Errorf("entry without span has trace %s/%s", entry.TraceID, entry.SpanID)
+	}
+
+	span.End()
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].SpanContext.SpanID() != caller.SpanID() {
+		t.Fatalf("exported spans: %+v", spans)
+	}
+}
+
+func TestWrapServiceRecordsCallerSpan(t *testing.T) {
+	tp, exporter := newTracer()
+	config := Config{TracerProvider: tp}
+
+	repo := &memoryRepository{}
+	tracedRepo, err := WrapRepository(repo, config)
+	if err != nil {
+		t.Fatalf("WrapRepository failed: %v", err)
+	}
+	service, err := WrapService(audit.NewServiceWithRepository(tracedRepo), config)
+	if err != nil {
+		t.Fatalf("WrapService failed: %v", err)
+	}
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "handler")
+	caller := span.SpanContext()
+
+	entry := audit.NewAuditBuilder().
+		Create().
+		User("user123", "").
+		Resource("document", "doc1", "").
+		Success(true).
+		Build()
+	if err := service.LogAction(ctx, entry); err != nil {
+		t.Fatalf("LogAction failed: %v", err)
+	}
+	span.End()
+
+	// The entry records the caller's span, not the span of the audit write
+	logged := repo.last(t)
+	if logged.TraceID != caller.TraceID().String() || logged.SpanID != caller.SpanID().String() {
+		t.Errorf("entry trace %s/%s, want %s/%s", logged.TraceID, logged.SpanID, caller.TraceID(), caller.SpanID())
+	}
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		byName[s.Name] = s
+	}
+
+	logSpan, ok := byName["audit.LogAction"]
+	if !ok {
+		t.Fatalf("no audit.LogAction span in %v", byName)
+	}
+	if logSpan.Parent.SpanID() != caller.SpanID() || logSpan.SpanKind != trace.SpanKindInternal {
+		t.Errorf("audit.LogAction parent %s kind %s", logSpan.Parent.SpanID(), logSpan.SpanKind)
+	}
+
+	insertSpan, ok := byName["audit.repository.Insert"]
+	if !ok {
+		t.Fatalf("no audit.repository.Insert span in %v", byName)
+	}
+	if insertSpan.Parent.SpanID() != logSpan.SpanContext.SpanID() || insertSpan.SpanKind != trace.SpanKindClient {
+		t.Errorf("audit.repository.Insert parent %s kind %s", insertSpan.Parent.SpanID(), insertSpan.SpanKind)
+	}
+}
@@ -0,0 +1,362 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitState represents the state of a circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // calls pass through
+	CircuitOpen     CircuitState = "open"      // calls are rejected immediately
+	CircuitHalfOpen CircuitState = "half_open" // a limited number of trial calls pass through
+)
+
+// CircuitBreakerConfig represents the configuration of a circuit breaker
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // consecutive failures that open the circuit
+	OpenTimeout      time.Duration // time spent open before trial calls are allowed
+	HalfOpenMaxCalls int           // concurrent trial calls allowed while half-open
+	SuccessThreshold int           // consecutive successful trials that close the circuit
+
+	// OnStateChange is called after every state transition. Optional.
+	OnStateChange func(from, to CircuitState)
+
+	// IsFailure classifies call errors as backend failures. Defaults to
+	// IsRetryableError, so that invalid IDs, missing tenants and other
+	// caller errors cannot open the circuit. Errors that are not failures
+	// are ignored rather than counted as successes.
+	IsFailure func(err error) bool
+}
+
+// DefaultCircuitBreakerConfig returns a default circuit breaker configuration
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 2,
+	}
+}
+
+// CircuitBreaker stops calls to a failing backend for a while so that callers
+// fail fast instead of waiting through retries
+type CircuitBreaker struct {
+	mu     sync.Mutex
+	config CircuitBreakerConfig
+
+	state     CircuitState
+	failures  int // consecutive failures while closed
+	successes int // consecutive successes while half-open
+	trials    int // trial calls in flight while half-open
+	openedAt  time.Time
+
+	// generation is incremented on every transition so that the outcome of
+	// a call admitted in an earlier state is ignored
+	generation uint64
+
+	rejected atomic.Int64
+}
+
+// NewCircuitBreaker creates a new circuit breaker in the closed state. Zero
+// values in the configuration are replaced by their defaults.
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	defaults := DefaultCircuitBreakerConfig()
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaults.OpenTimeout
+	}
+	if config.HalfOpenMaxCalls <= 0 {
+		config.HalfOpenMaxCalls = defaults.HalfOpenMaxCalls
+	}
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = defaults.SuccessThreshold
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = IsRetryableError
+	}
+
+	return &CircuitBreaker{
+		config: config,
+		state:  CircuitClosed,
+	}
+}
+
+// ErrCircuitOpen represents a call rejected because the circuit is open
+type ErrCircuitOpen struct{}
+
+func (e ErrCircuitOpen) Error() string {
+	return "audit storage circuit breaker is open"
+}
+
+// Execute runs op if the circuit allows it and records the outcome
+func (cb *CircuitBreaker) Execute(op func() error) error {
+	return cb.execute(context.Background(), op)
+}
+
+// execute runs op like Execute. An error returned once ctx is done is
+// ignored: the caller gave up, which says nothing about the backend.
+func (cb *CircuitBreaker) execute(ctx context.Context, op func() error) error {
+	generation, err := cb.acquire()
+	if err != nil {
+		return err
+	}
+
+	err = op()
+	switch {
+	case err == nil:
+		cb.release(generation, callSucceeded)
+	case ctx.Err() == nil && cb.config.IsFailure(err):
+		cb.release(generation, callFailed)
+	default:
+		cb.release(generation, callIgnored)
+	}
+	return err
+}
+
+// callOutcome is the outcome of a call admitted by a circuit breaker
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	callIgnored // neither a success nor a backend failure
+)
+
+// State returns the current state of the circuit
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.config.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// Rejected returns the number of calls rejected while the circuit was open
+func (cb *CircuitBreaker) Rejected() int64 {
+	return cb.rejected.Load()
+}
+
+// acquire admits a call or rejects it with ErrCircuitOpen. It returns the
+// generation the call was admitted in.
+func (cb *CircuitBreaker) acquire() (uint64, error) {
+	cb.mu.Lock()
+
+	var from CircuitState
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.config.OpenTimeout {
+		from = cb.transition(CircuitHalfOpen)
+	}
+
+	admitted := true
+	switch cb.state {
+	case CircuitOpen:
+		admitted = false
+	case CircuitHalfOpen:
+		if cb.trials >= cb.config.HalfOpenMaxCalls {
+			admitted = false
+		} else {
+			cb.trials++
+		}
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
+
+	cb.notify(from, CircuitHalfOpen)
+	if !admitted {
+		cb.rejected.Add(1)
+		return 0, ErrCircuitOpen{}
+	}
+	return generation, nil
+}
+
+// release records the outcome of an admitted call. Outcomes of calls
+// admitted before the last transition are ignored: they neither count
+// towards the new state nor release one of its trials.
+func (cb *CircuitBreaker) release(generation uint64, outcome callOutcome) {
+	cb.mu.Lock()
+	if generation != cb.generation {
+		cb.mu.Unlock()
+		return
+	}
+
+	var from, to CircuitState
+	switch cb.state {
+	case CircuitClosed:
+		switch outcome {
+		case callSucceeded:
+			cb.failures = 0
+		case callFailed:
+			if cb.failures++; cb.failures >= cb.config.FailureThreshold {
+				from, to = cb.transition(CircuitOpen), CircuitOpen
+			}
+		}
+	case CircuitHalfOpen:
+		cb.trials--
+		switch outcome {
+		case callSucceeded:
+			if cb.successes++; cb.successes >= cb.config.SuccessThreshold {
+				from, to = cb.transition(CircuitClosed), CircuitClosed
+			}
+		case callFailed:
+			from, to = cb.transition(CircuitOpen), CircuitOpen
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// transition switches state, resets counters and returns the previous state.
+// Must be called with mu held.
+func (cb *CircuitBreaker) transition(to CircuitState) CircuitState {
+	from := cb.state
+	cb.state = to
+	cb.failures = 0
+	cb.successes = 0
+	cb.trials = 0
+	cb.generation++
+	if to == CircuitOpen {
+		cb.openedAt = time.Now()
+	}
+	return from
+}
+
+// notify reports a transition to the state change callback
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from == "" || from == to || cb.config.OnStateChange == nil {
+		return
+	}
+	cb.config.OnStateChange(from, to)
+}
+
+// InsertFallback handles an entry that was not written because the circuit is open
+type InsertFallback func(ctx context.Context, entry AuditEntry, err error) error
+
+// SpoolFallback returns a fallback that appends rejected entries to a spool.
+// Replay them with Spool.Replay once the circuit has closed.
+func SpoolFallback(spool *Spool) InsertFallback {
+	return func(ctx context.Context, entry AuditEntry, err error) error {
+		if spoolErr := spool.Append(entry); spoolErr != nil {
+			return fmt.Errorf("%w (spool: %v)", err, spoolErr)
+		}
+		return nil
+	}
+}
+
+// DropFallback returns a fallback that discards rejected entries and counts
+// them in dropped
+func DropFallback(dropped *atomic.Int64) InsertFallback {
+	return func(ctx context.Context, entry AuditEntry, err error) error {
+		dropped.Add(1)
+		return nil
+	}
+}
+
+// breakerRepository guards an AuditRepository with a circuit breaker
+type breakerRepository struct {
+	repo     AuditRepository
+	breaker  *CircuitBreaker
+	fallback InsertFallback
+}
+
+// NewCircuitBreakerRepository wraps a repository with a circuit breaker. All
+// calls are rejected with ErrCircuitOpen while the circuit is open; rejected
+// inserts are passed to fallback if it is not nil.
+func NewCircuitBreakerRepository(repo AuditRepository, breaker *CircuitBreaker, fallback InsertFallback) AuditRepository {
+	return &breakerRepository{
+		repo:     repo,
+		breaker:  breaker,
+		fallback: fallback,
+	}
+}
+
+// WithCircuitBreaker guards the service's repository with a circuit breaker
+func WithCircuitBreaker(breaker *CircuitBreaker, fallback InsertFallback) ServiceOption {
+	return func(s *auditService) {
+		s.repo = NewCircuitBreakerRepository(s.repo, breaker, fallback)
+	}
+}
+
+// Insert inserts a new audit entry
+func (r *breakerRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	err := r.breaker.execute(ctx, func() error {
+		return r.repo.Insert(ctx, entry)
+	})
+
+	var open ErrCircuitOpen
+	if errors.As(err, &open) && r.fallback != nil {
+		return r.fallback(ctx, entry, err)
+	}
+	return err
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *breakerRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	var result *AuditQueryResult
+	err := r.breaker.execute(ctx, func() error {
+		var err error
+		result, err = r.repo.FindByQuery(ctx, query)
+		return err
+	})
+	return result, err
+}
+
+// FindByID finds an audit entry by its ID
+func (r *breakerRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	var entry *AuditEntry
+	err := r.breaker.execute(ctx, func() error {
+		var err error
+		entry, err = r.repo.FindByID(ctx, id)
+		return err
+	})
+	return entry, err
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *breakerRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := r.breaker.execute(ctx, func() error {
+		var err error
+		entries, err = r.repo.FindByResource(ctx, resourceType, resourceID, limit)
+		return err
+	})
+	return entries, err
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *breakerRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := r.breaker.execute(ctx, func() error {
+		var err error
+		entries, err = r.repo.FindByActor(ctx, actorID, actorType, limit)
+		return err
+	})
+	return entries, err
+}
+
+// EnsureIndexes creates necessary database indexes
+func (r *breakerRepository) EnsureIndexes(ctx context.Context) error {
+	return r.breaker.execute(ctx, func() error {
+		return r.repo.EnsureIndexes(ctx)
+	})
+}
+
+// Unwrap returns the wrapped repository
+func (r *breakerRepository) Unwrap() AuditRepository {
+	return r.repo
+}
+
+// Close closes the repository connection
+func (r *breakerRepository) Close(ctx context.Context) error {
+	return r.repo.Close(ctx)
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errBackend is a transient backend error
+var errBackend = fmt.Errorf("backend unavailable: %w", context.DeadlineExceeded)
+
+// newTestBreaker returns a breaker recording its transitions
+func newTestBreaker(config CircuitBreakerConfig) (*CircuitBreaker, func() []string) {
+	var mu sync.Mutex
+	var transitions []string
+	config.OnStateChange = func(from, to CircuitState) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, fmt.Sprintf("%s->%s", from, to))
+	}
+	return NewCircuitBreaker(config), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(transitions)
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	breaker, transitions := newTestBreaker(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 2,
+	})
+	fail := func() error { return errBackend }
+	succeed := func() error { return nil }
+
+	breaker.Execute(fail)
+	breaker.Execute(succeed)
+	breaker.Execute(fail)
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state %s after non-consecutive failures, want closed", state)
+	}
+	breaker.Execute(fail)
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state %s after consecutive failures, want open", state)
+	}
+
+	var open ErrCircuitOpen
+	if err := breaker.Execute(succeed); !errors.As(err, &open) || breaker.Rejected() != 1 {
+		t.Fatalf("Execute while open = %v with %d rejected, want ErrCircuitOpen", err, breaker.Rejected())
+	}
+
+	// A failed trial opens the circuit again
+	time.Sleep(20 * time.Millisecond)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("state %s after the open timeout, want half-open", state)
+	}
+	breaker.Execute(fail)
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state %s after a failed trial, want open", state)
+	}
+
+	// Only HalfOpenMaxCalls trials run at once
+	time.Sleep(20 * time.Millisecond)
+	breaker.Execute(func() error {
+		if err := breaker.Execute(succeed); !errors.As(err, &open) {
+			t.Errorf("concurrent trial = %v, want ErrCircuitOpen", err)
+		}
+		return nil
+	})
+	breaker.Execute(succeed)
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state %s after successful trials, want closed", state)
+	}
+
+	want := []string{
+		"closed->open", "open->half_open", "half_open->open",
+		"open->half_open", "half_open->closed",
+	}
+	if got := transitions(); !slices.Equal(got, want) {
+		t.Errorf("transitions %v, want %v", got, want)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallerErrors(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2})
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	breaker.Execute(func() error { return errBackend })
+	for _, err := range []error{
+		ErrTenantRequired{Operation: "find audit entries"},
+		fmt.Errorf("invalid ID format: %w", errors.New("the provided hex string is not a valid ObjectID")),
+		ErrDuplicateEntry{ID: "1"},
+		context.Canceled,
+	} {
+		breaker.Execute(func() error { return err })
+	}
+	// The caller's own deadline is not a backend failure
+	breaker.execute(expired, func() error { return expired.Err() })
+
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state %s after caller errors, want closed", state)
+	}
+
+	// Ignored errors do not reset the consecutive failures either
+	breaker.Execute(func() error { return errBackend })
+	if state := breaker.State(); state != CircuitOpen {
+		t.Errorf("state %s after a second backend failure, want open", state)
+	}
+}
+
+func TestCircuitBreakerIgnoresStaleOutcomes(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      10 * time.Millisecond,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 1,
+	})
+
+	// A slow call is admitted while closed, then another call opens the circuit
+	slow, err := breaker.acquire()
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	breaker.Execute(func() error { return errBackend })
+
+	time.Sleep(10 * time.Millisecond)
+	trial, err := breaker.acquire()
+	if err != nil {
+		t.Fatalf("trial acquire failed: %v", err)
+	}
+
+	// The slow call's success neither closes the circuit nor frees the trial
+	breaker.release(slow, callSucceeded)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("state %s after a stale success, want half-open", state)
+	}
+	if _, err := breaker.acquire(); err == nil {
+		t.Fatal("a second trial was admitted")
+	}
+
+	breaker.release(trial, callSucceeded)
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state %s after the trial succeeded, want closed", state)
+	}
+}
@@ -5,23 +5,18 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AuditBuilder provides a fluent interface for building and logging audit entries
 type AuditBuilder struct {
 	entry   AuditEntry
-	service AuditService
+	service AuditService // nil to use the service of the context passed to Log
 }
 
-// defaultService is the global audit service instance
-var defaultService AuditService
-
-// SetDefaultService sets the global audit service instance
-func SetDefaultService(service AuditService) {
-	defaultService = service
-}
-
-// NewAuditBuilder creates a new audit builder
+// NewAuditBuilder creates a new audit builder. Log uses the service bound to
+// its context, see ContextWithService, or else the default service.
 func NewAuditBuilder() *AuditBuilder {
 	return &AuditBuilder{
 		entry: AuditEntry{
@@ -29,7 +24,6 @@ func NewAuditBuilder() *AuditBuilder {
 			Timestamp: time.Now().UTC(),
 			Metadata:  make(map[string]any),
 		},
-		service: defaultService,
 	}
 }
 
@@ -101,6 +95,20 @@ func (b *AuditBuilder) AddChange(field string, oldValue, newValue any) *AuditBui
 	return b
 }
 
+// Diff adds a field change for every difference between two versions of a
+// struct or map, as computed by the package-level Diff function
+func (b *AuditBuilder) Diff(oldValue, newValue any) *AuditBuilder {
+	changes := Diff(oldValue, newValue)
+	if len(changes) == 0 {
+		return b
+	}
+	if b.entry.Changes == nil {
+		b.entry.Changes = make([]FieldChange, 0, len(changes))
+	}
+	b.entry.Changes = append(b.entry.Changes, changes...)
+	return b
+}
+
 // Metadata adds metadata to the audit entry
 func (b *AuditBuilder) Metadata(key string, value any) *AuditBuilder {
 	if b.entry.Metadata == nil {
@@ -143,17 +151,88 @@ func (b *AuditBuilder) Timestamp(timestamp time.Time) *AuditBuilder {
 	return b
 }
 
+// Correlation sets the correlation ID shared by all entries of one request or workflow
+func (b *AuditBuilder) Correlation(correlationID string) *AuditBuilder {
+	b.entry.CorrelationID = correlationID
+	return b
+}
+
+// Trace sets the distributed trace and span IDs
+func (b *AuditBuilder) Trace(traceID, spanID string) *AuditBuilder {
+	b.entry.TraceID = traceID
+	b.entry.SpanID = spanID
+	return b
+}
+
+// Parent sets the ID of the entry that caused this one
+func (b *AuditBuilder) Parent(parentID primitive.ObjectID) *AuditBuilder {
+	b.entry.ParentID = &parentID
+	return b
+}
+
+// ChildOf links the entry to a parent entry, inheriting its correlation and trace IDs
+func (b *AuditBuilder) ChildOf(parent AuditEntry) *AuditBuilder {
+	b.Parent(parent.ID)
+	b.entry.CorrelationID = parent.CorrelationID
+	b.entry.TraceID = parent.TraceID
+	return b
+}
+
 // Build returns the built audit entry without logging it
 func (b *AuditBuilder) Build() AuditEntry {
 	return b.entry
 }
 
-// Log logs the audit entry using the configured service
+// Log logs the audit entry using the configured service. An entry without
+// trace IDs inherits those of the OpenTelemetry span active in ctx.
 func (b *AuditBuilder) Log(ctx context.Context) error {
-	if b.service == nil {
-		return ErrNoServiceConfigured{}
+	service, err := b.serviceFor(ctx)
+	if err != nil {
+		return err
+	}
+	return service.LogAction(ctx, b.entryFor(ctx))
+}
+
+// LogInSession logs the audit entry within the caller's MongoDB transaction.
+// An entry without trace IDs inherits those of the OpenTelemetry span active
+// in sc.
+func (b *AuditBuilder) LogInSession(sc mongo.SessionContext) error {
+	service, err := b.serviceFor(sc)
+	if err != nil {
+		return err
+	}
+	sessionService, ok := service.(SessionService)
+	if !ok {
+		return ErrTransactionsUnsupported{}
+	}
+	return sessionService.LogActionInSession(sc, b.entryFor(sc))
+}
+
+// entryFor returns the built entry with the trace and span IDs of the span
+// active in ctx filled in, unless the entry belongs to another trace
+func (b *AuditBuilder) entryFor(ctx context.Context) AuditEntry {
+	entry := b.entry
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return entry
+	}
+
+	traceID := sc.TraceID().String()
+	if entry.TraceID == "" {
+		entry.TraceID = traceID
+	}
+	if entry.SpanID == "" && entry.TraceID == traceID {
+		entry.SpanID = sc.SpanID().String()
+	}
+	return entry
+}
+
+// serviceFor returns the builder's own service, or else the service of ctx
+func (b *AuditBuilder) serviceFor(ctx context.Context) (AuditService, error) {
+	if b.service != nil {
+		return b.service, nil
 	}
-	return b.service.LogAction(ctx, b.entry)
+	return serviceFor(ctx)
 }
 
 // Convenience methods for common actor types
@@ -234,5 +313,5 @@ func (b *AuditBuilder) Export() *AuditBuilder {
 type ErrNoServiceConfigured struct{}
 
 func (e ErrNoServiceConfigured) Error() string {
-	return "no audit service configured: use SetDefaultService() or NewAuditBuilderWithService()"
+	return "no audit service configured: use SetDefaultService(), ContextWithService() or NewAuditBuilderWithService()"
 }
@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalesceConfig represents the configuration of a coalescer
+type CoalesceConfig struct {
+	// Window is how long the first of identical entries is held while later
+	// ones are merged into it
+	Window time.Duration `json:"window" yaml:"window"`
+
+	// Actions limits coalescing to the given actions, e.g. ActionView.
+	// Empty coalesces every action.
+	Actions []AuditAction `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	// MaxPending is the number of held entries beyond which new entries are
+	// logged at once
+	MaxPending int `json:"max_pending" yaml:"max_pending"`
+
+	// WriteTimeout is the timeout of writing a held entry
+	WriteTimeout time.Duration `json:"write_timeout" yaml:"write_timeout"`
+
+	// OnError is called when a held entry fails to be written. Optional.
+	OnError func(entry AuditEntry, err error) `json:"-" yaml:"-"`
+}
+
+// DefaultCoalesceConfig returns a default coalescer configuration
+func DefaultCoalesceConfig() CoalesceConfig {
+	return CoalesceConfig{
+		Window:       time.Minute,
+		MaxPending:   10000,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// CoalesceStats represents the counters of a coalescer
+type CoalesceStats struct {
+	Pending int   `json:"pending"` // entries held at the moment
+	Merged  int64 `json:"merged"`  // entries merged into an earlier identical entry
+	Written int64 `json:"written"` // held entries written
+	Failed  int64 `json:"failed"`  // held entries that failed to be written
+}
+
+// Coalescer merges identical entries logged within a window, such as the
+// views of polling clients, into one entry counting their Occurrences. The
+// first entry is held for the window and stored with the timestamp and ID of
+// the first occurrence and the LastOccurrence; IDs, correlation and trace IDs
+// of later occurrences are dropped. Entries are identical when they have the
+// same tenant, action, actor, resource, IP address, user agent, metadata and
+// sampling rate. Entries with changes, failed entries and entries with a
+// parent are never coalesced. A coalescer must be used by a single service.
+type Coalescer struct {
+	config CoalesceConfig
+	write  func(ctx context.Context, entry AuditEntry) error
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[string]*heldEntry
+	flushes sync.WaitGroup
+
+	merged  atomic.Int64
+	written atomic.Int64
+	failed  atomic.Int64
+}
+
+// heldEntry is an entry waiting for identical ones until its timer fires
+type heldEntry struct {
+	ctx   context.Context
+	entry AuditEntry
+	timer *time.Timer
+}
+
+// coalesceKey lists the fields that make entries identical
+type coalesceKey struct {
+	TenantID   string         `json:"t"`
+	Action     AuditAction    `json:"a"`
+	Actor      Actor          `json:"u"`
+	Resource   AuditResource  `json:"r"`
+	IPAddress  string         `json:"i"`
+	UserAgent  string         `json:"g"`
+	Metadata   map[string]any `json:"m"`
+	SampleRate float64        `json:"s"`
+}
+
+// NewCoalescer creates a coalescer. Zero values in the configuration are
+// replaced by their defaults.
+func NewCoalescer(config CoalesceConfig) *Coalescer {
+	defaults := DefaultCoalesceConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = defaults.MaxPending
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaults.WriteTimeout
+	}
+
+	return &Coalescer{
+		config:  config,
+		pending: make(map[string]*heldEntry),
+	}
+}
+
+// WithCoalescer merges identical entries before they are logged. A held
+// entry is not an error: LogAction returns nil and write errors are
+// reported to OnError. Close writes the entries still held. Entries logged
+// within a transaction and entries whose failure mode is FailClosed, the
+// mode of every entry without a policy, are never coalesced.
+func WithCoalescer(coalescer *Coalescer) ServiceOption {
+	return func(s *auditService) {
+		s.coalescer = coalescer
+		coalescer.write = s.dispatch
+	}
+}
+
+// Stats returns the counters of the coalescer
+func (c *Coalescer) Stats() CoalesceStats {
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+
+	return CoalesceStats{
+		Pending: pending,
+		Merged:  c.merged.Load(),
+		Written: c.written.Load(),
+		Failed:  c.failed.Load(),
+	}
+}
+
+// coalescible reports whether an entry may be merged with identical ones
+func (c *Coalescer) coalescible(entry AuditEntry) bool {
+	if len(entry.Changes) > 0 || !entry.Success || entry.ErrorMsg != "" || entry.ParentID != nil {
+		return false
+	}
+	return len(c.config.Actions) == 0 || slices.Contains(c.config.Actions, entry.Action)
+}
+
+// hold merges an entry into an identical held one, or holds it for the
+// window. It returns false if the entry must be logged at once.
+func (c *Coalescer) hold(ctx context.Context, entry AuditEntry) bool {
+	if !c.coalescible(entry) {
+		return false
+	}
+	raw, err := json.Marshal(coalesceKey{
+		TenantID:   entry.TenantID,
+		Action:     entry.Action,
+		Actor:      entry.Actor,
+		Resource:   entry.Resource,
+		IPAddress:  entry.IPAddress,
+		UserAgent:  entry.UserAgent,
+		Metadata:   entry.Metadata,
+		SampleRate: entry.SampleRate,
+	})
+	if err != nil {
+		return false
+	}
+	key := string(raw)
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if held, ok := c.pending[key]; ok {
+		first := &held.entry
+		if first.Occurrences == 0 {
+			first.Occurrences = 1
+		}
+		first.Occurrences++
+		if first.LastOccurrence == nil || entry.Timestamp.After(*first.LastOccurrence) {
+			last := entry.Timestamp
+			first.LastOccurrence = &last
+		}
+		c.merged.Add(1)
+		return true
+	}
+
+	if len(c.pending) >= c.config.MaxPending {
+		return false
+	}
+
+	held := &heldEntry{ctx: context.WithoutCancel(ctx), entry: entry}
+	c.flushes.Add(1)
+	held.timer = time.AfterFunc(c.config.Window, func() {
+		defer c.flushes.Done()
+		c.flush(key)
+	})
+	c.pending[key] = held
+	return true
+}
+
+// flush writes the held entry of a key
+func (c *Coalescer) flush(key string) {
+	c.mu.Lock()
+	held, ok := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+
+	if ok {
+		c.writeHeld(held)
+	}
+}
+
+// writeHeld writes a held entry and counts the outcome
+func (c *Coalescer) writeHeld(held *heldEntry) {
+	ctx, cancel := context.WithTimeout(held.ctx, c.config.WriteTimeout)
+	defer cancel()
+
+	if err := c.write(ctx, held.entry); err != nil {
+		c.failed.Add(1)
+		if c.config.OnError != nil {
+			c.config.OnError(held.entry, err)
+		}
+		return
+	}
+	c.written.Add(1)
+}
+
+// close stops holding entries and writes the held ones
+func (c *Coalescer) close() {
+	c.mu.Lock()
+	c.closed = true
+	var held []*heldEntry
+	for key, h := range c.pending {
+		if h.timer.Stop() {
+			c.flushes.Done()
+		}
+		held = append(held, h)
+		delete(c.pending, key)
+	}
+	c.mu.Unlock()
+
+	for _, h := range held {
+		c.writeHeld(h)
+	}
+	c.flushes.Wait()
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalescerSkipsFailClosedEntries(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		policy *AuditPolicy
+		held   bool
+	}{
+		{name: "no policy"},
+		{name: "fail closed", policy: DefaultAuditPolicy()},
+		{name: "fail open", policy: &AuditPolicy{Default: FailOpen}, held: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryRepository{}
+			options := []ServiceOption{WithCoalescer(NewCoalescer(CoalesceConfig{Window: time.Hour}))}
+			if tt.policy != nil {
+				options = append(options, WithPolicy(tt.policy))
+			}
+			service := NewServiceWithRepository(repo, options...)
+
+			for i := 0; i < 2; i++ {
+				if err := service.LogAction(ctx, testEntry("doc1")); err != nil {
+					t.Fatalf("LogAction failed: %v", err)
+				}
+			}
+			stored := len(repo.stored())
+			if err := service.Close(ctx); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			if tt.held {
+				if stored != 0 {
+					t.Errorf("stored %d entries before Close, want them held", stored)
+				}
+				if all := repo.stored(); len(all) != 1 || all[0].Occurrences != 2 {
+					t.Errorf("stored %+v after Close, want one entry of 2 occurrences", all)
+				}
+			} else if stored != 2 {
+				t.Errorf("stored %d entries when LogAction returned, want 2", stored)
+			}
+		})
+	}
+}
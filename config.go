@@ -1,6 +1,7 @@
 package audit
 
 import (
+	"strconv"
 	"time"
 )
 
@@ -11,33 +12,88 @@ type Config struct {
 	DatabaseName   string `json:"database_name" yaml:"database_name"`
 	CollectionName string `json:"collection_name" yaml:"collection_name"`
 
+	// PartitionInterval splits entries into one collection per month or day,
+	// named after CollectionName, so that old entries are dropped whole
+	PartitionInterval PartitionInterval `json:"partition_interval" yaml:"partition_interval"`
+
+	// TenantMode separates the entries of tenants: "shared" requires a tenant
+	// ID on every entry and starts indexes with it, "collection" also stores
+	// each tenant in its own collection. See ForTenant.
+	TenantMode TenantMode `json:"tenant_mode" yaml:"tenant_mode"`
+
+	// Time-series settings; TimeSeries creates the collection as a MongoDB
+	// time-series collection, which requires MongoDB 6.0 or later. Entries
+	// older than TimeSeriesExpireAfter are removed by MongoDB; 0 keeps them.
+	TimeSeries            bool          `json:"time_series" yaml:"time_series"`
+	TimeSeriesGranularity string        `json:"time_series_granularity" yaml:"time_series_granularity"`
+	TimeSeriesExpireAfter time.Duration `json:"time_series_expire_after" yaml:"time_series_expire_after"`
+
 	// Connection pool settings
 	MaxPoolSize    uint64        `json:"max_pool_size" yaml:"max_pool_size"`
 	MinPoolSize    uint64        `json:"min_pool_size" yaml:"min_pool_size"`
 	ConnectTimeout time.Duration `json:"connect_timeout" yaml:"connect_timeout"`
 
-	// Retry settings
-	MaxRetries int           `json:"max_retries" yaml:"max_retries"`
-	RetryDelay time.Duration `json:"retry_delay" yaml:"retry_delay"`
+	// Retry settings; RetryDelay is the initial delay of an exponential
+	// backoff capped at MaxRetryDelay. Only transient errors are retried.
+	MaxRetries      int           `json:"max_retries" yaml:"max_retries"`
+	RetryDelay      time.Duration `json:"retry_delay" yaml:"retry_delay"`
+	MaxRetryDelay   time.Duration `json:"max_retry_delay" yaml:"max_retry_delay"`
+	MaxRetryElapsed time.Duration `json:"max_retry_elapsed" yaml:"max_retry_elapsed"`
+
+	// RetryPolicy overrides the backoff built from the retry settings
+	RetryPolicy RetryPolicy `json:"-" yaml:"-"`
+
+	// Consistency settings; writes use WriteConcern ("majority", a number of
+	// nodes or a tag set name), history queries use ReadPreference and
+	// ReadConcern. Empty values keep the defaults of the client and MongoURI.
+	WriteConcern     string        `json:"write_concern" yaml:"write_concern"`
+	WriteJournal     bool          `json:"write_journal" yaml:"write_journal"`
+	WriteTimeout     time.Duration `json:"write_timeout" yaml:"write_timeout"`
+	ReadPreference   string        `json:"read_preference" yaml:"read_preference"`
+	ReadMaxStaleness time.Duration `json:"read_max_staleness" yaml:"read_max_staleness"`
+	ReadConcern      string        `json:"read_concern" yaml:"read_concern"`
 
 	// Performance settings
 	BatchSize     int  `json:"batch_size" yaml:"batch_size"`
 	EnableIndexes bool `json:"enable_indexes" yaml:"enable_indexes"`
+
+	// Spool settings; entries are spooled to local disk while the backend
+	// is unavailable. Disabled when SpoolDir is empty.
+	SpoolDir            string          `json:"spool_dir" yaml:"spool_dir"`
+	SpoolMaxBytes       int64           `json:"spool_max_bytes" yaml:"spool_max_bytes"`
+	SpoolSegmentBytes   int64           `json:"spool_segment_bytes" yaml:"spool_segment_bytes"`
+	SpoolFullPolicy     SpoolFullPolicy `json:"spool_full_policy" yaml:"spool_full_policy"`
+	SpoolReplayInterval time.Duration   `json:"spool_replay_interval" yaml:"spool_replay_interval"`
+
+	// File storage settings, used by OpenFileRepository
+	FileDir             string        `json:"file_dir" yaml:"file_dir"`
+	FileSegmentBytes    int64         `json:"file_segment_bytes" yaml:"file_segment_bytes"`
+	FileRetention       time.Duration `json:"file_retention" yaml:"file_retention"`
+	FileCompactInterval time.Duration `json:"file_compact_interval" yaml:"file_compact_interval"`
 }
 
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		MongoURI:       "mongodb://localhost:27017",
-		DatabaseName:   "audit",
-		CollectionName: "audit_logs",
-		MaxPoolSize:    100,
-		MinPoolSize:    5,
-		ConnectTimeout: 10 * time.Second,
-		MaxRetries:     3,
-		RetryDelay:     time.Second,
-		BatchSize:      1000,
-		EnableIndexes:  true,
+		MongoURI:        "mongodb://localhost:27017",
+		DatabaseName:    "audit",
+		CollectionName:  "audit_logs",
+		MaxPoolSize:     100,
+		MinPoolSize:     5,
+		ConnectTimeout:  10 * time.Second,
+		MaxRetries:      3,
+		RetryDelay:      time.Second,
+		MaxRetryDelay:   10 * time.Second,
+		MaxRetryElapsed: 30 * time.Second,
+		BatchSize:       1000,
+		EnableIndexes:   true,
+
+		SpoolMaxBytes:       1 << 30,
+		SpoolSegmentBytes:   64 << 20,
+		SpoolFullPolicy:     SpoolFullReject,
+		SpoolReplayInterval: 5 * time.Second,
+
+		FileSegmentBytes: 64 << 20,
 	}
 }
 
@@ -52,15 +108,146 @@ func (c *Config) Validate() error {
 	if c.CollectionName == "" {
 		return ErrInvalidConfig{Field: "CollectionName", Message: "cannot be empty"}
 	}
+	switch c.PartitionInterval {
+	case PartitionNone, PartitionMonthly, PartitionDaily:
+	default:
+		return ErrInvalidConfig{Field: "PartitionInterval", Message: "must be monthly, daily or empty"}
+	}
+	switch c.TenantMode {
+	case TenantNone, TenantShared, TenantCollection:
+	default:
+		return ErrInvalidConfig{Field: "TenantMode", Message: "must be shared, collection or empty"}
+	}
+	if err := c.validateTimeSeries(); err != nil {
+		return err
+	}
+	if err := c.validateConsistency(); err != nil {
+		return err
+	}
 	if c.MaxRetries < 0 {
 		return ErrInvalidConfig{Field: "MaxRetries", Message: "cannot be negative"}
 	}
 	if c.RetryDelay < 0 {
 		return ErrInvalidConfig{Field: "RetryDelay", Message: "cannot be negative"}
 	}
+	if c.MaxRetryDelay < 0 {
+		return ErrInvalidConfig{Field: "MaxRetryDelay", Message: "cannot be negative"}
+	}
+	if c.MaxRetryElapsed < 0 {
+		return ErrInvalidConfig{Field: "MaxRetryElapsed", Message: "cannot be negative"}
+	}
 	if c.BatchSize <= 0 {
 		return ErrInvalidConfig{Field: "BatchSize", Message: "must be positive"}
 	}
+	if c.SpoolDir != "" {
+		if err := c.validateSpool(); err != nil {
+			return err
+		}
+	}
+	if c.FileDir != "" {
+		if err := c.validateFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryPolicy returns the configured retry policy or builds the default
+// exponential backoff from the retry settings
+func (c *Config) retryPolicy() RetryPolicy {
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy
+	}
+	return ExponentialBackoff{
+		InitialDelay:   c.RetryDelay,
+		MaxDelay:       c.MaxRetryDelay,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxRetries:     c.MaxRetries,
+		MaxElapsedTime: c.MaxRetryElapsed,
+	}
+}
+
+// validateSpool validates the spool settings
+func (c *Config) validateSpool() error {
+	if c.SpoolMaxBytes <= 0 {
+		return ErrInvalidConfig{Field: "SpoolMaxBytes", Message: "must be positive"}
+	}
+	if c.SpoolSegmentBytes <= 0 {
+		return ErrInvalidConfig{Field: "SpoolSegmentBytes", Message: "must be positive"}
+	}
+	if c.SpoolSegmentBytes > c.SpoolMaxBytes {
+		return ErrInvalidConfig{Field: "SpoolSegmentBytes", Message: "cannot exceed SpoolMaxBytes"}
+	}
+	if c.SpoolReplayInterval <= 0 {
+		return ErrInvalidConfig{Field: "SpoolReplayInterval", Message: "must be positive"}
+	}
+	switch c.SpoolFullPolicy {
+	case SpoolFullReject, SpoolFullDropOldest:
+	default:
+		return ErrInvalidConfig{Field: "SpoolFullPolicy", Message: "must be 'reject' or 'drop_oldest'"}
+	}
+	return nil
+}
+
+// validateConsistency validates the write concern, read preference and read
+// concern settings
+func (c *Config) validateConsistency() error {
+	if n, err := strconv.Atoi(c.WriteConcern); err == nil && n <= 0 {
+		return ErrInvalidConfig{Field: "WriteConcern", Message: "must acknowledge writes on at least one node"}
+	}
+	if c.WriteTimeout < 0 {
+		return ErrInvalidConfig{Field: "WriteTimeout", Message: "cannot be negative"}
+	}
+	if c.ReadMaxStaleness < 0 {
+		return ErrInvalidConfig{Field: "ReadMaxStaleness", Message: "cannot be negative"}
+	}
+	// MongoDB rejects a max staleness below 90 seconds
+	if c.ReadMaxStaleness > 0 && c.ReadMaxStaleness < 90*time.Second {
+		return ErrInvalidConfig{Field: "ReadMaxStaleness", Message: "must be at least 90 seconds"}
+	}
+	if _, err := c.readPreference(); err != nil {
+		return err
+	}
+	if c.ReadConcern != "" && !readConcernLevels[c.ReadConcern] {
+		return ErrInvalidConfig{Field: "ReadConcern", Message: "must be local, available, majority, linearizable or snapshot"}
+	}
+	if c.ReadConcern == "linearizable" && c.ReadPreference != "" && c.ReadPreference != "primary" {
+		return ErrInvalidConfig{Field: "ReadConcern", Message: "linearizable requires the primary read preference"}
+	}
+	return nil
+}
+
+// validateTimeSeries validates the time-series settings
+func (c *Config) validateTimeSeries() error {
+	switch c.TimeSeriesGranularity {
+	case "", GranularitySeconds, GranularityMinutes, GranularityHours:
+	default:
+		return ErrInvalidConfig{Field: "TimeSeriesGranularity", Message: "must be seconds, minutes, hours or empty"}
+	}
+	if c.TimeSeriesExpireAfter < 0 {
+		return ErrInvalidConfig{Field: "TimeSeriesExpireAfter", Message: "cannot be negative"}
+	}
+	if c.TimeSeriesExpireAfter > 0 && c.TimeSeriesExpireAfter < time.Second {
+		return ErrInvalidConfig{Field: "TimeSeriesExpireAfter", Message: "must be at least one second"}
+	}
+	if !c.TimeSeries && (c.TimeSeriesGranularity != "" || c.TimeSeriesExpireAfter != 0) {
+		return ErrInvalidConfig{Field: "TimeSeries", Message: "must be enabled to use the time-series settings"}
+	}
+	return nil
+}
+
+// validateFile validates the file storage settings
+func (c *Config) validateFile() error {
+	if c.FileSegmentBytes <= 0 {
+		return ErrInvalidConfig{Field: "FileSegmentBytes", Message: "must be positive"}
+	}
+	if c.FileRetention < 0 {
+		return ErrInvalidConfig{Field: "FileRetention", Message: "cannot be negative"}
+	}
+	if c.FileCompactInterval < 0 {
+		return ErrInvalidConfig{Field: "FileCompactInterval", Message: "cannot be negative"}
+	}
 	return nil
 }
 
@@ -0,0 +1,273 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix prefixes the environment variables read by LoadConfig,
+// e.g. AUDIT_MONGO_URI for mongo_uri
+const ConfigEnvPrefix = "AUDIT_"
+
+// secretConfigFields are the settings whose values may reference a secret
+// instead of holding it, as "file:<path>" or "env:<variable>"
+var secretConfigFields = []string{"MongoURI"}
+
+// configSources records where each loaded setting came from, so that
+// validation errors name the key to fix
+type configSources map[string]string
+
+// LoadConfig loads a configuration from a YAML or JSON file, chosen by its
+// extension, on top of DefaultConfig. AUDIT_* environment variables then
+// override the file; an empty path loads the environment only. Durations
+// are written with units, e.g. "10s", or as integer nanoseconds like
+// json.Marshal writes them. Secret references are resolved and the result
+// is validated.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	sources := configSources{}
+
+	if path != "" {
+		if err := loadConfigFile(config, path, sources); err != nil {
+			return nil, err
+		}
+	}
+	if err := loadConfigEnv(config, os.LookupEnv, sources); err != nil {
+		return nil, err
+	}
+	if err := resolveConfigSecrets(config, sources); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, sources.locate(err)
+	}
+	return config, nil
+}
+
+// loadConfigFile applies the settings of a YAML or JSON file
+func loadConfigFile(config *Config, path string, sources configSources) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	settings := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&settings)
+	default:
+		return fmt.Errorf("unsupported config file extension %q: use .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	fields := configFields(config)
+	for key, raw := range settings {
+		source := path + ": " + key
+		field, ok := fields[key]
+		if !ok {
+			return ErrInvalidConfig{Field: source, Message: "unknown setting"}
+		}
+
+		value, err := configScalar(raw)
+		if err != nil {
+			return ErrInvalidConfig{Field: source, Message: err.Error()}
+		}
+		if err := setConfigField(field.value, value); err != nil {
+			return ErrInvalidConfig{Field: source, Message: err.Error()}
+		}
+		sources[field.name] = source
+	}
+
+	return nil
+}
+
+// loadConfigEnv applies the settings of AUDIT_* environment variables
+func loadConfigEnv(config *Config, lookup func(string) (string, bool), sources configSources) error {
+	for key, field := range configFields(config) {
+		name := ConfigEnvPrefix + strings.ToUpper(key)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setConfigField(field.value, value); err != nil {
+			return ErrInvalidConfig{Field: name, Message: err.Error()}
+		}
+		sources[field.name] = name
+	}
+	return nil
+}
+
+// resolveConfigSecrets replaces secret references by the secrets they point to
+func resolveConfigSecrets(config *Config, sources configSources) error {
+	v := reflect.ValueOf(config).Elem()
+	for _, name := range secretConfigFields {
+		field := v.FieldByName(name)
+		secret, err := resolveSecret(field.String())
+		if err != nil {
+			return ErrInvalidConfig{Field: sources.key(name), Message: err.Error()}
+		}
+		field.SetString(secret)
+	}
+	return nil
+}
+
+// resolveSecret returns the secret a value references, or the value itself.
+// File contents are trimmed of surrounding whitespace.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "file:"):
+		// Accept both file:/path and file:///path
+		path := strings.TrimPrefix(value, "file:")
+		if strings.HasPrefix(path, "//") {
+			path = strings.TrimPrefix(path, "//")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// configField is a loadable Config field
+type configField struct {
+	name  string // Go field name, as reported by Validate
+	value reflect.Value
+}
+
+// configFields returns the loadable fields of a Config by key. Keys are the
+// json tags, which match the yaml tags.
+func configFields(config *Config) map[string]configField {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+
+	fields := map[string]configField{}
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields[key] = configField{name: t.Field(i).Name, value: v.Field(i)}
+	}
+	return fields
+}
+
+// configScalar returns the text of a scalar decoded from a config file
+func configScalar(raw any) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("must be a single value")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setConfigField parses text into a Config field
+func setConfigField(field reflect.Value, text string) error {
+	if field.Type() == durationType {
+		if text == "" {
+			field.SetInt(0)
+			return nil
+		}
+		// Integers are nanoseconds, so that a marshalled Config loads back
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			field.SetInt(n)
+			return nil
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: use units such as \"10s\" or \"1h30m\", or nanoseconds", text)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", text)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", text)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot be loaded from a file or the environment")
+	}
+	return nil
+}
+
+// key returns where a setting came from, or its key if it was not loaded
+func (s configSources) key(name string) string {
+	if source, ok := s[name]; ok {
+		return source
+	}
+	t, _ := reflect.TypeOf(Config{}).FieldByName(name)
+	if key, _, _ := strings.Cut(t.Tag.Get("json"), ","); key != "" {
+		return key
+	}
+	return name
+}
+
+// locate rewrites a validation error to name the key that set the field
+func (s configSources) locate(err error) error {
+	var invalid ErrInvalidConfig
+	if !errors.As(err, &invalid) {
+		return err
+	}
+	return ErrInvalidConfig{Field: s.key(invalid.Field), Message: invalid.Message}
+}
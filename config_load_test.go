@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeConfigFile writes a config file with the given name to a temporary
+// directory and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := map[string]string{
+		"audit.yaml": `
+database_name: myapp_audit
+max_pool_size: 20
+retry_delay: 500ms
+max_retry_elapsed: 1m
+write_journal: true
+write_concern: majority
+`,
+		"audit.json": `{
+	"database_name": "myapp_audit",
+	"max_pool_size": 20,
+	"retry_delay": "500ms",
+	"max_retry_elapsed": 60000000000,
+	"write_journal": true,
+	"write_concern": "majority"
+}`,
+	}
+	for name, content := range tests {
+		config, err := LoadConfig(writeConfigFile(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+
+		want := DefaultConfig()
+		want.DatabaseName = "myapp_audit"
+		want.MaxPoolSize = 20
+		want.RetryDelay = 500 * time.Millisecond
+		want.MaxRetryElapsed = time.Minute
+		want.WriteJournal = true
+		want.WriteConcern = "majority"
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("%s: loaded %+v, want %+v", name, config, want)
+		}
+	}
+}
+
+func TestLoadConfigRoundTrip(t *testing.T) {
+	want := DefaultConfig()
+	want.DatabaseName = "myapp_audit"
+	want.WriteTimeout = 5 * time.Second
+	want.SpoolDir = "/var/spool/audit"
+
+	marshal := map[string]func(any) ([]byte, error){
+		"audit.json": json.Marshal,
+		"audit.yaml": yaml.Marshal,
+	}
+	for name, marshal := range marshal {
+		data, err := marshal(want)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %v", name, err)
+		}
+		config, err := LoadConfig(writeConfigFile(t, name, string(data)))
+		if err != nil {
+			t.Fatalf("%s: LoadConfig failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("%s: loaded %+v, want %+v", name, config, want)
+		}
+	}
+}
+
+func TestLoadConfigEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "audit.yaml", "database_name: from_file\nretry_delay: 500ms\n")
+	t.Setenv("AUDIT_RETRY_DELAY", "2s")
+	t.Setenv("AUDIT_ENABLE_INDEXES", "false")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.DatabaseName != "from_file" || config.RetryDelay != 2*time.Second || config.EnableIndexes {
+		t.Errorf("loaded database %q, retry delay %v, indexes %v", config.DatabaseName, config.RetryDelay, config.EnableIndexes)
+	}
+
+	// An empty path loads the environment only
+	config, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.DatabaseName != DefaultConfig().DatabaseName || config.RetryDelay != 2*time.Second {
+		t.Errorf("loaded database %q, retry delay %v", config.DatabaseName, config.RetryDelay)
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	const uri = "mongodb://audit:secret@db:27017"
+	secretFile := writeConfigFile(t, "mongo-uri", uri+"\n")
+	t.Setenv("TEST_AUDIT_MONGO_URI", uri)
+
+	for _, reference := range []string{"file:" + secretFile, "file://" + secretFile, "env:TEST_AUDIT_MONGO_URI"} {
+		t.Setenv("AUDIT_MONGO_URI", reference)
+		config, err := LoadConfig("")
+		if err != nil {
+			t.Fatalf("%s: LoadConfig failed: %v", reference, err)
+		}
+		if config.MongoURI != uri {
+			t.Errorf("%s: resolved to %q", reference, config.MongoURI)
+		}
+	}
+
+	for _, reference := range []string{"file:" + secretFile + ".missing", "env:TEST_AUDIT_UNSET"} {
+		t.Setenv("AUDIT_MONGO_URI", reference)
+		_, err := LoadConfig("")
+		var invalid ErrInvalidConfig
+		if !errors.As(err, &invalid) || invalid.Field != "AUDIT_MONGO_URI" {
+			t.Errorf("%s: LoadConfig = %v, want an error for AUDIT_MONGO_URI", reference, err)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, content string
+		field         string
+	}{
+		{"audit.yaml", "unknown_key: 1\n", ": unknown_key"},
+		{"audit.yaml", "retry_delay: soon\n", ": retry_delay"},
+		{"audit.yaml", "max_pool_size: -1\n", ": max_pool_size"},
+		{"audit.yaml", "database_name: [a, b]\n", ": database_name"},
+		{"audit.json", `{"retry_delay": "-1s"}`, ": retry_delay"},
+	}
+	for _, tt := range tests {
+		path := writeConfigFile(t, tt.name, tt.content)
+		_, err := LoadConfig(path)
+		var invalid ErrInvalidConfig
+		if !errors.As(err, &invalid) || invalid.Field != path+tt.field {
+			t.Errorf("%q: LoadConfig = %v, want an error for %s", tt.content, err, path+tt.field)
+		}
+	}
+
+	// Validation errors name the variable that set the value
+	t.Setenv("AUDIT_BATCH_SIZE", "0")
+	var invalid ErrInvalidConfig
+	if _, err := LoadConfig(""); !errors.As(err, &invalid) || invalid.Field != "AUDIT_BATCH_SIZE" {
+		t.Errorf("LoadConfig = %v, want an error for AUDIT_BATCH_SIZE", err)
+	}
+
+	if _, err := LoadConfig(writeConfigFile(t, "audit.toml", "")); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("LoadConfig = %v, want an unsupported extension error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// AuditEntryNode represents an audit entry together with the entries that
+// reference it as their parent
+type AuditEntryNode struct {
+	Entry    AuditEntry        `json:"entry"`
+	Children []*AuditEntryNode `json:"children,omitempty"`
+}
+
+// GetCorrelationTree returns all entries sharing a correlation ID arranged as
+// a forest by parent ID. Entries without a parent, or whose parent is not
+// part of the correlation, are returned as roots. Parent IDs that form a
+// cycle are cut at the earliest entry of the cycle, which becomes a root, so
+// that every entry is returned exactly once. Roots and children are ordered
+// by timestamp.
+func GetCorrelationTree(ctx context.Context, service AuditService, correlationID string) ([]*AuditEntryNode, error) {
+	if correlationID == "" {
+		return nil, fmt.Errorf("correlation ID cannot be empty")
+	}
+
+	result, err := service.GetHistory(ctx, AuditQuery{CorrelationID: correlationID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load correlated entries: %w", err)
+	}
+
+	entries := result.Entries
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	nodes := make(map[string]*AuditEntryNode, len(entries))
+	for _, entry := range entries {
+		nodes[entry.ID.Hex()] = &AuditEntryNode{Entry: entry}
+	}
+
+	var roots []*AuditEntryNode
+	for _, entry := range entries {
+		node := nodes[entry.ID.Hex()]
+		if entry.ParentID != nil {
+			if parent, ok := nodes[entry.ParentID.Hex()]; ok && parent != node {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	return breakCorrelationCycles(entries, nodes, roots), nil
+}
+
+// breakCorrelationCycles adds the entries no root reaches, whose parents form
+// a cycle, to the roots by detaching the earliest entry of each cycle from
+// its parent
+func breakCorrelationCycles(entries []AuditEntry, nodes map[string]*AuditEntryNode, roots []*AuditEntryNode) []*AuditEntryNode {
+	reached := make(map[*AuditEntryNode]bool, len(nodes))
+	var reach func(node *AuditEntryNode)
+	reach = func(node *AuditEntryNode) {
+		reached[node] = true
+		for _, child := range node.Children {
+			reach(child)
+		}
+	}
+	for _, root := range roots {
+		reach(root)
+	}
+	if len(reached) == len(nodes) {
+		return roots
+	}
+
+	order := make(map[*AuditEntryNode]int, len(entries))
+	for i, entry := range entries {
+		order[nodes[entry.ID.Hex()]] = i
+	}
+	parentOf := func(node *AuditEntryNode) *AuditEntryNode {
+		return nodes[node.Entry.ParentID.Hex()]
+	}
+
+	for _, entry := range entries {
+		node := nodes[entry.ID.Hex()]
+		if reached[node] {
+			continue
+		}
+
+		// Unreached entries all have a parent, so following parents from
+		// one ends in a cycle; the first entry seen twice is part of it
+		seen := map[*AuditEntryNode]bool{}
+		for !seen[node] {
+			seen[node] = true
+			node = parentOf(node)
+		}
+		first := node
+		for member := parentOf(node); member != node; member = parentOf(member) {
+			if order[member] < order[first] {
+				first = member
+			}
+		}
+
+		parent := parentOf(first)
+		parent.Children = slices.DeleteFunc(parent.Children, func(child *AuditEntryNode) bool {
+			return child == first
+		})
+		roots = append(roots, first)
+		reach(first)
+	}
+
+	sort.SliceStable(roots, func(i, j int) bool {
+		return order[roots[i]] < order[roots[j]]
+	})
+	return roots
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// treeShape renders a forest as resource IDs, children in parentheses
+func treeShape(nodes []*AuditEntryNode) string {
+	shape := ""
+	for i, node := range nodes {
+		if i > 0 {
+			shape += " "
+		}
+		shape += node.Entry.Resource.ID
+		if len(node.Children) > 0 {
+			shape += "(" + treeShape(node.Children) + ")"
+		}
+	}
+	return shape
+}
+
+func TestGetCorrelationTree(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestFileRepository(t)
+	service := NewServiceWithRepository(repo)
+
+	// c -> d -> e -> c is a cycle; f hangs off it and is older than all of it
+	ids := map[string]primitive.ObjectID{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "x"} {
+		ids[name] = primitive.NewObjectID()
+	}
+	start := time.Now().UTC().Add(-time.Hour)
+	entry := func(name, parent string, minutes int) AuditEntry {
+		builder := NewAuditBuilder().
+			Update().
+			User("user123", "").
+			Resource("order", name, "").
+			Correlation("req-1").
+			Success(true)
+		if parent != "" {
+			builder.Parent(ids[parent])
+		}
+		entry := builder.Build()
+		entry.ID = ids[name]
+		entry.Timestamp = start.Add(time.Duration(minutes) * time.Minute)
+		return entry
+	}
+	insertEntries(t, repo,
+		entry("e", "d", 6),
+		entry("a", "", 1),
+		entry("d", "c", 5),
+		entry("b", "a", 2),
+		entry("c", "e", 4),
+		entry("f", "d", 3),
+		entry("g", "g", 7), // its own parent
+		entry("h", "x", 8), // parent outside the correlation
+	)
+
+	roots, err := GetCorrelationTree(ctx, service, "req-1")
+	if err != nil {
+		t.Fatalf("GetCorrelationTree failed: %v", err)
+	}
+	if got, want := treeShape(roots), "a(b) c(d(f e)) g h"; got != want {
+		t.Errorf("tree %q, want %q", got, want)
+	}
+	if _, err := json.Marshal(roots); err != nil {
+		t.Errorf("failed to marshal the tree: %v", err)
+	}
+
+	if _, err := GetCorrelationTree(ctx, service, ""); err == nil {
+		t.Error("expected an error for an empty correlation ID")
+	}
+}
@@ -0,0 +1,298 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedactedValue replaces the old and new values of sensitive fields
+const RedactedValue = "[REDACTED]"
+
+// maxDiffDepth bounds recursion so cyclic structures terminate
+const maxDiffDepth = 32
+
+// Diff compares two values and returns the changed fields as FieldChange
+// entries. Structs, maps, slices and arrays are walked recursively and the
+// resulting fields use dotted paths, e.g. "address.city" or "roles.1".
+//
+// Struct fields are named after their `audit` tag, falling back to the
+// `json` tag and then the Go field name. The tag also controls handling:
+//
+//	Email    string `audit:"email"`           // renamed
+//	Internal string `audit:"-"`               // ignored
+//	Password string `audit:"password,sensitive"` // values replaced by RedactedValue
+//
+// Unexported fields are ignored, but unexported embedded structs promote
+// their exported fields. Values of different types are reported as a single
+// change at their path.
+func Diff(oldValue, newValue any) []FieldChange {
+	var changes []FieldChange
+	diffValues(&changes, "", reflect.ValueOf(oldValue), reflect.ValueOf(newValue), 0)
+	return changes
+}
+
+// diffValues appends the differences between two values at the given path.
+// Either value may be invalid, which stands for an absent or nil value.
+func diffValues(changes *[]FieldChange, path string, oldV, newV reflect.Value, depth int) {
+	oldV, newV = indirect(oldV), indirect(newV)
+
+	kind, ok := composite(oldV, newV)
+	if !ok || depth >= maxDiffDepth {
+		if !leafEqual(oldV, newV) {
+			*changes = append(*changes, FieldChange{
+				Field:    path,
+				OldValue: interfaceOf(oldV),
+				NewValue: interfaceOf(newV),
+			})
+		}
+		return
+	}
+
+	switch kind {
+	case reflect.Struct:
+		diffStructs(changes, path, oldV, newV, depth)
+	case reflect.Map:
+		diffMaps(changes, path, oldV, newV, depth)
+	case reflect.Slice, reflect.Array:
+		diffSlices(changes, path, oldV, newV, depth)
+	}
+}
+
+// diffStructs walks the exported fields of two structs of the same type
+func diffStructs(changes *[]FieldChange, path string, oldV, newV reflect.Value, depth int) {
+	t := typeOf(oldV, newV)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, sensitive, skip := diffFieldName(field)
+		flatten := field.Anonymous && name == field.Name && isStructLike(field.Type)
+
+		// Unexported embedded structs still promote their exported fields
+		if skip || (!field.IsExported() && (!flatten || sensitive)) {
+			continue
+		}
+
+		oldField, newField := fieldOf(oldV, i), fieldOf(newV, i)
+		fieldPath := joinPath(path, name)
+
+		if sensitive {
+			oldField, newField = indirect(oldField), indirect(newField)
+			if !leafEqual(oldField, newField) {
+				*changes = append(*changes, FieldChange{
+					Field:    fieldPath,
+					OldValue: redacted(oldField),
+					NewValue: redacted(newField),
+				})
+			}
+			continue
+		}
+
+		// Embedded structs without an explicit name are flattened like encoding/json does
+		if flatten {
+			diffValues(changes, path, oldField, newField, depth+1)
+			continue
+		}
+
+		diffValues(changes, fieldPath, oldField, newField, depth+1)
+	}
+}
+
+// diffMaps walks the union of keys of two maps in sorted order
+func diffMaps(changes *[]FieldChange, path string, oldV, newV reflect.Value, depth int) {
+	keys := make(map[string]reflect.Value)
+	for _, v := range []reflect.Value{oldV, newV} {
+		if !v.IsValid() {
+			continue
+		}
+		for _, key := range v.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := keys[name]
+		var oldElem, newElem reflect.Value
+		if oldV.IsValid() {
+			oldElem = oldV.MapIndex(key)
+		}
+		if newV.IsValid() {
+			newElem = newV.MapIndex(key)
+		}
+		diffValues(changes, joinPath(path, name), oldElem, newElem, depth+1)
+	}
+}
+
+// diffSlices walks two slices or arrays element by element
+func diffSlices(changes *[]FieldChange, path string, oldV, newV reflect.Value, depth int) {
+	n := 0
+	if oldV.IsValid() {
+		n = oldV.Len()
+	}
+	if newV.IsValid() && newV.Len() > n {
+		n = newV.Len()
+	}
+
+	for i := 0; i < n; i++ {
+		var oldElem, newElem reflect.Value
+		if oldV.IsValid() && i < oldV.Len() {
+			oldElem = oldV.Index(i)
+		}
+		if newV.IsValid() && i < newV.Len() {
+			newElem = newV.Index(i)
+		}
+		diffValues(changes, joinPath(path, strconv.Itoa(i)), oldElem, newElem, depth+1)
+	}
+}
+
+// diffFieldName resolves the path segment and handling of a struct field
+func diffFieldName(field reflect.StructField) (name string, sensitive, skip bool) {
+	name = field.Name
+
+	if tag, ok := field.Tag.Lookup("audit"); ok {
+		if tag == "-" {
+			return "", false, true
+		}
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			if opt == "sensitive" {
+				sensitive = true
+			}
+		}
+		if parts[0] != "" {
+			return name, sensitive, false
+		}
+	}
+
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		if tag == "-" {
+			return "", false, true
+		}
+		if jsonName, _, _ := strings.Cut(tag, ","); jsonName != "" {
+			name = jsonName
+		}
+	}
+
+	return name, sensitive, false
+}
+
+// composite reports whether two values should be walked recursively and
+// with which kind. Values of different types are compared as leaves.
+func composite(oldV, newV reflect.Value) (reflect.Kind, bool) {
+	if !oldV.IsValid() && !newV.IsValid() {
+		return reflect.Invalid, false
+	}
+	if oldV.IsValid() && newV.IsValid() && oldV.Type() != newV.Type() {
+		return reflect.Invalid, false
+	}
+
+	t := typeOf(oldV, newV)
+	switch t.Kind() {
+	case reflect.Struct:
+		return t.Kind(), isStructLike(t)
+	case reflect.Map:
+		return t.Kind(), true
+	case reflect.Slice, reflect.Array:
+		// Byte sequences such as ObjectIDs read better as a single value
+		return t.Kind(), t.Elem().Kind() != reflect.Uint8
+	default:
+		return t.Kind(), false
+	}
+}
+
+// isStructLike reports whether a struct type has exported fields to walk.
+// Opaque structs such as time.Time are compared as leaves.
+func isStructLike(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// leafEqual compares two values, honouring an Equal method such as time.Time's
+func leafEqual(oldV, newV reflect.Value) bool {
+	if !oldV.IsValid() || !newV.IsValid() {
+		return oldV.IsValid() == newV.IsValid()
+	}
+	if oldV.Type() != newV.Type() {
+		return false
+	}
+
+	if method := oldV.MethodByName("Equal"); method.IsValid() {
+		mt := method.Type()
+		if mt.NumIn() == 1 && mt.In(0) == newV.Type() && mt.NumOut() == 1 && mt.Out(0).Kind() == reflect.Bool {
+			return method.Call([]reflect.Value{newV})[0].Bool()
+		}
+	}
+
+	return reflect.DeepEqual(oldV.Interface(), newV.Interface())
+}
+
+// indirect dereferences pointers and interfaces, returning an invalid value for nil
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// typeOf returns the type of whichever value is valid
+func typeOf(oldV, newV reflect.Value) reflect.Type {
+	if oldV.IsValid() {
+		return oldV.Type()
+	}
+	return newV.Type()
+}
+
+// fieldOf returns the i-th field of a struct value, or an invalid value
+func fieldOf(v reflect.Value, i int) reflect.Value {
+	if !v.IsValid() {
+		return reflect.Value{}
+	}
+	return v.Field(i)
+}
+
+// interfaceOf returns the value held by v, or nil for an invalid value
+func interfaceOf(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// redacted hides a sensitive value while preserving whether it was set
+func redacted(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	return RedactedValue
+}
+
+// joinPath appends a segment to a dotted field path
+func joinPath(path, segment string) string {
+	if path == "" {
+		return segment
+	}
+	return path + "." + segment
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type diffAddress struct {
+	City   string `json:"city"`
+	Street string `json:"street,omitempty"`
+}
+
+type diffBase struct {
+	Version int `json:"version"`
+}
+
+type diffUser struct {
+	diffBase
+	Name     string            `audit:"full_name" json:"name"`
+	Email    string            `json:"email"`
+	Password string            `audit:"password,sensitive"`
+	Token    *string           `audit:",sensitive" json:"token"`
+	Internal string            `audit:"-"`
+	Cache    string            `json:"-"`
+	Address  *diffAddress      `json:"address"`
+	Roles    []string          `json:"roles"`
+	Labels   map[string]string `json:"labels"`
+	Updated  time.Time         `json:"updated"`
+	secret   string
+}
+
+func TestDiff(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	token := "t1"
+	before := diffUser{
+		diffBase: diffBase{Version: 1},
+		Name:     "Jane",
+		Email:    "jane@example.com",
+		Password: "old",
+		Internal: "a",
+		Cache:    "a",
+		Address:  &diffAddress{City: "Oslo"},
+		Roles:    []string{"admin", "user"},
+		Labels:   map[string]string{"team": "core", "site": "eu"},
+		Updated:  updated,
+		secret:   "a",
+	}
+	after := before
+	after.Version = 2
+	after.Name = "Jane Smith"
+	after.Password = "new"
+	after.Token = &token
+	after.Internal = "b"
+	after.Cache = "b"
+	after.Address = &diffAddress{City: "Bergen", Street: "Main"}
+	after.Roles = []string{"admin"}
+	after.Labels = map[string]string{"team": "platform", "tier": "1"}
+	after.Updated = updated.In(time.FixedZone("CET", 3600)) // same instant
+	after.secret = "b"
+
+	want := []FieldChange{
+		{Field: "version", OldValue: 1, NewValue: 2},
+		{Field: "full_name", OldValue: "Jane", NewValue: "Jane Smith"},
+		{Field: "password", OldValue: RedactedValue, NewValue: RedactedValue},
+		{Field: "token", OldValue: nil, NewValue: RedactedValue},
+		{Field: "address.city", OldValue: "Oslo", NewValue: "Bergen"},
+		{Field: "address.street", OldValue: "", NewValue: "Main"},
+		{Field: "roles.1", OldValue: "user", NewValue: nil},
+		{Field: "labels.site", OldValue: "eu", NewValue: nil},
+		{Field: "labels.team", OldValue: "core", NewValue: "platform"},
+		{Field: "labels.tier", OldValue: nil, NewValue: "1"},
+	}
+	if got := Diff(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := Diff(&before, &before); len(got) != 0 {
+		t.Errorf("Diff of equal values = %+v", got)
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name             string
+		oldValue, newVal any
+		want             []FieldChange
+	}{
+		{"nil to map", nil, map[string]int{"a": 1}, []FieldChange{{Field: "a", OldValue: nil, NewValue: 1}}},
+		{"scalar", 1, 2, []FieldChange{{Field: "", OldValue: 1, NewValue: 2}}},
+		{"different types", 1, "1", []FieldChange{{Field: "", OldValue: 1, NewValue: "1"}}},
+		{"bytes as one value", []byte("ab"), []byte("ac"), []FieldChange{{Field: "", OldValue: []byte("ab"), NewValue: []byte("ac")}}},
+		{"nested slices", [][]int{{1, 2}}, [][]int{{1, 3}}, []FieldChange{{Field: "0.1", OldValue: 2, NewValue: 3}}},
+	}
+	for _, tt := range tests {
+		if got := Diff(tt.oldValue, tt.newVal); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Diff = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+type diffNode struct {
+	Name string
+	Next *diffNode
+}
+
+func TestDiffDepthLimit(t *testing.T) {
+	// Cyclic values terminate at the depth limit
+	a := &diffNode{Name: "a"}
+	a.Next = a
+	b := &diffNode{Name: "b"}
+	b.Next = b
+
+	changes := Diff(a, b)
+	if len(changes) == 0 || len(changes) > maxDiffDepth+1 {
+		t.Fatalf("Diff of cyclic values returned %d changes", len(changes))
+	}
+	if changes[0].Field != "Name" {
+		t.Errorf("first change at %q, want Name", changes[0].Field)
+	}
+	last := changes[len(changes)-1]
+	if depth := strings.Count(last.Field, ".") + 1; depth > maxDiffDepth {
+		t.Errorf("last change at depth %d, beyond the limit %d", depth, maxDiffDepth)
+	}
+
+	// Values nested beyond the limit are reported as a whole
+	var deep, deeper any = "x", "y"
+	for i := 0; i < maxDiffDepth+5; i++ {
+		deep = map[string]any{"n": deep}
+		deeper = map[string]any{"n": deeper}
+	}
+	changes = Diff(deep, deeper)
+	if len(changes) != 1 || strings.Count(changes[0].Field, ".")+1 != maxDiffDepth {
+		t.Fatalf("Diff of deep values = %+v", changes)
+	}
+	if _, ok := changes[0].OldValue.(map[string]any); !ok {
+		t.Errorf("value beyond the limit is %T, want the remaining map", changes[0].OldValue)
+	}
+}
@@ -0,0 +1,290 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BackendMode defines how a secondary backend's write failures affect an insert
+type BackendMode string
+
+const (
+	BackendRequired   BackendMode = "required"    // a failed write fails the insert
+	BackendBestEffort BackendMode = "best_effort" // a failed write is counted as divergence only
+)
+
+// FanOutBackend represents a repository written to by a fan-out repository
+type FanOutBackend struct {
+	Name       string
+	Repository AuditRepository
+	Mode       BackendMode // ignored for the primary, which is always required
+}
+
+// FanOutConfig represents the configuration of a fan-out repository
+type FanOutConfig struct {
+	Primary     FanOutBackend
+	Secondaries []FanOutBackend
+
+	// ReadFrom names the backend serving reads. Defaults to the primary.
+	ReadFrom string
+
+	// OnDivergence is called for every secondary write that failed after
+	// the primary write succeeded. Optional.
+	OnDivergence func(backend string, entry AuditEntry, err error)
+}
+
+// FanOutRepository writes every entry to a primary and to N secondary
+// repositories, e.g. to dual-write while migrating between stores. Reads are
+// served by a single chosen backend. Range reads need a read backend that
+// implements RangeRepository; retention changes need every backend to
+// implement RetentionRepository. Entries cannot be written inside a
+// caller's transaction, since the backends cannot share it.
+type FanOutRepository struct {
+	primary      *fanOutBackend
+	secondaries  []*fanOutBackend
+	read         atomic.Pointer[fanOutBackend]
+	onDivergence func(backend string, entry AuditEntry, err error)
+
+	divergent atomic.Int64
+}
+
+// fanOutBackend is a backend together with its counters
+type fanOutBackend struct {
+	FanOutBackend
+	written atomic.Int64
+	failed  atomic.Int64
+}
+
+// FanOutStats represents the counters of a fan-out repository
+type FanOutStats struct {
+	Divergent int64                `json:"divergent"` // entries written to the primary but missing from a secondary
+	Backends  []FanOutBackendStats `json:"backends"`
+}
+
+// FanOutBackendStats represents the write counters of one backend
+type FanOutBackendStats struct {
+	Name    string      `json:"name"`
+	Mode    BackendMode `json:"mode"`
+	Written int64       `json:"written"`
+	Failed  int64       `json:"failed"`
+}
+
+// NewFanOutRepository creates a fan-out repository. Backend names must be
+// unique and ReadFrom, if set, must name one of them.
+func NewFanOutRepository(config FanOutConfig) (*FanOutRepository, error) {
+	if config.Primary.Repository == nil {
+		return nil, fmt.Errorf("fan-out primary repository cannot be nil")
+	}
+
+	r := &FanOutRepository{
+		primary:      &fanOutBackend{FanOutBackend: config.Primary},
+		onDivergence: config.OnDivergence,
+	}
+	r.primary.Mode = BackendRequired
+
+	names := map[string]bool{config.Primary.Name: true}
+	for _, backend := range config.Secondaries {
+		if backend.Repository == nil {
+			return nil, fmt.Errorf("fan-out backend %q has no repository", backend.Name)
+		}
+		if names[backend.Name] {
+			return nil, fmt.Errorf("duplicate fan-out backend name %q", backend.Name)
+		}
+		switch backend.Mode {
+		case BackendRequired, BackendBestEffort:
+		case "":
+			backend.Mode = BackendRequired
+		default:
+			return nil, fmt.Errorf("invalid mode %q for fan-out backend %q", backend.Mode, backend.Name)
+		}
+		names[backend.Name] = true
+		r.secondaries = append(r.secondaries, &fanOutBackend{FanOutBackend: backend})
+	}
+
+	r.read.Store(r.primary)
+	if config.ReadFrom != "" {
+		if err := r.SetReadBackend(config.ReadFrom); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// SetReadBackend switches reads to the named backend, e.g. to cut over once a
+// secondary has been backfilled
+func (r *FanOutRepository) SetReadBackend(name string) error {
+	for _, backend := range r.backends() {
+		if backend.Name == name {
+			r.read.Store(backend)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fan-out backend %q", name)
+}
+
+// backends returns the primary followed by the secondaries
+func (r *FanOutRepository) backends() []*fanOutBackend {
+	return append([]*fanOutBackend{r.primary}, r.secondaries...)
+}
+
+// Insert writes an entry to the primary, then to every secondary in
+// parallel. A primary failure other than a duplicate entry is returned
+// without writing the secondaries.
+// Failures of required secondaries are returned; failures of best-effort
+// secondaries are only counted. Entries a secondary already holds count as
+// written.
+func (r *FanOutRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	// Every backend must store the same ID and timestamp for entries to be
+	// comparable
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	// A duplicate in the primary still reaches the secondaries, which may
+	// have missed the first write
+	primaryErr := r.primary.Repository.Insert(ctx, entry)
+	if primaryErr != nil && !IsDuplicateEntry(primaryErr) {
+		r.primary.failed.Add(1)
+		return primaryErr
+	}
+	if primaryErr == nil {
+		r.primary.written.Add(1)
+	}
+
+	errs := make([]error, len(r.secondaries))
+	var wg sync.WaitGroup
+	for i, backend := range r.secondaries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = backend.Repository.Insert(ctx, entry)
+		}()
+	}
+	wg.Wait()
+
+	diverged := false
+	var required []error
+	for i, backend := range r.secondaries {
+		err := errs[i]
+		if err == nil || IsDuplicateEntry(err) {
+			backend.written.Add(1)
+			continue
+		}
+
+		backend.failed.Add(1)
+		diverged = true
+		if r.onDivergence != nil {
+			r.onDivergence(backend.Name, entry, err)
+		}
+		if backend.Mode == BackendRequired {
+			required = append(required, fmt.Errorf("backend %s: %w", backend.Name, err))
+		}
+	}
+	if diverged {
+		r.divergent.Add(1)
+	}
+
+	if len(required) > 0 {
+		return fmt.Errorf("failed to write audit entry to required backends: %w", errors.Join(required...))
+	}
+	return primaryErr
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *FanOutRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	return r.read.Load().Repository.FindByQuery(ctx, query)
+}
+
+// FindByID finds an audit entry by its ID
+func (r *FanOutRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	return r.read.Load().Repository.FindByID(ctx, id)
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *FanOutRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	return r.read.Load().Repository.FindByResource(ctx, resourceType, resourceID, limit)
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *FanOutRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	return r.read.Load().Repository.FindByActor(ctx, actorID, actorType, limit)
+}
+
+// FindRange finds the entries of [start, end) after a cursor in the read
+// backend, which must implement RangeRepository
+func (r *FanOutRepository) FindRange(ctx context.Context, start, end time.Time, after *RangeCursor, limit int) ([]AuditEntry, error) {
+	backend := r.read.Load()
+	ranged, ok := findRepository[RangeRepository](backend.Repository)
+	if !ok {
+		return nil, fmt.Errorf("fan-out read backend %s does not support range reads", backend.Name)
+	}
+	return ranged.FindRange(ctx, start, end, after, limit)
+}
+
+// SetRetention changes the retention of every backend. Nothing is changed
+// unless every backend implements RetentionRepository.
+func (r *FanOutRepository) SetRetention(ctx context.Context, retention time.Duration) error {
+	backends := r.backends()
+	repos := make([]RetentionRepository, len(backends))
+	for i, backend := range backends {
+		repo, ok := findRepository[RetentionRepository](backend.Repository)
+		if !ok {
+			return fmt.Errorf("fan-out backend %s does not support changing retention", backend.Name)
+		}
+		repos[i] = repo
+	}
+
+	var errs []error
+	for i, repo := range repos {
+		if err := repo.SetRetention(ctx, retention); err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", backends[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EnsureIndexes creates the indexes of every backend
+func (r *FanOutRepository) EnsureIndexes(ctx context.Context) error {
+	var errs []error
+	for _, backend := range r.backends() {
+		if err := backend.Repository.EnsureIndexes(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", backend.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every backend
+func (r *FanOutRepository) Close(ctx context.Context) error {
+	var errs []error
+	for _, backend := range r.backends() {
+		if err := backend.Repository.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", backend.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the write and divergence counters
+func (r *FanOutRepository) Stats() FanOutStats {
+	stats := FanOutStats{Divergent: r.divergent.Load()}
+	for _, backend := range r.backends() {
+		stats.Backends = append(stats.Backends, FanOutBackendStats{
+			Name:    backend.Name,
+			Mode:    backend.Mode,
+			Written: backend.written.Load(),
+			Failed:  backend.failed.Load(),
+		})
+	}
+	return stats
+}
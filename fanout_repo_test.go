@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestFanOut returns a fan-out repository over a primary, a required and
+// a best-effort secondary kept in memory
+func newTestFanOut(t *testing.T) (*FanOutRepository, []*memoryRepository, *[]string) {
+	t.Helper()
+
+	repos := []*memoryRepository{{}, {}, {}}
+	var diverged []string
+	fanout, err := NewFanOutRepository(FanOutConfig{
+		Primary: FanOutBackend{Name: "primary", Repository: repos[0]},
+		Secondaries: []FanOutBackend{
+			{Name: "required", Repository: repos[1]},
+			{Name: "best_effort", Repository: repos[2], Mode: BackendBestEffort},
+		},
+		OnDivergence: func(backend string, entry AuditEntry, err error) {
+			diverged = append(diverged, backend)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOutRepository failed: %v", err)
+	}
+	return fanout, repos, &diverged
+}
+
+func TestNewFanOutRepositoryValidation(t *testing.T) {
+	repo := &memoryRepository{}
+	tests := map[string]FanOutConfig{
+		"no primary":     {},
+		"no repository":  {Primary: FanOutBackend{Name: "a", Repository: repo}, Secondaries: []FanOutBackend{{Name: "b"}}},
+		"duplicate name": {Primary: FanOutBackend{Name: "a", Repository: repo}, Secondaries: []FanOutBackend{{Name: "a", Repository: repo}}},
+		"invalid mode":   {Primary: FanOutBackend{Name: "a", Repository: repo}, Secondaries: []FanOutBackend{{Name: "b", Repository: repo, Mode: "sometimes"}}},
+		"unknown read":   {Primary: FanOutBackend{Name: "a", Repository: repo}, ReadFrom: "b"},
+	}
+	for name, config := range tests {
+		if _, err := NewFanOutRepository(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestFanOutRepositoryInsert(t *testing.T) {
+	ctx := context.Background()
+	fanout, repos, diverged := newTestFanOut(t)
+
+	// Every backend stores the entry with the same ID and timestamp
+	entry := testEntry("doc1")
+	entry.ID = [12]byte{}
+	entry.Timestamp = time.Time{}
+	insertEntries(t, fanout, entry)
+	stored := repos[0].stored()
+	if len(stored) != 1 || stored[0].ID.IsZero() || stored[0].Timestamp.IsZero() {
+		t.Fatalf("primary stored %+v", stored)
+	}
+	for i, repo := range repos[1:] {
+		if got := repo.stored(); len(got) != 1 || got[0].ID != stored[0].ID || !got[0].Timestamp.Equal(stored[0].Timestamp) {
+			t.Errorf("secondary %d stored %+v", i+1, got)
+		}
+	}
+
+	// A best-effort failure is only counted
+	repos[2].failWith(errors.New("unavailable"))
+	insertEntries(t, fanout, testEntry("doc2"))
+
+	// A required failure fails the insert
+	repos[2].failWith(nil)
+	repos[1].failWith(errors.New("unavailable"))
+	if err := fanout.Insert(ctx, testEntry("doc3")); err == nil {
+		t.Fatal("expected the required backend's error")
+	}
+
+	// A primary failure is returned before the secondaries are written
+	repos[1].failWith(nil)
+	repos[0].failWith(errors.New("unavailable"))
+	if err := fanout.Insert(ctx, testEntry("doc4")); err == nil {
+		t.Fatal("expected the primary's error")
+	}
+	if n := len(repos[2].stored()); n != 2 {
+		t.Errorf("best-effort backend stored %d entries, want 2", n)
+	}
+
+	if got := *diverged; len(got) != 2 || got[0] != "best_effort" || got[1] != "required" {
+		t.Errorf("divergences reported for %v", got)
+	}
+	stats := fanout.Stats()
+	want := []FanOutBackendStats{
+		{Name: "primary", Mode: BackendRequired, Written: 3, Failed: 1},
+		{Name: "required", Mode: BackendRequired, Written: 2, Failed: 1},
+		{Name: "best_effort", Mode: BackendBestEffort, Written: 2, Failed: 1},
+	}
+	if stats.Divergent != 2 || len(stats.Backends) != len(want) {
+		t.Fatalf("stats %+v", stats)
+	}
+	for i := range want {
+		if stats.Backends[i] != want[i] {
+			t.Errorf("backend stats %+v, want %+v", stats.Backends[i], want[i])
+		}
+	}
+}
+
+func TestFanOutRepositoryInsertDuplicate(t *testing.T) {
+	ctx := context.Background()
+	fanout, repos, diverged := newTestFanOut(t)
+
+	// The primary already holds the entry, but a secondary missed it
+	entry := testEntry("doc1")
+	insertEntries(t, repos[0], entry)
+	insertEntries(t, repos[2], entry)
+
+	if err := fanout.Insert(ctx, entry); !IsDuplicateEntry(err) {
+		t.Fatalf("Insert = %v, want the primary's duplicate error", err)
+	}
+	for i, repo := range repos {
+		if n := len(repo.stored()); n != 1 {
+			t.Errorf("backend %d stored %d entries, want 1", i, n)
+		}
+	}
+	if len(*diverged) != 0 || fanout.Stats().Divergent != 0 {
+		t.Errorf("duplicates counted as divergence: %v", *diverged)
+	}
+}
+
+func TestFanOutRepositoryReads(t *testing.T) {
+	ctx := context.Background()
+	primary := openTestFileRepository(t)
+	secondary := openTestFileRepository(t)
+	fanout, err := NewFanOutRepository(FanOutConfig{
+		Primary:     FanOutBackend{Name: "primary", Repository: primary},
+		Secondaries: []FanOutBackend{{Name: "secondary", Repository: secondary}},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOutRepository failed: %v", err)
+	}
+
+	// Entries only in one backend show which backend serves reads
+	onlyPrimary, onlySecondary := testEntry("doc1"), testEntry("doc2")
+	insertEntries(t, primary, onlyPrimary)
+	insertEntries(t, secondary, onlySecondary)
+
+	start := time.Now().Add(-time.Hour)
+	end := time.Now().Add(time.Hour)
+	for _, tt := range []struct {
+		read string
+		want AuditEntry
+	}{
+		{"primary", onlyPrimary},
+		{"secondary", onlySecondary},
+	} {
+		if err := fanout.SetReadBackend(tt.read); err != nil {
+			t.Fatalf("SetReadBackend failed: %v", err)
+		}
+		if entry, err := fanout.FindByID(ctx, tt.want.ID.Hex()); err != nil || entry == nil {
+			t.Errorf("FindByID from %s = %v, %v", tt.read, entry, err)
+		}
+		entries, err := fanout.FindRange(ctx, start, end, nil, 10)
+		if err != nil || len(entries) != 1 || entries[0].ID != tt.want.ID {
+			t.Errorf("FindRange from %s = %+v, %v", tt.read, entries, err)
+		}
+	}
+	if err := fanout.SetReadBackend("unknown"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+
+	// A read backend without range reads is reported
+	other, err := NewFanOutRepository(FanOutConfig{Primary: FanOutBackend{Name: "memory", Repository: &memoryRepository{}}})
+	if err != nil {
+		t.Fatalf("NewFanOutRepository failed: %v", err)
+	}
+	if _, err := other.FindRange(ctx, start, end, nil, 10); err == nil {
+		t.Error("expected an error for a read backend without range reads")
+	}
+}
+
+func TestFanOutRepositorySetRetention(t *testing.T) {
+	ctx := context.Background()
+	primary := openTestFileRepository(t)
+	secondary := openTestFileRepository(t)
+	fanout, err := NewFanOutRepository(FanOutConfig{
+		Primary:     FanOutBackend{Name: "primary", Repository: primary},
+		Secondaries: []FanOutBackend{{Name: "secondary", Repository: secondary}},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOutRepository failed: %v", err)
+	}
+
+	if err := fanout.SetRetention(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("SetRetention failed: %v", err)
+	}
+	for name, repo := range map[string]*FileRepository{"primary": primary, "secondary": secondary} {
+		if got := time.Duration(repo.retention.Load()); got != 24*time.Hour {
+			t.Errorf("%s retention %v, want 24h", name, got)
+		}
+	}
+
+	// Nothing changes unless every backend supports retention
+	mixed, err := NewFanOutRepository(FanOutConfig{
+		Primary:     FanOutBackend{Name: "primary", Repository: primary},
+		Secondaries: []FanOutBackend{{Name: "memory", Repository: &memoryRepository{}}},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOutRepository failed: %v", err)
+	}
+	if err := mixed.SetRetention(ctx, time.Hour); err == nil {
+		t.Fatal("expected an error for a backend without retention")
+	}
+	if got := time.Duration(primary.retention.Load()); got != 24*time.Hour {
+		t.Errorf("primary retention changed to %v", got)
+	}
+}
@@ -0,0 +1,907 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	fileSegmentPrefix = "data-"
+	fileSegmentSuffix = ".log"
+	fileCompactSuffix = ".compact"
+)
+
+// FileRepository is an AuditRepository storing entries in append-only,
+// checksummed segment files on local disk, for deployments without a
+// database. Secondary indexes by actor, resource, action and time are kept in
+// memory and rebuilt from the segments on open. A directory must only be used
+// by one process.
+type FileRepository struct {
+	mu     sync.RWMutex
+	dir    string
+	config *Config
+
+	segments []*fileSegment // ordered oldest first; the last one is active
+
+	records    map[primitive.ObjectID]*fileRecord
+	byTime     []*fileRecord // every record, ordered by timestamp then ID
+	byActor    map[string][]*fileRecord
+	byResource map[fileResourceKey][]*fileRecord
+	byAction   map[AuditAction][]*fileRecord
+
+	corrupted int64 // segments whose tail failed checksum verification
+	closed    bool
+	broken    error // set when a failed record could not be removed; inserts fail with it
+
+	retention atomic.Int64 // FileRetention, changeable with SetRetention
+
+	compactMu sync.Mutex // serialises compactions
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// fileSegment describes one segment file
+type fileSegment struct {
+	seq  uint64
+	file segmentFile // read-write for the active segment, read-only otherwise
+	size int64       // bytes of valid records
+	dead int64       // bytes of valid records that are not indexed
+}
+
+// segmentFile is the open file of a segment, an *os.File outside of tests
+type segmentFile interface {
+	io.ReaderAt
+	io.WriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+	Stat() (os.FileInfo, error)
+}
+
+// fileResourceKey identifies a resource in the resource index
+type fileResourceKey struct {
+	resourceType string
+	resourceID   string
+}
+
+// fileRecord is the in-memory index entry of a stored audit entry
+type fileRecord struct {
+	id        primitive.ObjectID
+	timestamp time.Time
+	tenantID  string
+	action    AuditAction
+	actorID   string
+	actorType ActorType
+	resource  fileResourceKey
+
+	segment *fileSegment
+	offset  int64
+	size    int64
+}
+
+// FileRepositoryStats represents counters describing a file repository
+type FileRepositoryStats struct {
+	Entries   int   `json:"entries"`
+	Segments  int   `json:"segments"`
+	Bytes     int64 `json:"bytes"`
+	Corrupted int64 `json:"corrupted"` // segments with a damaged tail found on open
+}
+
+// OpenFileRepository opens the repository in config.FileDir, creating it if
+// needed. Torn records at the end of the last segment, left by a crash
+// during a write, are truncated; a damaged tail of an older segment is
+// skipped and removed by the next compaction. If FileCompactInterval is set,
+// segments are compacted in the background.
+func OpenFileRepository(config *Config) (*FileRepository, error) {
+	if config.FileDir == "" {
+		return nil, ErrInvalidConfig{Field: "FileDir", Message: "cannot be empty"}
+	}
+	if err := config.validateFile(); err != nil {
+		return nil, err
+	}
+	if config.TenantMode == TenantCollection {
+		return nil, ErrInvalidConfig{Field: "TenantMode", Message: "the collection-per-tenant mode is only supported by MongoDB"}
+	}
+	if err := os.MkdirAll(config.FileDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	r := &FileRepository{
+		dir:        config.FileDir,
+		config:     config,
+		records:    make(map[primitive.ObjectID]*fileRecord),
+		byActor:    make(map[string][]*fileRecord),
+		byResource: make(map[fileResourceKey][]*fileRecord),
+		byAction:   make(map[AuditAction][]*fileRecord),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	r.retention.Store(int64(config.FileRetention))
+
+	if err := r.load(); err != nil {
+		r.closeFiles()
+		return nil, err
+	}
+
+	if config.FileCompactInterval > 0 {
+		go r.compactLoop()
+	} else {
+		close(r.done)
+	}
+
+	return r, nil
+}
+
+// load removes interrupted compactions, then scans the segments and
+// rebuilds the indexes
+func (r *FileRepository) load() error {
+	leftovers, err := filepath.Glob(filepath.Join(r.dir, fileSegmentPrefix+"*"+fileSegmentSuffix+fileCompactSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list storage segments: %w", err)
+	}
+	for _, name := range leftovers {
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove interrupted compaction: %w", err)
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(r.dir, fileSegmentPrefix+"*"+fileSegmentSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list storage segments: %w", err)
+	}
+
+	for _, name := range names {
+		seqStr := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), fileSegmentPrefix), fileSegmentSuffix)
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		r.segments = append(r.segments, &fileSegment{seq: seq})
+	}
+	sort.Slice(r.segments, func(i, j int) bool { return r.segments[i].seq < r.segments[j].seq })
+
+	if len(r.segments) == 0 {
+		return r.rotate()
+	}
+
+	for i, seg := range r.segments {
+		active := i == len(r.segments)-1
+
+		flag := os.O_RDONLY
+		if active {
+			flag = os.O_RDWR
+		}
+		f, err := os.OpenFile(r.segmentPath(seg.seq), flag, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open storage segment: %w", err)
+		}
+		seg.file = f
+
+		if err := r.scanSegment(seg, active); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanSegment indexes the valid records of a segment, stopping at the first
+// record that is truncated or fails its checksum
+func (r *FileRepository) scanSegment(seg *fileSegment, active bool) error {
+	info, err := seg.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat storage segment: %w", err)
+	}
+
+	var offset int64
+	for {
+		payload, n, err := readSpoolRecord(seg.file, offset)
+		if err != nil {
+			break
+		}
+
+		var entry AuditEntry
+		if err := bson.Unmarshal(payload, &entry); err != nil {
+			break
+		}
+
+		// A record may be present twice if a crash interrupted a compaction
+		if _, ok := r.records[entry.ID]; ok {
+			seg.dead += n
+		} else {
+			r.index(newFileRecord(entry, seg, offset, n))
+		}
+		offset += n
+	}
+	seg.size = offset
+
+	if offset < info.Size() {
+		if !active {
+			r.corrupted++
+			return nil
+		}
+		// Drop a torn tail so new records are appended after valid data
+		if err := seg.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate storage segment: %w", err)
+		}
+	}
+	if active {
+		if _, err := seg.file.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek storage segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// newFileRecord builds the index entry of an entry stored at offset
+func newFileRecord(entry AuditEntry, seg *fileSegment, offset, size int64) *fileRecord {
+	return &fileRecord{
+		id:        entry.ID,
+		timestamp: entry.Timestamp,
+		tenantID:  entry.TenantID,
+		action:    entry.Action,
+		actorID:   entry.Actor.ID,
+		actorType: entry.Actor.Type,
+		resource:  fileResourceKey{resourceType: entry.Resource.Type, resourceID: entry.Resource.ID},
+		segment:   seg,
+		offset:    offset,
+		size:      size,
+	}
+}
+
+// rotate seals the active segment and starts a new one. Must be called with
+// mu held.
+func (r *FileRepository) rotate() error {
+	var seq uint64 = 1
+	if len(r.segments) > 0 {
+		seq = r.segments[len(r.segments)-1].seq + 1
+	}
+
+	f, err := os.OpenFile(r.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create storage segment: %w", err)
+	}
+	if err := syncDir(r.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	r.segments = append(r.segments, &fileSegment{seq: seq, file: f})
+	return nil
+}
+
+// Insert durably appends a new audit entry
+func (r *FileRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if r.config.TenantMode != TenantNone && entry.TenantID == "" {
+		return ErrTenantRequired{Operation: "insert an audit entry"}
+	}
+
+	payload, err := bson.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if len(payload) > spoolMaxRecordSize {
+		return fmt.Errorf("audit entry of %d bytes exceeds the maximum record size", len(payload))
+	}
+	record := encodeSpoolRecord(payload)
+	size := int64(len(record))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("audit file repository is closed")
+	}
+	if r.broken != nil {
+		return r.broken
+	}
+	if _, ok := r.records[entry.ID]; ok {
+		return ErrDuplicateEntry{ID: entry.ID.Hex()}
+	}
+
+	active := r.segments[len(r.segments)-1]
+	if active.size > 0 && active.size+size > r.config.FileSegmentBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+		active = r.segments[len(r.segments)-1]
+	}
+
+	if _, err := active.file.Write(record); err != nil {
+		return r.discardFailed(active, fmt.Errorf("failed to write audit entry: %w", err))
+	}
+	if err := active.file.Sync(); err != nil {
+		// The entry is reported as failed, so it must not reappear on reopen
+		// or be followed by the next record
+		return r.discardFailed(active, fmt.Errorf("failed to sync audit entry: %w", err))
+	}
+
+	r.index(newFileRecord(entry, active, active.size, size))
+	active.size += size
+	return nil
+}
+
+// discardFailed removes a failed record from the active segment and returns
+// cause. If the segment cannot be rolled back, it is sealed and a new one
+// started, so that the next record is not written after the failed one; the
+// failed record may then reappear on reopen until the segment is compacted.
+// If no new segment can be started either, later inserts fail. Must be
+// called with mu held.
+func (r *FileRepository) discardFailed(active *fileSegment, cause error) error {
+	err := active.discardFrom(active.size)
+	if err == nil {
+		return cause
+	}
+	if rotateErr := r.rotate(); rotateErr != nil {
+		r.broken = fmt.Errorf("audit file repository cannot write: %w", errors.Join(err, rotateErr))
+		return errors.Join(cause, r.broken)
+	}
+	return errors.Join(cause, err)
+}
+
+// discardFrom truncates a partially written or unsynced record at offset so
+// the segment stays readable and the next record is appended at offset
+func (seg *fileSegment) discardFrom(offset int64) error {
+	if err := seg.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate storage segment: %w", err)
+	}
+	if _, err := seg.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek storage segment: %w", err)
+	}
+	return nil
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *FileRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("audit file repository is closed")
+	}
+
+	candidates := r.candidates(query)
+	lo, hi := timeRange(candidates, query.StartTime, query.EndTime)
+
+	// Filters on fields that are not indexed need the stored entry
+	needEntry := query.SessionID != "" || query.Success != nil || query.CorrelationID != "" ||
+		query.TraceID != "" || query.ParentID != ""
+
+	var total int64
+	var entries []AuditEntry
+	for i := hi - 1; i >= lo; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		rec := candidates[i]
+		if !rec.matches(query) {
+			continue
+		}
+
+		var entry AuditEntry
+		loaded := false
+		if needEntry {
+			var err error
+			if entry, err = r.readEntry(rec); err != nil {
+				return nil, err
+			}
+			if !matchesQuery(entry, query) {
+				continue
+			}
+			loaded = true
+		}
+
+		total++
+		if total <= int64(query.Offset) || (query.Limit > 0 && len(entries) >= query.Limit) {
+			continue
+		}
+		if !loaded {
+			var err error
+			if entry, err = r.readEntry(rec); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	hasMore := false
+	if query.Limit > 0 && int64(query.Offset+len(entries)) < total {
+		hasMore = true
+	}
+
+	return &AuditQueryResult{
+		Entries: entries,
+		Total:   total,
+		HasMore: hasMore,
+	}, nil
+}
+
+// FindRange finds the entries of [start, end) after a cursor, oldest first.
+// Lookups through a tenant-scoped handle only read the tenant's entries.
+func (r *FileRepository) FindRange(ctx context.Context, start, end time.Time, after *RangeCursor, limit int) ([]AuditEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("audit file repository is closed")
+	}
+
+	lo := sort.Search(len(r.byTime), func(i int) bool { return !r.byTime[i].timestamp.Before(start) })
+	if after != nil {
+		cursor := &fileRecord{id: after.ID, timestamp: after.Timestamp}
+		lo = max(lo, sort.Search(len(r.byTime), func(i int) bool { return recordLess(cursor, r.byTime[i]) }))
+	}
+	tenantID := tenantFromContext(ctx)
+
+	var entries []AuditEntry
+	for i := lo; i < len(r.byTime) && r.byTime[i].timestamp.Before(end); i++ {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		rec := r.byTime[i]
+		if tenantID != "" && rec.tenantID != tenantID {
+			continue
+		}
+
+		entry, err := r.readEntry(rec)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// candidates returns the smallest index list that covers the query, ordered
+// by timestamp. Must be called with mu held.
+func (r *FileRepository) candidates(query AuditQuery) []*fileRecord {
+	best := r.byTime
+
+	if query.ResourceType != "" && query.ResourceID != "" {
+		if list := r.byResource[fileResourceKey{query.ResourceType, query.ResourceID}]; len(list) < len(best) {
+			best = list
+		}
+	}
+	if query.ActorID != "" {
+		if list := r.byActor[query.ActorID]; len(list) < len(best) {
+			best = list
+		}
+	}
+	if len(query.Actions) > 0 {
+		var lists [][]*fileRecord
+		size := 0
+		for action := range uniqueActions(query.Actions) {
+			lists = append(lists, r.byAction[action])
+			size += len(r.byAction[action])
+		}
+		if size < len(best) {
+			best = make([]*fileRecord, 0, size)
+			for _, list := range lists {
+				best = append(best, list...)
+			}
+			sort.Slice(best, func(i, j int) bool { return recordLess(best[i], best[j]) })
+		}
+	}
+
+	return best
+}
+
+// uniqueActions returns the set of actions in a query
+func uniqueActions(actions []AuditAction) map[AuditAction]struct{} {
+	set := make(map[AuditAction]struct{}, len(actions))
+	for _, action := range actions {
+		set[action] = struct{}{}
+	}
+	return set
+}
+
+// timeRange returns the bounds of the records of a timestamp-ordered list
+// that fall within [start, end]
+func timeRange(list []*fileRecord, start, end *time.Time) (int, int) {
+	lo, hi := 0, len(list)
+	if start != nil {
+		lo = sort.Search(len(list), func(i int) bool { return !list[i].timestamp.Before(*start) })
+	}
+	if end != nil {
+		hi = sort.Search(len(list), func(i int) bool { return list[i].timestamp.After(*end) })
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// matches checks the indexed fields of a record against a query
+func (rec *fileRecord) matches(query AuditQuery) bool {
+	if query.TenantID != "" && rec.tenantID != query.TenantID {
+		return false
+	}
+	if query.ActorID != "" && rec.actorID != query.ActorID {
+		return false
+	}
+	if query.ActorType != "" && rec.actorType != query.ActorType {
+		return false
+	}
+	if len(query.Actions) > 0 && !containsAction(query.Actions, rec.action) {
+		return false
+	}
+	if query.ResourceType != "" && rec.resource.resourceType != query.ResourceType {
+		return false
+	}
+	if query.ResourceID != "" && rec.resource.resourceID != query.ResourceID {
+		return false
+	}
+	return true
+}
+
+// readEntry reads and decodes the entry of a record. Must be called with mu held.
+func (r *FileRepository) readEntry(rec *fileRecord) (AuditEntry, error) {
+	payload, _, err := readSpoolRecord(rec.segment.file, rec.offset)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to read audit entry %s: %w", rec.id.Hex(), err)
+	}
+
+	var entry AuditEntry
+	if err := bson.Unmarshal(payload, &entry); err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to decode audit entry %s: %w", rec.id.Hex(), err)
+	}
+	return entry, nil
+}
+
+// FindByID finds an audit entry by its ID
+func (r *FileRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID format: %w", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.records[objectID]
+	if !ok {
+		return nil, nil
+	}
+
+	entry, err := r.readEntry(rec)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *FileRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	result, err := r.FindByQuery(ctx, AuditQuery{TenantID: tenantFromContext(ctx), ResourceType: resourceType, ResourceID: resourceID, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource history: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *FileRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	result, err := r.FindByQuery(ctx, AuditQuery{TenantID: tenantFromContext(ctx), ActorID: actorID, ActorType: actorType, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actor history: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// EnsureIndexes does nothing: the indexes are kept in memory
+func (r *FileRepository) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+// SetRetention replaces FileRetention; the next compaction applies it
+func (r *FileRepository) SetRetention(ctx context.Context, retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("retention cannot be negative")
+	}
+	r.retention.Store(int64(retention))
+	return nil
+}
+
+// Compact rewrites sealed segments without entries older than FileRetention
+// and without damaged or duplicated records, and returns the number of
+// entries removed. Segments left empty are deleted. Inserts and queries
+// continue while a segment is rewritten.
+func (r *FileRepository) Compact(ctx context.Context) (int, error) {
+	r.compactMu.Lock()
+	defer r.compactMu.Unlock()
+
+	var cutoff time.Time
+	if retention := time.Duration(r.retention.Load()); retention > 0 {
+		cutoff = time.Now().Add(-retention)
+	}
+
+	// Sealed segments receive no inserts, so their records only change here
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return 0, fmt.Errorf("audit file repository is closed")
+	}
+	sealed := append([]*fileSegment(nil), r.segments[:len(r.segments)-1]...)
+	bySegment := make(map[*fileSegment][]*fileRecord)
+	for _, rec := range r.byTime {
+		bySegment[rec.segment] = append(bySegment[rec.segment], rec)
+	}
+	r.mu.RUnlock()
+
+	removed := 0
+	for _, seg := range sealed {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		recs := bySegment[seg]
+		sort.Slice(recs, func(i, j int) bool { return recs[i].offset < recs[j].offset })
+
+		var keep, expired []*fileRecord
+		for _, rec := range recs {
+			if rec.timestamp.Before(cutoff) {
+				expired = append(expired, rec)
+			} else {
+				keep = append(keep, rec)
+			}
+		}
+
+		info, err := seg.file.Stat()
+		if err != nil {
+			return removed, fmt.Errorf("failed to stat storage segment: %w", err)
+		}
+		if len(expired) == 0 && seg.dead == 0 && info.Size() == seg.size {
+			continue
+		}
+
+		if err := r.rewriteSegment(seg, keep, expired); err != nil {
+			return removed, err
+		}
+		removed += len(expired)
+	}
+
+	return removed, nil
+}
+
+// rewriteSegment replaces a sealed segment by one holding only the kept
+// records, or deletes it if none are kept
+func (r *FileRepository) rewriteSegment(seg *fileSegment, keep, expired []*fileRecord) error {
+	path := r.segmentPath(seg.seq)
+
+	var file segmentFile
+	offsets := make([]int64, len(keep))
+	var size int64
+	if len(keep) > 0 {
+		tmp := path + fileCompactSuffix
+		out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create compacted segment: %w", err)
+		}
+
+		for i, rec := range keep {
+			buf := make([]byte, rec.size)
+			if _, err := seg.file.ReadAt(buf, rec.offset); err != nil {
+				out.Close()
+				os.Remove(tmp)
+				return fmt.Errorf("failed to read storage segment: %w", err)
+			}
+			if _, err := out.Write(buf); err != nil {
+				out.Close()
+				os.Remove(tmp)
+				return fmt.Errorf("failed to write compacted segment: %w", err)
+			}
+			offsets[i] = size
+			size += rec.size
+		}
+		if err := out.Sync(); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to sync compacted segment: %w", err)
+		}
+		out.Close()
+
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to replace storage segment: %w", err)
+		}
+		opened, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open storage segment: %w", err)
+		}
+		file = opened
+	} else if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove storage segment: %w", err)
+	}
+	if err := syncDir(r.dir); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := seg.file
+	for i, rec := range keep {
+		rec.offset = offsets[i]
+	}
+	for _, rec := range expired {
+		r.unindex(rec)
+	}
+	seg.file, seg.size, seg.dead = file, size, 0
+
+	if file == nil {
+		for i, candidate := range r.segments {
+			if candidate == seg {
+				r.segments = append(r.segments[:i], r.segments[i+1:]...)
+				break
+			}
+		}
+	}
+	return old.Close()
+}
+
+// compactLoop compacts segments every FileCompactInterval until Close
+func (r *FileRepository) compactLoop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.FileCompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-r.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			r.Compact(ctx)
+			cancel()
+		}
+	}
+}
+
+// Stats returns the current repository counters
+func (r *FileRepository) Stats() FileRepositoryStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := FileRepositoryStats{
+		Entries:   len(r.records),
+		Segments:  len(r.segments),
+		Corrupted: r.corrupted,
+	}
+	for _, seg := range r.segments {
+		stats.Bytes += seg.size
+	}
+	return stats
+}
+
+// Close stops background compaction and closes the segment files
+func (r *FileRepository) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	close(r.stop)
+	<-r.done
+
+	r.compactMu.Lock()
+	defer r.compactMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closeFiles()
+}
+
+// closeFiles closes every open segment file
+func (r *FileRepository) closeFiles() error {
+	var err error
+	for _, seg := range r.segments {
+		if seg.file == nil {
+			continue
+		}
+		if closeErr := seg.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// index adds a record to the in-memory indexes. Must be called with mu held.
+func (r *FileRepository) index(rec *fileRecord) {
+	r.records[rec.id] = rec
+	r.byTime = insertRecord(r.byTime, rec)
+	r.byActor[rec.actorID] = insertRecord(r.byActor[rec.actorID], rec)
+	r.byResource[rec.resource] = insertRecord(r.byResource[rec.resource], rec)
+	r.byAction[rec.action] = insertRecord(r.byAction[rec.action], rec)
+}
+
+// unindex removes a record from the in-memory indexes. Must be called with mu held.
+func (r *FileRepository) unindex(rec *fileRecord) {
+	delete(r.records, rec.id)
+	r.byTime = removeRecord(r.byTime, rec)
+
+	if list := removeRecord(r.byActor[rec.actorID], rec); len(list) > 0 {
+		r.byActor[rec.actorID] = list
+	} else {
+		delete(r.byActor, rec.actorID)
+	}
+	if list := removeRecord(r.byResource[rec.resource], rec); len(list) > 0 {
+		r.byResource[rec.resource] = list
+	} else {
+		delete(r.byResource, rec.resource)
+	}
+	if list := removeRecord(r.byAction[rec.action], rec); len(list) > 0 {
+		r.byAction[rec.action] = list
+	} else {
+		delete(r.byAction, rec.action)
+	}
+}
+
+// recordLess orders records by timestamp, then by ID
+func recordLess(a, b *fileRecord) bool {
+	if !a.timestamp.Equal(b.timestamp) {
+		return a.timestamp.Before(b.timestamp)
+	}
+	return bytes.Compare(a.id[:], b.id[:]) < 0
+}
+
+// insertRecord inserts a record into an ordered list
+func insertRecord(list []*fileRecord, rec *fileRecord) []*fileRecord {
+	// Entries mostly arrive in timestamp order
+	if len(list) == 0 || recordLess(list[len(list)-1], rec) {
+		return append(list, rec)
+	}
+
+	i := sort.Search(len(list), func(i int) bool { return recordLess(rec, list[i]) })
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = rec
+	return list
+}
+
+// removeRecord removes a record from an ordered list
+func removeRecord(list []*fileRecord, rec *fileRecord) []*fileRecord {
+	i := sort.Search(len(list), func(i int) bool { return !recordLess(list[i], rec) })
+	if i < len(list) && list[i] == rec {
+		return append(list[:i], list[i+1:]...)
+	}
+	return list
+}
+
+// segmentPath returns the file path of a segment
+func (r *FileRepository) segmentPath(seq uint64) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s%020d%s", fileSegmentPrefix, seq, fileSegmentSuffix))
+}
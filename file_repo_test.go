@@ -0,0 +1,301 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// failingFile is a segment file whose Sync and Truncate fail when set
+type failingFile struct {
+	segmentFile
+	syncErr     error
+	truncateErr error
+}
+
+func (f *failingFile) Sync() error {
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.segmentFile.Sync()
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.segmentFile.Truncate(size)
+}
+
+// testFileConfig returns a file repository configuration in a temporary directory
+func testFileConfig(t *testing.T) *Config {
+	t.Helper()
+	config := DefaultConfig()
+	config.FileDir = t.TempDir()
+	return config
+}
+
+// reopenFileRepository closes a repository and opens it again
+func reopenFileRepository(t *testing.T, repo *FileRepository) *FileRepository {
+	t.Helper()
+	if err := repo.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reopened, err := OpenFileRepository(repo.config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close(context.Background()) })
+	return reopened
+}
+
+// assertFound checks which of the given entries a repository holds
+func assertFound(t *testing.T, repo AuditRepository, entries map[*AuditEntry]bool) {
+	t.Helper()
+	for entry, want := range entries {
+		found, err := repo.FindByID(context.Background(), entry.ID.Hex())
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if (found != nil) != want {
+			t.Errorf("entry %s found = %v, want %v", entry.Resource.ID, found != nil, want)
+		} else if found != nil && found.Resource.ID != entry.Resource.ID {
+			t.Errorf("entry %s read back as %s", entry.Resource.ID, found.Resource.ID)
+		}
+	}
+}
+
+func TestFileRepositoryTruncatesTornTail(t *testing.T) {
+	repo := openTestFileRepository(t)
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	insertEntries(t, repo, entries...)
+	if err := repo.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A crash in the middle of the last write leaves part of a record
+	path := repo.segmentPath(1)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	reopened, err := OpenFileRepository(repo.config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close(context.Background()) })
+
+	// New records follow the last valid one
+	next := testEntry("doc4")
+	insertEntries(t, reopened, next)
+	reopened = reopenFileRepository(t, reopened)
+
+	if stats := reopened.Stats(); stats.Entries != 3 {
+		t.Errorf("stats %+v, want 3 entries", stats)
+	}
+	assertFound(t, reopened, map[*AuditEntry]bool{&entries[0]: true, &entries[1]: true, &entries[2]: false, &next: true})
+}
+
+func TestFileRepositorySkipsDuplicateRecords(t *testing.T) {
+	ctx := context.Background()
+	config := testFileConfig(t)
+	config.FileSegmentBytes = 1
+	repo, err := OpenFileRepository(config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	insertEntries(t, repo, entries...)
+	if err := repo.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A compaction interrupted after writing a record into another segment
+	// leaves it stored twice
+	first, err := os.ReadFile(repo.segmentPath(1))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	second, err := os.ReadFile(repo.segmentPath(2))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if err := os.WriteFile(repo.segmentPath(2), append(first, second...), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	repo, err = OpenFileRepository(config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close(ctx) })
+
+	stats := repo.Stats()
+	if stats.Entries != 3 {
+		t.Fatalf("stats %+v, want 3 entries", stats)
+	}
+	result, err := repo.FindByQuery(ctx, AuditQuery{})
+	if err != nil || result.Total != 3 {
+		t.Fatalf("FindByQuery = %+v, %v; want 3 entries", result, err)
+	}
+
+	// Compaction removes the duplicate
+	if _, err := repo.Compact(ctx); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if compacted := repo.Stats(); compacted.Bytes != stats.Bytes-int64(len(first)) {
+		t.Errorf("%d bytes after compaction, want %d", compacted.Bytes, stats.Bytes-int64(len(first)))
+	}
+	repo = reopenFileRepository(t, repo)
+	assertFound(t, repo, map[*AuditEntry]bool{&entries[0]: true, &entries[1]: true, &entries[2]: true})
+}
+
+func TestFileRepositoryCompactsExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	config := testFileConfig(t)
+	config.FileSegmentBytes = 1
+	config.FileRetention = 24 * time.Hour
+
+	// An interrupted compaction leaves a temporary segment behind
+	leftover := filepath.Join(config.FileDir, fileSegmentPrefix+"00000000000000000001"+fileSegmentSuffix+fileCompactSuffix)
+	if err := os.WriteFile(leftover, []byte("partial"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	repo, err := OpenFileRepository(config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close(ctx) })
+	if _, err := os.Stat(leftover); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("interrupted compaction not removed: %v", err)
+	}
+
+	expired := []AuditEntry{testEntry("doc1"), testEntry("doc2")}
+	for i := range expired {
+		expired[i].Timestamp = time.Now().Add(-48 * time.Hour).UTC()
+	}
+	kept := []AuditEntry{testEntry("doc3"), testEntry("doc4")}
+	insertEntries(t, repo, expired[0], kept[0], expired[1], kept[1])
+
+	// The active segment is never compacted
+	removed, err := repo.Compact(ctx)
+	if err != nil || removed != 2 {
+		t.Fatalf("Compact = %d, %v; want 2", removed, err)
+	}
+	if stats := repo.Stats(); stats.Entries != 2 || stats.Segments != 2 {
+		t.Errorf("stats %+v, want 2 entries in 2 segments", stats)
+	}
+
+	repo = reopenFileRepository(t, repo)
+	assertFound(t, repo, map[*AuditEntry]bool{&expired[0]: false, &expired[1]: false, &kept[0]: true, &kept[1]: true})
+}
+
+func TestFileRepositoryFindByQuery(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestFileRepository(t)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	for i := 0; i < 10; i++ {
+		entry := testEntry("doc1")
+		entry.Timestamp = base.Add(time.Duration(i) * time.Minute)
+		if i%2 == 1 {
+			entry.Actor.ID = "user456"
+		}
+		if i%3 == 0 {
+			entry.Action = ActionUpdate
+			entry.Success = false
+		}
+		entries = append(entries, entry)
+	}
+	// Entries arrive out of order
+	insertEntries(t, repo, entries[5:]...)
+	insertEntries(t, repo, entries[:5]...)
+
+	ids := func(list []AuditEntry) []string {
+		var out []string
+		for _, entry := range list {
+			out = append(out, entry.ID.Hex())
+		}
+		return out
+	}
+	want := func(indexes ...int) []string {
+		var out []string
+		for _, i := range indexes {
+			out = append(out, entries[i].ID.Hex())
+		}
+		return out
+	}
+	success := true
+	start, end := base.Add(2*time.Minute), base.Add(7*time.Minute)
+
+	tests := []struct {
+		name    string
+		query   AuditQuery
+		want    []string
+		total   int64
+		hasMore bool
+	}{
+		{"all newest first", AuditQuery{Limit: 3}, want(9, 8, 7), 10, true},
+		{"offset", AuditQuery{Offset: 8, Limit: 3}, want(1, 0), 10, false},
+		{"actor", AuditQuery{ActorID: "user456", Offset: 1, Limit: 2}, want(7, 5), 5, true},
+		{"actions", AuditQuery{Actions: []AuditAction{ActionUpdate}}, want(9, 6, 3, 0), 4, false},
+		{"time range", AuditQuery{StartTime: &start, EndTime: &end, Limit: 10}, want(7, 6, 5, 4, 3, 2), 6, false},
+		{"stored field", AuditQuery{ActorID: "user123", Success: &success, Limit: 1}, want(8), 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := repo.FindByQuery(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("FindByQuery failed: %v", err)
+			}
+			if got := ids(result.Entries); !slices.Equal(got, tt.want) {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+			if result.Total != tt.total || result.HasMore != tt.hasMore {
+				t.Errorf("total %d, has more %v; want %d, %v", result.Total, result.HasMore, tt.total, tt.hasMore)
+			}
+		})
+	}
+}
+
+func TestFileRepositoryRollsBackFailedSync(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestFileRepository(t)
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3"), testEntry("doc4"), testEntry("doc5")}
+	insertEntries(t, repo, entries[0])
+
+	active := repo.segments[len(repo.segments)-1]
+	file := &failingFile{segmentFile: active.file, syncErr: errors.New("disk error")}
+	active.file = file
+
+	// The failed entry is removed and the next one is written in its place
+	if err := repo.Insert(ctx, entries[1]); err == nil {
+		t.Fatal("expected the sync error")
+	}
+	file.syncErr = nil
+	insertEntries(t, repo, entries[2])
+
+	// When the segment cannot be rolled back either, writing moves to a new one
+	file.syncErr = errors.New("disk error")
+	file.truncateErr = errors.New("read-only file system")
+	if err := repo.Insert(ctx, entries[3]); err == nil {
+		t.Fatal("expected the sync error")
+	}
+	if stats := repo.Stats(); stats.Segments != 2 {
+		t.Fatalf("stats %+v, want a new segment", stats)
+	}
+	insertEntries(t, repo, entries[4])
+
+	assertFound(t, repo, map[*AuditEntry]bool{&entries[0]: true, &entries[1]: false, &entries[2]: true, &entries[3]: false, &entries[4]: true})
+	repo = reopenFileRepository(t, repo)
+	assertFound(t, repo, map[*AuditEntry]bool{&entries[0]: true, &entries[1]: false, &entries[2]: true, &entries[4]: true})
+}
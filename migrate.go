@@ -0,0 +1,498 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrationConfig represents the configuration of a migration
+type MigrationConfig struct {
+	// Time range to copy. A zero From starts at the oldest source entry; a
+	// zero To copies up to the time the migration starts.
+	From time.Time
+	To   time.Time
+
+	Window    time.Duration // span of the time windows copied, checkpointed and verified one by one
+	BatchSize int           // entries read from the source per query
+	RateLimit float64       // maximum entries copied per second; 0 means unlimited
+
+	// CheckpointFile records the completed windows so that an interrupted
+	// migration of the same From and To resumes where it stopped. A
+	// checkpoint of another range is ignored and replaced. Optional.
+	CheckpointFile string
+
+	// Verify compares the count and hash of every window once copied
+	Verify bool
+
+	// OnWindow is called after every window. Optional.
+	OnWindow func(window MigrationWindow)
+}
+
+// DefaultMigrationConfig returns a default migration configuration
+func DefaultMigrationConfig() MigrationConfig {
+	return MigrationConfig{
+		Window:    24 * time.Hour,
+		BatchSize: 1000,
+		Verify:    true,
+	}
+}
+
+// MigrationWindow represents the outcome of copying one time window
+type MigrationWindow struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Copied  int64     `json:"copied"`  // entries inserted into the destination
+	Skipped int64     `json:"skipped"` // entries the destination already held
+
+	// Verification is set when MigrationConfig.Verify is enabled
+	Verification *WindowVerification `json:"verification,omitempty"`
+}
+
+// WindowVerification represents the comparison of a time window between the
+// source and the destination
+type WindowVerification struct {
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	SourceCount      int64     `json:"source_count"`
+	DestinationCount int64     `json:"destination_count"`
+	SourceHash       string    `json:"source_hash"`
+	DestinationHash  string    `json:"destination_hash"`
+}
+
+// Match reports whether both sides hold the same entries
+func (v WindowVerification) Match() bool {
+	return v.SourceCount == v.DestinationCount && v.SourceHash == v.DestinationHash
+}
+
+// MigrationReport represents the outcome of a migration run
+type MigrationReport struct {
+	Windows    int                  `json:"windows"`
+	Copied     int64                `json:"copied"`
+	Skipped    int64                `json:"skipped"`
+	Mismatches []WindowVerification `json:"mismatches,omitempty"`
+}
+
+// migrationCheckpoint is the persisted progress of a migration
+type migrationCheckpoint struct {
+	From time.Time `json:"from"` // configured range the progress belongs to
+	To   time.Time `json:"to"`
+	Next time.Time `json:"next"` // start of the first window not yet completed
+}
+
+// Migrator copies entries from one repository to another. Entries keep
+// their IDs, so copying a window twice only skips the entries already
+// present; running a migration again over a live source replicates the
+// entries added since. Windows are read by keyset on timestamp and ID from
+// repositories implementing RangeRepository, and by offset otherwise.
+type Migrator struct {
+	source      AuditRepository
+	destination AuditRepository
+	config      MigrationConfig
+	limiter     *tokenBucket
+}
+
+// NewMigrator creates a migrator. Zero values in the configuration are
+// replaced by their defaults, except Verify.
+func NewMigrator(source, destination AuditRepository, config MigrationConfig) (*Migrator, error) {
+	defaults := DefaultMigrationConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.RateLimit < 0 {
+		return nil, fmt.Errorf("migration rate limit cannot be negative")
+	}
+	if !config.From.IsZero() && !config.To.IsZero() && config.From.After(config.To) {
+		return nil, fmt.Errorf("migration start cannot be after its end")
+	}
+
+	m := &Migrator{
+		source:      source,
+		destination: destination,
+		config:      config,
+	}
+	if config.RateLimit > 0 {
+		m.limiter = newTokenBucket(config.RateLimit, config.BatchSize)
+	}
+
+	return m, nil
+}
+
+// Run copies every window of the configured range, resuming after the last
+// checkpointed window. Windows ending after the run started are copied but
+// not checkpointed, since the source may still receive entries for them.
+func (m *Migrator) Run(ctx context.Context) (*MigrationReport, error) {
+	started := time.Now()
+
+	from, to, err := m.bounds(ctx, started)
+	if err != nil {
+		return nil, err
+	}
+
+	cp, err := m.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil && cp.From.Equal(m.config.From) && cp.To.Equal(m.config.To) && cp.Next.After(from) {
+		from = cp.Next
+	}
+
+	report := &MigrationReport{}
+	checkpointing := true
+	for start := from; start.Before(to); start = start.Add(m.config.Window) {
+		end := start.Add(m.config.Window)
+		if end.After(to) {
+			end = to
+		}
+
+		window, err := m.copyWindow(ctx, start, end)
+		report.Copied += window.Copied
+		report.Skipped += window.Skipped
+		if err != nil {
+			return report, fmt.Errorf("failed to migrate window starting %s: %w", start.Format(time.RFC3339), err)
+		}
+
+		if m.config.Verify {
+			verification, err := m.verifyWindow(ctx, start, end)
+			if err != nil {
+				return report, fmt.Errorf("failed to verify window starting %s: %w", start.Format(time.RFC3339), err)
+			}
+			window.Verification = verification
+			if !verification.Match() {
+				report.Mismatches = append(report.Mismatches, *verification)
+			}
+		}
+		report.Windows++
+
+		if m.config.OnWindow != nil {
+			m.config.OnWindow(window)
+		}
+
+		// The next run resumes at the first window that is still open or
+		// failed verification
+		if end.After(started) || (window.Verification != nil && !window.Verification.Match()) {
+			checkpointing = false
+		}
+		if checkpointing {
+			cp := migrationCheckpoint{From: m.config.From, To: m.config.To, Next: end}
+			if err := m.saveCheckpoint(cp); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// bounds resolves the time range of the migration
+func (m *Migrator) bounds(ctx context.Context, now time.Time) (time.Time, time.Time, error) {
+	from, to := m.config.From, m.config.To
+	if to.IsZero() {
+		to = now
+	}
+	if from.IsZero() {
+		oldest, err := m.oldest(ctx)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if oldest.IsZero() {
+			return to, to, nil
+		}
+		from = oldest.Truncate(m.config.Window)
+	}
+	return from, to, nil
+}
+
+// oldest returns the timestamp of the oldest source entry, or zero if the
+// source is empty
+func (m *Migrator) oldest(ctx context.Context) (time.Time, error) {
+	if ranged, ok := findRepository[RangeRepository](m.source); ok {
+		entries, err := ranged.FindRange(ctx, time.Time{}, maxTime, nil, 1)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to find oldest source entry: %w", err)
+		}
+		if len(entries) == 0 {
+			return time.Time{}, nil
+		}
+		return entries[0].Timestamp, nil
+	}
+
+	result, err := m.source.FindByQuery(ctx, AuditQuery{Limit: 1})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to count source entries: %w", err)
+	}
+	if result.Total == 0 {
+		return time.Time{}, nil
+	}
+
+	// Results are ordered newest first
+	result, err = m.source.FindByQuery(ctx, AuditQuery{Limit: 1, Offset: int(result.Total - 1)})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to find oldest source entry: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return time.Time{}, nil
+	}
+	return result.Entries[0].Timestamp, nil
+}
+
+// maxTime is later than any entry timestamp
+var maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// windowQuery returns the query selecting the entries of [start, end)
+func windowQuery(start, end time.Time, limit, offset int) AuditQuery {
+	// EndTime is inclusive
+	last := end.Add(-time.Nanosecond)
+	return AuditQuery{StartTime: &start, EndTime: &last, Limit: limit, Offset: offset}
+}
+
+// scanWindow reads the entries of [start, end) from a repository batch by
+// batch and passes every batch to fn. Read errors are wrapped; errors of fn
+// are returned as is.
+func (m *Migrator) scanWindow(ctx context.Context, repo AuditRepository, start, end time.Time, fn func(entries []AuditEntry) error) error {
+	if ranged, ok := findRepository[RangeRepository](repo); ok {
+		var after *RangeCursor
+		for {
+			entries, err := ranged.FindRange(ctx, start, end, after, m.config.BatchSize)
+			if err != nil {
+				return fmt.Errorf("failed to read entries: %w", err)
+			}
+			if len(entries) > 0 {
+				if err := fn(entries); err != nil {
+					return err
+				}
+			}
+			if len(entries) < m.config.BatchSize {
+				return nil
+			}
+			after = CursorOf(entries[len(entries)-1])
+		}
+	}
+
+	for offset := 0; ; offset += m.config.BatchSize {
+		result, err := repo.FindByQuery(ctx, windowQuery(start, end, m.config.BatchSize, offset))
+		if err != nil {
+			return fmt.Errorf("failed to read entries: %w", err)
+		}
+		if len(result.Entries) > 0 {
+			if err := fn(result.Entries); err != nil {
+				return err
+			}
+		}
+		if !result.HasMore || len(result.Entries) == 0 {
+			return nil
+		}
+	}
+}
+
+// copyWindow copies the entries of [start, end) batch by batch
+func (m *Migrator) copyWindow(ctx context.Context, start, end time.Time) (MigrationWindow, error) {
+	window := MigrationWindow{Start: start, End: end}
+
+	err := m.scanWindow(ctx, m.source, start, end, func(entries []AuditEntry) error {
+		for _, entry := range entries {
+			if m.limiter != nil {
+				if err := m.limiter.wait(ctx); err != nil {
+					return err
+				}
+			}
+
+			err := m.destination.Insert(ctx, entry)
+			switch {
+			case err == nil:
+				window.Copied++
+			case IsDuplicateEntry(err):
+				window.Skipped++
+			default:
+				return fmt.Errorf("failed to write entry %s: %w", entry.ID.Hex(), err)
+			}
+		}
+		return nil
+	})
+	return window, err
+}
+
+// verifyWindow compares the counts and hashes of [start, end) on both sides
+func (m *Migrator) verifyWindow(ctx context.Context, start, end time.Time) (*WindowVerification, error) {
+	sourceCount, sourceHash, err := m.hashWindow(ctx, m.source, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	destCount, destHash, err := m.hashWindow(ctx, m.destination, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+
+	return &WindowVerification{
+		Start:            start,
+		End:              end,
+		SourceCount:      sourceCount,
+		DestinationCount: destCount,
+		SourceHash:       sourceHash,
+		DestinationHash:  destHash,
+	}, nil
+}
+
+// hashWindow returns the number of entries of [start, end) in a repository
+// and a SHA-256 over their canonical form, ordered by ID
+func (m *Migrator) hashWindow(ctx context.Context, repo AuditRepository, start, end time.Time) (int64, string, error) {
+	var lines []string
+	err := m.scanWindow(ctx, repo, start, end, func(entries []AuditEntry) error {
+		for _, entry := range entries {
+			line, err := entryDigestLine(entry)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+
+	return int64(len(lines)), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entryDigestLine returns the hashed representation of an entry: its ID,
+// so that lines sort by ID, followed by the JSON of its canonical form
+func entryDigestLine(entry AuditEntry) (string, error) {
+	data, err := json.Marshal(canonicalEntry(entry))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode entry %s: %w", entry.ID.Hex(), err)
+	}
+	return entry.ID.Hex() + " " + string(data), nil
+}
+
+// canonicalEntry normalises an entry to the form every backend returns it
+// in: timestamps in UTC at millisecond precision, the precision of MongoDB,
+// empty changes and metadata as nil, and BSON documents and arrays of
+// metadata and change values as plain maps and slices
+func canonicalEntry(entry AuditEntry) AuditEntry {
+	entry.Timestamp = canonicalTime(entry.Timestamp)
+	if entry.LastOccurrence != nil {
+		last := canonicalTime(*entry.LastOccurrence)
+		entry.LastOccurrence = &last
+	}
+
+	if len(entry.Changes) == 0 {
+		entry.Changes = nil
+	} else {
+		changes := make([]FieldChange, len(entry.Changes))
+		for i, change := range entry.Changes {
+			change.OldValue = canonicalValue(change.OldValue)
+			change.NewValue = canonicalValue(change.NewValue)
+			changes[i] = change
+		}
+		entry.Changes = changes
+	}
+
+	if len(entry.Metadata) == 0 {
+		entry.Metadata = nil
+	} else {
+		entry.Metadata = canonicalValue(entry.Metadata).(map[string]any)
+	}
+
+	return entry
+}
+
+// canonicalTime truncates a timestamp to milliseconds in UTC
+func canonicalTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// canonicalValue converts BSON documents, arrays and dates into the plain
+// values JSON decoding produces
+func canonicalValue(v any) any {
+	switch v := v.(type) {
+	case primitive.D:
+		m := make(map[string]any, len(v))
+		for _, e := range v {
+			m[e.Key] = canonicalValue(e.Value)
+		}
+		return m
+	case primitive.M:
+		return canonicalValue(map[string]any(v))
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[key] = canonicalValue(value)
+		}
+		return m
+	case primitive.A:
+		return canonicalValue([]any(v))
+	case []any:
+		a := make([]any, len(v))
+		for i, value := range v {
+			a[i] = canonicalValue(value)
+		}
+		return a
+	case primitive.DateTime:
+		return canonicalTime(v.Time())
+	case time.Time:
+		return canonicalTime(v)
+	default:
+		return v
+	}
+}
+
+// loadCheckpoint reads the checkpoint file, if any
+func (m *Migrator) loadCheckpoint() (*migrationCheckpoint, error) {
+	if m.config.CheckpointFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(m.config.CheckpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration checkpoint: %w", err)
+	}
+
+	var cp migrationCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode migration checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint file
+func (m *Migrator) saveCheckpoint(cp migrationCheckpoint) error {
+	if m.config.CheckpointFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := m.config.CheckpointFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write migration checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, m.config.CheckpointFile); err != nil {
+		return fmt.Errorf("failed to write migration checkpoint: %w", err)
+	}
+	return syncDir(filepath.Dir(m.config.CheckpointFile))
+}
@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestFileRepository opens a file repository in a temporary directory
+func openTestFileRepository(t *testing.T) *FileRepository {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.FileDir = t.TempDir()
+	repo, err := OpenFileRepository(config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close(context.Background()) })
+	return repo
+}
+
+// insertEntries inserts entries into a repository
+func insertEntries(t *testing.T, repo AuditRepository, entries ...AuditEntry) {
+	t.Helper()
+	for _, entry := range entries {
+		if err := repo.Insert(context.Background(), entry); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+}
+
+func TestFindRangeKeyset(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestFileRepository(t)
+
+	// Five entries share a timestamp, so pages must break ties by ID
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	for i := 0; i < 7; i++ {
+		entry := testEntry("doc1")
+		entry.Timestamp = base.Add(time.Duration(max(i-4, 0)) * time.Hour)
+		entries = append(entries, entry)
+	}
+	insertEntries(t, repo, entries...)
+
+	var got []AuditEntry
+	var after *RangeCursor
+	for page := 0; ; page++ {
+		if page > len(entries) {
+			t.Fatal("pagination does not terminate")
+		}
+		batch, err := repo.FindRange(ctx, base, base.Add(2*time.Hour), after, 2)
+		if err != nil {
+			t.Fatalf("FindRange failed: %v", err)
+		}
+		got = append(got, batch...)
+		if len(batch) < 2 {
+			break
+		}
+		after = CursorOf(batch[len(batch)-1])
+	}
+
+	// The end of the range is exclusive
+	if len(got) != 6 {
+		t.Fatalf("read %d entries, want 6", len(got))
+	}
+	seen := make(map[string]bool)
+	for i, entry := range got {
+		if seen[entry.ID.Hex()] {
+			t.Errorf("entry %s read twice", entry.ID.Hex())
+		}
+		seen[entry.ID.Hex()] = true
+		if i > 0 && !recordLess(&fileRecord{id: got[i-1].ID, timestamp: got[i-1].Timestamp}, &fileRecord{id: entry.ID, timestamp: entry.Timestamp}) {
+			t.Errorf("entry %d is out of order", i)
+		}
+	}
+}
+
+func TestMigratorCopiesAndVerifies(t *testing.T) {
+	ctx := context.Background()
+	source := openTestFileRepository(t)
+	destination := openTestFileRepository(t)
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	for i := 0; i < 9; i++ {
+		entry := testEntry("doc1")
+		entry.Timestamp = base.Add(time.Duration(i/3) * time.Hour)
+		entry.Metadata = map[string]any{"n": i}
+		entries = append(entries, entry)
+	}
+	insertEntries(t, source, entries...)
+
+	// The destination already holds an entry that differs only in metadata
+	tampered := entries[4]
+	tampered.Metadata = map[string]any{"n": -1}
+	insertEntries(t, destination, tampered)
+
+	migrator, err := NewMigrator(source, destination, MigrationConfig{
+		From:      base,
+		To:        base.Add(3 * time.Hour),
+		Window:    time.Hour,
+		BatchSize: 2,
+		Verify:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+
+	report, err := migrator.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Windows != 3 || report.Copied != 8 || report.Skipped != 1 {
+		t.Errorf("report %+v, want 3 windows, 8 copied, 1 skipped", report)
+	}
+
+	// The hash covers every field, so the tampered window does not match
+	if len(report.Mismatches) != 1 || !report.Mismatches[0].Start.Equal(base.Add(time.Hour)) {
+		t.Fatalf("mismatches %+v, want the second window", report.Mismatches)
+	}
+	if m := report.Mismatches[0]; m.SourceCount != 3 || m.DestinationCount != 3 {
+		t.Errorf("mismatch counts %d and %d, want 3", m.SourceCount, m.DestinationCount)
+	}
+}
+
+func TestMigratorCheckpointRange(t *testing.T) {
+	ctx := context.Background()
+	source := openTestFileRepository(t)
+	destination := openTestFileRepository(t)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		entry := testEntry("doc1")
+		entry.Timestamp = base.Add(time.Duration(i) * time.Hour)
+		insertEntries(t, source, entry)
+	}
+
+	run := func(from, to time.Time) *MigrationReport {
+		t.Helper()
+		migrator, err := NewMigrator(source, destination, MigrationConfig{
+			From:           from,
+			To:             to,
+			Window:         time.Hour,
+			CheckpointFile: checkpoint,
+			Verify:         true,
+		})
+		if err != nil {
+			t.Fatalf("NewMigrator failed: %v", err)
+		}
+		report, err := migrator.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		return report
+	}
+
+	if report := run(base.Add(2*time.Hour), base.Add(4*time.Hour)); report.Windows != 2 || report.Copied != 2 {
+		t.Fatalf("first run %+v, want 2 windows copied", report)
+	}
+
+	// The same range resumes after its checkpoint
+	if report := run(base.Add(2*time.Hour), base.Add(4*time.Hour)); report.Windows != 0 {
+		t.Errorf("resumed run copied %d windows, want 0", report.Windows)
+	}
+
+	// Another range ignores the checkpoint of the first one
+	report := run(base, base.Add(4*time.Hour))
+	if report.Windows != 4 || report.Copied != 2 || report.Skipped != 2 {
+		t.Errorf("run over a new range %+v, want 4 windows, 2 copied, 2 skipped", report)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("mismatches %+v", report.Mismatches)
+	}
+}
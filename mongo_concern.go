@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Read concern levels accepted by Config.ReadConcern
+var readConcernLevels = map[string]bool{
+	"local":        true,
+	"available":    true,
+	"majority":     true,
+	"linearizable": true,
+	"snapshot":     true,
+}
+
+// writeConcern returns the write concern of audit writes, or nil to keep the
+// client's default
+func (c *Config) writeConcern() *writeconcern.WriteConcern {
+	if c.WriteConcern == "" && !c.WriteJournal && c.WriteTimeout == 0 {
+		return nil
+	}
+
+	wc := &writeconcern.WriteConcern{WTimeout: c.WriteTimeout}
+	if c.WriteConcern != "" {
+		if n, err := strconv.Atoi(c.WriteConcern); err == nil {
+			wc.W = n
+		} else {
+			wc.W = c.WriteConcern
+		}
+	}
+	if c.WriteJournal {
+		journal := true
+		wc.Journal = &journal
+	}
+	return wc
+}
+
+// readPreference returns the read preference of history queries, or nil to
+// keep the client's default
+func (c *Config) readPreference() (*readpref.ReadPref, error) {
+	if c.ReadPreference == "" {
+		if c.ReadMaxStaleness != 0 {
+			return nil, ErrInvalidConfig{Field: "ReadMaxStaleness", Message: "requires a ReadPreference other than primary"}
+		}
+		return nil, nil
+	}
+
+	mode, err := readpref.ModeFromString(c.ReadPreference)
+	if err != nil {
+		return nil, ErrInvalidConfig{Field: "ReadPreference", Message: fmt.Sprintf("unknown mode %q", c.ReadPreference)}
+	}
+
+	var opts []readpref.Option
+	if c.ReadMaxStaleness != 0 {
+		if mode == readpref.PrimaryMode {
+			return nil, ErrInvalidConfig{Field: "ReadMaxStaleness", Message: "requires a ReadPreference other than primary"}
+		}
+		opts = append(opts, readpref.WithMaxStaleness(c.ReadMaxStaleness))
+	}
+
+	rp, err := readpref.New(mode, opts...)
+	if err != nil {
+		return nil, ErrInvalidConfig{Field: "ReadPreference", Message: err.Error()}
+	}
+	return rp, nil
+}
+
+// readConcern returns the read concern of history queries, or nil to keep
+// the client's default
+func (c *Config) readConcern() *readconcern.ReadConcern {
+	if c.ReadConcern == "" {
+		return nil
+	}
+	return &readconcern.ReadConcern{Level: c.ReadConcern}
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoOutbox is an OutboxStore kept in a collection of the caller's own
+// database, for entries that must commit together with business data that
+// does not live in the audit cluster
+type MongoOutbox struct {
+	collection *mongo.Collection
+	retention  time.Duration
+}
+
+// mongoOutboxRecord is the document stored for an outbox record
+type mongoOutboxRecord struct {
+	ID          string     `bson:"_id"`
+	Entry       AuditEntry `bson:"entry"`
+	CreatedAt   time.Time  `bson:"created_at"`
+	Delivered   bool       `bson:"delivered"`
+	DeliveredAt *time.Time `bson:"delivered_at,omitempty"`
+}
+
+// NewMongoOutbox creates an outbox on the given collection. Delivered records
+// are kept for retention and then removed by a TTL index; a zero retention
+// deletes them as soon as they are delivered.
+func NewMongoOutbox(collection *mongo.Collection, retention time.Duration) *MongoOutbox {
+	return &MongoOutbox{
+		collection: collection,
+		retention:  retention,
+	}
+}
+
+// Write adds an entry to the outbox. Pass a mongo.SessionContext as ctx to
+// write it within the caller's transaction. The entry ID and timestamp are
+// set here so that relaying the entry more than once is idempotent.
+func (o *MongoOutbox) Write(ctx context.Context, entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+
+	record := mongoOutboxRecord{
+		ID:        entry.ID.Hex(),
+		Entry:     entry,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := o.collection.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to write audit entry to outbox: %w", err)
+	}
+
+	return nil
+}
+
+// Pending returns up to limit undelivered records, oldest first
+func (o *MongoOutbox) Pending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := o.collection.Find(ctx, bson.M{"delivered": false}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find outbox records: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []mongoOutboxRecord
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox records: %w", err)
+	}
+
+	records := make([]OutboxRecord, len(docs))
+	for i, doc := range docs {
+		records[i] = OutboxRecord{ID: doc.ID, Entry: doc.Entry}
+	}
+
+	return records, nil
+}
+
+// MarkDelivered marks records as delivered, or deletes them if the outbox
+// has no retention
+func (o *MongoOutbox) MarkDelivered(ctx context.Context, ids []string) error {
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+
+	var err error
+	if o.retention <= 0 {
+		_, err = o.collection.DeleteMany(ctx, filter)
+	} else {
+		_, err = o.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+			"delivered":    true,
+			"delivered_at": time.Now().UTC(),
+		}})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox records as delivered: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureIndexes creates the indexes used to find pending records and to
+// expire delivered ones
+func (o *MongoOutbox) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "delivered", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	}
+	if o.retention > 0 {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "delivered_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(o.retention.Seconds())),
+		})
+	}
+
+	_, err := o.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox indexes: %w", err)
+	}
+
+	return nil
+}
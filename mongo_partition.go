@@ -0,0 +1,414 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PartitionInterval defines the time span covered by one collection
+type PartitionInterval string
+
+const (
+	PartitionNone    PartitionInterval = ""        // a single collection for all time
+	PartitionMonthly PartitionInterval = "monthly" // one collection per month, e.g. audit_logs_2026_10
+	PartitionDaily   PartitionInterval = "daily"   // one collection per day, e.g. audit_logs_2026_10_18
+)
+
+// layout returns the time layout of partition name suffixes
+func (p PartitionInterval) layout() string {
+	if p == PartitionDaily {
+		return "2006_01_02"
+	}
+	return "2006_01"
+}
+
+// bounds returns the UTC start and exclusive end of the partition holding t
+func (p PartitionInterval) bounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if p == PartitionDaily {
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Partition represents one time-partitioned collection
+type Partition struct {
+	Name   string    `json:"name"`
+	Tenant string    `json:"tenant,omitempty"` // set in the collection-per-tenant mode
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"` // exclusive
+}
+
+// PartitionedRepository is implemented by repositories whose entries are
+// split into time-partitioned collections. Old partitions are removed whole,
+// which is far cheaper than deleting entries one by one.
+type PartitionedRepository interface {
+	// Partitions returns the existing partitions, newest first
+	Partitions(ctx context.Context) ([]Partition, error)
+
+	// DropPartitionsBefore drops the partitions that end before the given
+	// time and returns them
+	DropPartitionsBefore(ctx context.Context, before time.Time) ([]Partition, error)
+
+	// ArchivePartitionsBefore moves the partitions that end before the given
+	// time into another database of the same cluster and returns them
+	ArchivePartitionsBefore(ctx context.Context, before time.Time, database string) ([]Partition, error)
+}
+
+// partitioned reports whether entries are split into time partitions
+func (r *mongoRepository) partitioned() bool {
+	return r.config.PartitionInterval != PartitionNone
+}
+
+// tenantCollections reports whether every tenant has its own collection
+func (r *mongoRepository) tenantCollections() bool {
+	return r.config.TenantMode == TenantCollection
+}
+
+// baseName returns the name of the collection of a tenant's entries, before
+// partitioning
+func (r *mongoRepository) baseName(tenantID string) string {
+	if r.tenantCollections() {
+		return r.config.CollectionName + "_" + tenantID
+	}
+	return r.config.CollectionName
+}
+
+// collectionName returns the name of the collection holding the entries of
+// a tenant at time t
+func (r *mongoRepository) collectionName(tenantID string, t time.Time) string {
+	name := r.baseName(tenantID)
+	if r.partitioned() {
+		name += "_" + t.UTC().Format(r.config.PartitionInterval.layout())
+	}
+	return name
+}
+
+// writeCollection returns the collection an entry is written to, creating
+// a new partition or tenant collection and its indexes first
+func (r *mongoRepository) writeCollection(entry AuditEntry) (*mongo.Collection, error) {
+	if r.config.TenantMode != TenantNone && entry.TenantID == "" {
+		return nil, ErrTenantRequired{Operation: "insert an audit entry"}
+	}
+	if r.tenantCollections() {
+		if err := ValidateTenantID(entry.TenantID); err != nil {
+			return nil, err
+		}
+	}
+	if !r.partitioned() && !r.tenantCollections() {
+		return r.writeHandle(r.config.CollectionName), nil
+	}
+
+	name := r.collectionName(entry.TenantID, entry.Timestamp)
+	coll := r.writeHandle(name)
+	if r.partitioned() {
+		r.notePartition(name)
+	}
+
+	if r.config.EnableIndexes || r.timeSeries() {
+		if _, done := r.prepared.Load(name); !done {
+			// Collection and index creation must not join a caller's transaction
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := r.prepareCollection(ctx, coll, r.config.EnableIndexes); err != nil {
+				return nil, fmt.Errorf("failed to prepare collection %s: %w", name, err)
+			}
+			r.prepared.Store(name, true)
+		}
+	}
+
+	return coll, nil
+}
+
+// readCollections returns the collections that may hold entries of a tenant
+// between start and end, newest first. Nil bounds are open.
+func (r *mongoRepository) readCollections(ctx context.Context, tenantID string, start, end *time.Time) ([]*mongo.Collection, error) {
+	if r.tenantCollections() && tenantID == "" {
+		return nil, ErrTenantRequired{Operation: "read entries stored in per-tenant collections"}
+	}
+	if r.tenantCollections() {
+		if err := ValidateTenantID(tenantID); err != nil {
+			return nil, err
+		}
+	}
+	if !r.partitioned() {
+		return []*mongo.Collection{r.readHandle(r.baseName(tenantID))}, nil
+	}
+
+	partitions, legacy, err := r.cachedPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var colls []*mongo.Collection
+	for _, p := range partitions {
+		if p.Tenant != tenantID && r.tenantCollections() {
+			continue
+		}
+		if start != nil && !p.End.After(*start) {
+			continue
+		}
+		if end != nil && p.Start.After(*end) {
+			continue
+		}
+		colls = append(colls, r.readHandle(p.Name))
+	}
+
+	// Entries written before partitioning was enabled stay in the
+	// unpartitioned collection, read last as it holds the oldest entries
+	if legacy[r.baseName(tenantID)] {
+		colls = append(colls, r.readHandle(r.baseName(tenantID)))
+	}
+	return colls, nil
+}
+
+// partitionCacheTTL is how long reads reuse the listed partitions. Partitions
+// created by this repository are added at once; those created by other
+// processes are read after at most this long.
+const partitionCacheTTL = 30 * time.Second
+
+// partitionCache holds the partitions and unpartitioned collections listed
+// for reads
+type partitionCache struct {
+	mu         sync.Mutex
+	expires    time.Time
+	partitions []Partition     // newest first
+	names      map[string]bool // names of the partitions
+	legacy     map[string]bool // unpartitioned collections
+}
+
+// Partitions returns the existing partitions, newest first
+func (r *mongoRepository) Partitions(ctx context.Context) ([]Partition, error) {
+	partitions, _, err := r.listPartitions(ctx)
+	return partitions, err
+}
+
+// listPartitions lists the partitions, newest first, and the unpartitioned
+// collections holding entries written before partitioning was enabled, and
+// refreshes the cache of reads
+func (r *mongoRepository) listPartitions(ctx context.Context) ([]Partition, map[string]bool, error) {
+	if !r.partitioned() {
+		return nil, nil, fmt.Errorf("audit collection %s is not partitioned", r.config.CollectionName)
+	}
+
+	name := regexp.QuoteMeta(r.config.CollectionName)
+	filter := bson.M{"name": bson.M{"$regex": "^" + name + "(_|$)"}}
+	names, err := r.database.ListCollectionNames(ctx, filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	var partitions []Partition
+	legacy := make(map[string]bool)
+	for _, name := range names {
+		if p, ok := r.parsePartition(name); ok {
+			partitions = append(partitions, p)
+		} else if r.legacyCollection(name) {
+			legacy[name] = true
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Start.After(partitions[j].Start) })
+
+	c := &r.listed
+	c.mu.Lock()
+	c.partitions = partitions
+	c.names = make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		c.names[p.Name] = true
+	}
+	c.legacy = legacy
+	c.expires = time.Now().Add(partitionCacheTTL)
+	c.mu.Unlock()
+
+	return partitions, legacy, nil
+}
+
+// legacyCollection reports whether a collection is the unpartitioned
+// collection of the entries, or of a tenant's entries
+func (r *mongoRepository) legacyCollection(name string) bool {
+	if !r.tenantCollections() {
+		return name == r.config.CollectionName
+	}
+	tenant, ok := strings.CutPrefix(name, r.config.CollectionName+"_")
+	return ok && ValidateTenantID(tenant) == nil
+}
+
+// cachedPartitions returns the partitions and unpartitioned collections,
+// listing them again once the cache has expired
+func (r *mongoRepository) cachedPartitions(ctx context.Context) ([]Partition, map[string]bool, error) {
+	c := &r.listed
+	c.mu.Lock()
+	if time.Now().Before(c.expires) {
+		partitions, legacy := c.partitions, c.legacy
+		c.mu.Unlock()
+		return partitions, legacy, nil
+	}
+	c.mu.Unlock()
+
+	return r.listPartitions(ctx)
+}
+
+// notePartition adds a partition written to by this repository to the cache
+func (r *mongoRepository) notePartition(name string) {
+	c := &r.listed
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.names == nil || c.names[name] {
+		return
+	}
+	p, ok := r.parsePartition(name)
+	if !ok {
+		return
+	}
+
+	// The cached slice may be in use by readers, so it is replaced
+	partitions := make([]Partition, 0, len(c.partitions)+1)
+	partitions = append(partitions, c.partitions...)
+	partitions = append(partitions, p)
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Start.After(partitions[j].Start) })
+	c.partitions = partitions
+	c.names[name] = true
+}
+
+// forgetPartitions empties the cache after partitions were removed
+func (r *mongoRepository) forgetPartitions() {
+	c := &r.listed
+	c.mu.Lock()
+	c.expires = time.Time{}
+	c.mu.Unlock()
+}
+
+// parsePartition parses a partition name: the collection name, the tenant in
+// the collection-per-tenant mode, then the time suffix
+func (r *mongoRepository) parsePartition(name string) (Partition, bool) {
+	layout := r.config.PartitionInterval.layout()
+	rest := strings.TrimPrefix(name, r.config.CollectionName+"_")
+	if len(rest) < len(layout) {
+		return Partition{}, false
+	}
+
+	t, err := time.Parse(layout, rest[len(rest)-len(layout):])
+	if err != nil {
+		return Partition{}, false
+	}
+
+	p := Partition{Name: name}
+	if r.tenantCollections() {
+		tenant, ok := strings.CutSuffix(rest[:len(rest)-len(layout)], "_")
+		if !ok || ValidateTenantID(tenant) != nil {
+			return Partition{}, false
+		}
+		p.Tenant = tenant
+	} else if len(rest) != len(layout) {
+		return Partition{}, false
+	}
+
+	p.Start, p.End = r.config.PartitionInterval.bounds(t)
+	return p, true
+}
+
+// storedCollections returns the names of the existing collections in the
+// partitioned and collection-per-tenant modes
+func (r *mongoRepository) storedCollections(ctx context.Context) ([]string, error) {
+	var names []string
+	if r.partitioned() {
+		partitions, err := r.Partitions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range partitions {
+			names = append(names, p.Name)
+		}
+		return names, nil
+	}
+
+	prefix := r.config.CollectionName + "_"
+	filter := bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+	all, err := r.database.ListCollectionNames(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant collections: %w", err)
+	}
+	for _, name := range all {
+		if ValidateTenantID(strings.TrimPrefix(name, prefix)) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// expiredPartitions returns the partitions that end before the given time
+func (r *mongoRepository) expiredPartitions(ctx context.Context, before time.Time) ([]Partition, error) {
+	partitions, err := r.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Partition
+	for _, p := range partitions {
+		if !p.End.After(before) {
+			expired = append(expired, p)
+		}
+	}
+	return expired, nil
+}
+
+// DropPartitionsBefore drops the partitions that end before the given time
+func (r *mongoRepository) DropPartitionsBefore(ctx context.Context, before time.Time) ([]Partition, error) {
+	expired, err := r.expiredPartitions(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range expired {
+		if err := r.database.Collection(p.Name).Drop(ctx); err != nil {
+			r.forgetPartitions()
+			return expired[:i], fmt.Errorf("failed to drop partition %s: %w", p.Name, err)
+		}
+		r.prepared.Delete(p.Name)
+	}
+	r.forgetPartitions()
+	return expired, nil
+}
+
+// ArchivePartitionsBefore renames the partitions that end before the given
+// time into the archive database
+func (r *mongoRepository) ArchivePartitionsBefore(ctx context.Context, before time.Time, database string) ([]Partition, error) {
+	if r.timeSeries() {
+		return nil, fmt.Errorf("time-series partitions cannot be renamed: drop them instead")
+	}
+	if database == "" || database == r.config.DatabaseName {
+		return nil, fmt.Errorf("archive database must differ from the audit database")
+	}
+
+	expired, err := r.expiredPartitions(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := r.client.Database("admin")
+	for i, p := range expired {
+		cmd := bson.D{
+			{Key: "renameCollection", Value: r.config.DatabaseName + "." + p.Name},
+			{Key: "to", Value: database + "." + p.Name},
+		}
+		if err := admin.RunCommand(ctx, cmd).Err(); err != nil {
+			r.forgetPartitions()
+			return expired[:i], fmt.Errorf("failed to archive partition %s: %w", p.Name, err)
+		}
+		r.prepared.Delete(p.Name)
+	}
+	r.forgetPartitions()
+	return expired, nil
+}
@@ -3,6 +3,8 @@ package audit
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,8 +16,17 @@ import (
 // mongoRepository implements the AuditRepository interface using MongoDB
 type mongoRepository struct {
 	client     *mongo.Client
-	collection *mongo.Collection
+	database   *mongo.Database
 	config     *Config
+	ownsClient bool     // whether Close disconnects the client
+	prepared   sync.Map // names of partitions created and indexed for writes
+	listed     partitionCache
+
+	expireAfter atomic.Int64 // TimeSeriesExpireAfter, changeable with SetRetention
+
+	// Collection options of writes and of history queries
+	writeOptions *options.CollectionOptions
+	readOptions  *options.CollectionOptions
 }
 
 // NewMongoRepository creates a new MongoDB repository
@@ -40,22 +51,62 @@ func NewMongoRepository(config *Config) (AuditRepository, error) {
 	defer cancel()
 
 	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
 		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
-	collection := client.Database(config.DatabaseName).Collection(config.CollectionName)
+	repo, err := newMongoRepository(client, config)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	repo.ownsClient = true
+
+	return repo, nil
+}
+
+// NewMongoRepositoryWithClient creates a new MongoDB repository on an existing
+// client, e.g. the application's own client so that audit entries can be
+// inserted within its transactions. MongoURI and the pool settings of config
+// are ignored, and Close does not disconnect the client.
+func NewMongoRepositoryWithClient(client *mongo.Client, config *Config) (AuditRepository, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return newMongoRepository(client, config)
+}
+
+// newMongoRepository creates the repository and its indexes on a connected client
+func newMongoRepository(client *mongo.Client, config *Config) (*mongoRepository, error) {
+	readPref, err := config.readPreference()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	repo := &mongoRepository{
-		client:     client,
-		collection: collection,
-		config:     config,
+		client:   client,
+		database: client.Database(config.DatabaseName),
+		config:   config,
+		writeOptions: options.Collection().
+			SetWriteConcern(config.writeConcern()),
+		readOptions: options.Collection().
+			SetReadPreference(readPref).
+			SetReadConcern(config.readConcern()),
 	}
+	repo.expireAfter.Store(int64(config.TimeSeriesExpireAfter))
 
 	// Create indexes if enabled
 	if config.EnableIndexes {
 		if err := repo.EnsureIndexes(context.Background()); err != nil {
 			return nil, fmt.Errorf("failed to create indexes: %w", err)
 		}
+	} else if config.TimeSeries && !repo.partitioned() && !repo.tenantCollections() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := repo.prepareCollection(ctx, repo.writeHandle(config.CollectionName), false); err != nil {
+			return nil, err
+		}
 	}
 
 	return repo, nil
@@ -73,52 +124,134 @@ func (r *mongoRepository) Insert(ctx context.Context, entry AuditEntry) error {
 		entry.ID = primitive.NewObjectID()
 	}
 
-	var err error
-	for attempt := 0; attempt <= r.config.MaxRetries; attempt++ {
-		_, err = r.collection.InsertOne(ctx, entry)
-		if err == nil {
-			return nil
+	coll, err := r.writeCollection(entry)
+	if err != nil {
+		return err
+	}
+	doc, err := r.document(entry)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := retry(ctx, r.config.retryPolicy(), func(attempt int) error {
+		if r.timeSeries() {
+			exists, err := r.exists(ctx, coll, entry)
+			if err != nil {
+				return err
+			}
+			if exists && attempt > 1 {
+				return nil
+			}
+			if exists {
+				return ErrDuplicateEntry{ID: entry.ID.Hex()}
+			}
 		}
 
-		if attempt < r.config.MaxRetries {
-			time.Sleep(r.config.RetryDelay)
+		_, err := coll.InsertOne(ctx, doc)
+		// A duplicate ID on a retry means an earlier attempt was applied
+		// even though it reported an error
+		if attempt > 1 && mongo.IsDuplicateKeyError(err) {
+			return nil
 		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry after %d attempts: %w", attempts, err)
 	}
 
-	return fmt.Errorf("failed to insert audit entry after %d attempts: %w", r.config.MaxRetries+1, err)
+	return nil
 }
 
-// FindByQuery finds audit entries based on query parameters
-func (r *mongoRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
-	filter := r.buildFilter(query)
+// InsertInSession inserts a new audit entry within the caller's session. The
+// insert is not retried: inside a transaction the whole transaction must be
+// retried, which mongo.Session.WithTransaction does for transient errors.
+func (r *mongoRepository) InsertInSession(sc mongo.SessionContext, entry AuditEntry) error {
+	// MongoDB does not allow writes to time-series collections in transactions
+	if r.timeSeries() {
+		return ErrTransactionsUnsupported{}
+	}
+	if sc.Client() != r.client {
+		return fmt.Errorf("session belongs to a different client: create the repository with NewMongoRepositoryWithClient")
+	}
 
-	// Get total count
-	total, err := r.collection.CountDocuments(ctx, filter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to count documents: %w", err)
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
 	}
 
-	// Build options
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}) // Sort by timestamp descending
+	coll, err := r.writeCollection(entry)
+	if err != nil {
+		return err
+	}
 
-	if query.Limit > 0 {
-		opts.SetLimit(int64(query.Limit))
+	if _, err := coll.InsertOne(sc, entry); err != nil {
+		return fmt.Errorf("failed to insert audit entry in session: %w", err)
 	}
-	if query.Offset > 0 {
-		opts.SetSkip(int64(query.Offset))
+
+	return nil
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *mongoRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	filter, err := r.buildFilter(query)
+	if err != nil {
+		return nil, err
 	}
 
-	// Execute query
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	tenantID := query.TenantID
+	if tenantID == "" {
+		tenantID = tenantFromContext(ctx)
+	}
+	colls, err := r.readCollections(ctx, tenantID, query.StartTime, query.EndTime)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+		return nil, err
 	}
-	defer cursor.Close(ctx)
 
+	// Get total count
+	counts := make([]int64, len(colls))
+	var total int64
+	for i, coll := range colls {
+		count, err := coll.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count documents: %w", err)
+		}
+		counts[i] = count
+		total += count
+	}
+
+	// Partitions are ordered newest first, so the requested page is read
+	// from consecutive partitions after skipping the earlier ones
+	skip := int64(query.Offset)
 	var entries []AuditEntry
-	if err := cursor.All(ctx, &entries); err != nil {
-		return nil, fmt.Errorf("failed to decode results: %w", err)
+	for i, coll := range colls {
+		if query.Limit > 0 && len(entries) >= query.Limit {
+			break
+		}
+		if skip > 0 && skip >= counts[i] && len(colls) > 1 {
+			skip -= counts[i]
+			continue
+		}
+
+		// Build options
+		opts := options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: -1}}) // Sort by timestamp descending
+
+		if query.Limit > 0 {
+			opts.SetLimit(int64(query.Limit - len(entries)))
+		}
+		if skip > 0 {
+			opts.SetSkip(skip)
+		}
+		skip = 0
+
+		// Execute query
+		found, err := r.find(ctx, coll, filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute query: %w", err)
+		}
+		entries = append(entries, found...)
 	}
 
 	hasMore := false
@@ -133,6 +266,100 @@ func (r *mongoRepository) FindByQuery(ctx context.Context, query AuditQuery) (*A
 	}, nil
 }
 
+// find runs a query on one collection and decodes the results
+func (r *mongoRepository) find(ctx context.Context, coll *mongo.Collection, filter any, opts *options.FindOptions) ([]AuditEntry, error) {
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %w", err)
+	}
+
+	return entries, nil
+}
+
+// findNewest returns up to limit entries matching filter, newest first,
+// reading partitions until the limit is reached. Lookups through a
+// tenant-scoped handle only read the tenant's entries.
+func (r *mongoRepository) findNewest(ctx context.Context, filter bson.M, limit int) ([]AuditEntry, error) {
+	tenantID := tenantFromContext(ctx)
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	colls, err := r.readCollections(ctx, tenantID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+	for _, coll := range colls {
+		opts := options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: -1}})
+
+		if limit > 0 {
+			if len(entries) >= limit {
+				break
+			}
+			opts.SetLimit(int64(limit - len(entries)))
+		}
+
+		found, err := r.find(ctx, coll, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+
+	return entries, nil
+}
+
+// FindRange finds the entries of [start, end) after a cursor, oldest first.
+// Lookups through a tenant-scoped handle only read the tenant's entries.
+func (r *mongoRepository) FindRange(ctx context.Context, start, end time.Time, after *RangeCursor, limit int) ([]AuditEntry, error) {
+	filter := bson.M{"timestamp": bson.M{"$gte": start, "$lt": end}}
+	if after != nil {
+		filter["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{"$gt": after.Timestamp}},
+			bson.M{"timestamp": after.Timestamp, "_id": bson.M{"$gt": after.ID}},
+		}
+	}
+	tenantID := tenantFromContext(ctx)
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	colls, err := r.readCollections(ctx, tenantID, &start, &end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions are ordered newest first and do not overlap in time
+	var entries []AuditEntry
+	for i := len(colls) - 1; i >= 0; i-- {
+		opts := options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+		if limit > 0 {
+			if len(entries) >= limit {
+				break
+			}
+			opts.SetLimit(int64(limit - len(entries)))
+		}
+
+		found, err := r.find(ctx, colls[i], filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find entries: %w", err)
+		}
+		entries = append(entries, found...)
+	}
+
+	return entries, nil
+}
+
 // FindByID finds an audit entry by its ID
 func (r *mongoRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -141,17 +368,65 @@ func (r *mongoRepository) FindByID(ctx context.Context, id string) (*AuditEntry,
 	}
 
 	filter := bson.M{"_id": objectID}
+	tenantID := tenantFromContext(ctx)
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
 
-	var entry AuditEntry
-	err = r.collection.FindOne(ctx, filter).Decode(&entry)
+	colls, err := r.readCollections(ctx, tenantID, nil, nil)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil
+		return nil, err
+	}
+
+	// IDs are generated at insert time, so the entry is most likely in the
+	// partition of the ID's timestamp
+	if r.partitioned() {
+		name := r.collectionName(tenantID, objectID.Timestamp())
+		for i, coll := range colls {
+			if coll.Name() == name {
+				colls[0], colls[i] = colls[i], colls[0]
+				break
+			}
+		}
+	}
+
+	for _, coll := range colls {
+		entry, err := r.findOne(ctx, coll, objectID, filter)
+		if err != nil || entry != nil {
+			return entry, err
 		}
-		return nil, fmt.Errorf("failed to find audit entry: %w", err)
 	}
 
-	return &entry, nil
+	return nil, nil
+}
+
+// findOne finds an entry by ID in one collection. Time-series collections
+// are first searched around the time the ID was generated.
+func (r *mongoRepository) findOne(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, filter bson.M) (*AuditEntry, error) {
+	filters := []bson.M{filter}
+	if r.timeSeries() {
+		generated := id.Timestamp()
+		bounded := idFilter(id, generated.Add(-time.Hour), generated.Add(time.Hour))
+		for key, value := range filter {
+			if _, ok := bounded[key]; !ok {
+				bounded[key] = value
+			}
+		}
+		filters = []bson.M{bounded, filter}
+	}
+
+	for _, f := range filters {
+		var entry AuditEntry
+		err := coll.FindOne(ctx, f).Decode(&entry)
+		if err == nil {
+			return &entry, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to find audit entry: %w", err)
+		}
+	}
+
+	return nil, nil
 }
 
 // FindByResource finds audit entries for a specific resource
@@ -161,23 +436,10 @@ func (r *mongoRepository) FindByResource(ctx context.Context, resourceType, reso
 		"resource.id":   resourceID,
 	}
 
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}})
-
-	if limit > 0 {
-		opts.SetLimit(int64(limit))
-	}
-
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	entries, err := r.findNewest(ctx, r.storedFilter(filter), limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find resource history: %w", err)
 	}
-	defer cursor.Close(ctx)
-
-	var entries []AuditEntry
-	if err := cursor.All(ctx, &entries); err != nil {
-		return nil, fmt.Errorf("failed to decode results: %w", err)
-	}
 
 	return entries, nil
 }
@@ -192,29 +454,72 @@ func (r *mongoRepository) FindByActor(ctx context.Context, actorID string, actor
 		filter["actor.type"] = actorType
 	}
 
-	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	entries, err := r.findNewest(ctx, r.storedFilter(filter), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actor history: %w", err)
+	}
+
+	return entries, nil
+}
 
-	if limit > 0 {
-		opts.SetLimit(int64(limit))
+// EnsureIndexes creates necessary database indexes, on every partition if
+// the collection is partitioned and on every existing tenant collection in
+// the collection-per-tenant mode
+func (r *mongoRepository) EnsureIndexes(ctx context.Context) error {
+	if !r.partitioned() && !r.tenantCollections() {
+		return r.prepareCollection(ctx, r.writeHandle(r.config.CollectionName), true)
 	}
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	stored, err := r.storedCollections(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find actor history: %w", err)
+		return err
+	}
+	var names []string
+	if !r.tenantCollections() {
+		names = append(names, r.collectionName("", time.Now()))
+	}
+	for _, name := range stored {
+		if len(names) == 0 || name != names[0] {
+			names = append(names, name)
+		}
 	}
-	defer cursor.Close(ctx)
 
-	var entries []AuditEntry
-	if err := cursor.All(ctx, &entries); err != nil {
-		return nil, fmt.Errorf("failed to decode results: %w", err)
+	for _, name := range names {
+		if err := r.prepareCollection(ctx, r.writeHandle(name), true); err != nil {
+			return fmt.Errorf("collection %s: %w", name, err)
+		}
+		r.prepared.Store(name, true)
 	}
 
-	return entries, nil
+	return nil
 }
 
-// EnsureIndexes creates necessary database indexes
-func (r *mongoRepository) EnsureIndexes(ctx context.Context) error {
+// writeHandle returns a collection applying the write settings
+func (r *mongoRepository) writeHandle(name string) *mongo.Collection {
+	return r.database.Collection(name, r.writeOptions)
+}
+
+// readHandle returns a collection applying the read settings
+func (r *mongoRepository) readHandle(name string) *mongo.Collection {
+	return r.database.Collection(name, r.readOptions)
+}
+
+// prepareCollection creates a time-series collection if enabled, then its
+// indexes if requested. Regular collections are created by their first write.
+func (r *mongoRepository) prepareCollection(ctx context.Context, coll *mongo.Collection, indexes bool) error {
+	if r.timeSeries() {
+		if err := r.createTimeSeries(ctx, coll.Name()); err != nil {
+			return err
+		}
+	}
+	if !indexes {
+		return nil
+	}
+	return r.createIndexes(ctx, coll)
+}
+
+// createIndexes creates the indexes of one collection
+func (r *mongoRepository) createIndexes(ctx context.Context, coll *mongo.Collection) error {
 	indexes := []mongo.IndexModel{
 		{
 			Keys: bson.D{{Key: "actor.id", Value: 1}, {Key: "timestamp", Value: -1}},
@@ -237,10 +542,30 @@ func (r *mongoRepository) EnsureIndexes(ctx context.Context) error {
 		{
 			Keys: bson.D{{Key: "actor.id", Value: 1}, {Key: "actor.type", Value: 1}, {Key: "timestamp", Value: -1}},
 		},
+		{
+			Keys: bson.D{{Key: "correlation_id", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "trace_id", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "parent_id", Value: 1}},
+		},
+	}
+
+	for i, index := range indexes {
+		keys := index.Keys.(bson.D)
+		for j := range keys {
+			keys[j].Key = r.field(keys[j].Key)
+		}
+		// Every read of a shared multi-tenant collection filters by tenant
+		if r.config.TenantMode == TenantShared {
+			indexes[i].Keys = append(bson.D{{Key: "tenant_id", Value: 1}}, keys...)
+		}
 	}
 
 	opts := options.CreateIndexes().SetMaxTime(30 * time.Second)
-	_, err := r.collection.Indexes().CreateMany(ctx, indexes, opts)
+	_, err := coll.Indexes().CreateMany(ctx, indexes, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
@@ -250,13 +575,19 @@ func (r *mongoRepository) EnsureIndexes(ctx context.Context) error {
 
 // Close closes the repository connection
 func (r *mongoRepository) Close(ctx context.Context) error {
+	if !r.ownsClient {
+		return nil
+	}
 	return r.client.Disconnect(ctx)
 }
 
 // buildFilter builds a MongoDB filter from AuditQuery
-func (r *mongoRepository) buildFilter(query AuditQuery) bson.M {
+func (r *mongoRepository) buildFilter(query AuditQuery) (bson.M, error) {
 	filter := bson.M{}
 
+	if query.TenantID != "" {
+		filter["tenant_id"] = query.TenantID
+	}
 	if query.ActorID != "" {
 		filter["actor.id"] = query.ActorID
 	}
@@ -278,6 +609,19 @@ func (r *mongoRepository) buildFilter(query AuditQuery) bson.M {
 	if query.Success != nil {
 		filter["success"] = *query.Success
 	}
+	if query.CorrelationID != "" {
+		filter["correlation_id"] = query.CorrelationID
+	}
+	if query.TraceID != "" {
+		filter["trace_id"] = query.TraceID
+	}
+	if query.ParentID != "" {
+		parentID, err := primitive.ObjectIDFromHex(query.ParentID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent ID: %s", query.ParentID)
+		}
+		filter["parent_id"] = parentID
+	}
 
 	// Time range filter
 	if query.StartTime != nil || query.EndTime != nil {
@@ -291,5 +635,5 @@ func (r *mongoRepository) buildFilter(query AuditQuery) bson.M {
 		filter["timestamp"] = timeFilter
 	}
 
-	return filter
+	return r.storedFilter(filter), nil
 }
@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Time-series granularities, matching the bucketing of MongoDB
+const (
+	GranularitySeconds = "seconds"
+	GranularityMinutes = "minutes"
+	GranularityHours   = "hours"
+)
+
+// timeSeriesMetaField is the metaField of time-series collections. It holds
+// the IDs and types of the actor and the resource, which MongoDB uses to group
+// entries into buckets.
+const timeSeriesMetaField = "meta"
+
+// namespaceExistsCode is the MongoDB error code of creating an existing collection
+const namespaceExistsCode = 48
+
+// timeSeriesMeta is the metaField of an entry stored in a time-series
+// collection. Names, sessions and other fields vary between the entries of
+// the same actor and resource, so they stay out of it to keep buckets full.
+type timeSeriesMeta struct {
+	Actor    timeSeriesKey `bson:"actor"`
+	Resource timeSeriesKey `bson:"resource"`
+}
+
+// timeSeriesKey identifies an actor or a resource in the metaField
+type timeSeriesKey struct {
+	ID   string `bson:"id"`
+	Type string `bson:"type"`
+}
+
+// timeSeries reports whether entries are stored in time-series collections
+func (r *mongoRepository) timeSeries() bool {
+	return r.config.TimeSeries
+}
+
+// timeSeriesMetaFields lists the entry fields copied into the metaField
+var timeSeriesMetaFields = []string{"actor.id", "actor.type", "resource.type", "resource.id"}
+
+// field returns the stored name of an entry field; the IDs and types of the
+// actor and resource of time-series entries are filtered on in the metaField
+func (r *mongoRepository) field(name string) string {
+	if r.timeSeries() && slices.Contains(timeSeriesMetaFields, name) {
+		return timeSeriesMetaField + "." + name
+	}
+	return name
+}
+
+// storedFilter renames the fields of a filter to their stored names
+func (r *mongoRepository) storedFilter(filter bson.M) bson.M {
+	if !r.timeSeries() {
+		return filter
+	}
+
+	stored := make(bson.M, len(filter))
+	for name, value := range filter {
+		stored[r.field(name)] = value
+	}
+	return stored
+}
+
+// document returns the document an entry is stored as. Time-series entries
+// have the IDs and types of their actor and resource copied into the
+// metaField.
+func (r *mongoRepository) document(entry AuditEntry) (any, error) {
+	if !r.timeSeries() {
+		return entry, nil
+	}
+
+	raw, err := bson.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	doc = append(doc, bson.E{Key: timeSeriesMetaField, Value: timeSeriesMeta{
+		Actor:    timeSeriesKey{ID: entry.Actor.ID, Type: string(entry.Actor.Type)},
+		Resource: timeSeriesKey{ID: entry.Resource.ID, Type: entry.Resource.Type},
+	}})
+
+	return doc, nil
+}
+
+// createTimeSeries creates a time-series collection, or updates the expiry
+// of an existing one
+func (r *mongoRepository) createTimeSeries(ctx context.Context, name string) error {
+	tsOpts := options.TimeSeries().
+		SetTimeField("timestamp").
+		SetMetaField(timeSeriesMetaField)
+	if r.config.TimeSeriesGranularity != "" {
+		tsOpts.SetGranularity(r.config.TimeSeriesGranularity)
+	}
+
+	expireAfter := time.Duration(r.expireAfter.Load())
+	opts := options.CreateCollection().SetTimeSeriesOptions(tsOpts)
+	if expireAfter > 0 {
+		opts.SetExpireAfterSeconds(int64(expireAfter.Seconds()))
+	}
+
+	err := r.database.CreateCollection(ctx, name, opts)
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != namespaceExistsCode {
+		return fmt.Errorf("failed to create time-series collection %s: %w", name, err)
+	}
+
+	// A regular collection cannot be converted in place
+	specs, err := r.database.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil {
+		return fmt.Errorf("failed to inspect collection %s: %w", name, err)
+	}
+	if len(specs) == 1 && specs[0].Type != "timeseries" {
+		return ErrInvalidConfig{
+			Field: "TimeSeries",
+			Message: fmt.Sprintf("collection %s already exists and is not a time-series collection; "+
+				"copy its entries into a new time-series collection with a Migrator, or disable TimeSeries", name),
+		}
+	}
+
+	// Expiry is the only time-series option that can change after creation
+	return r.updateExpiry(ctx, name, expireAfter)
+}
+
+// updateExpiry changes the expiry of an existing time-series collection
+func (r *mongoRepository) updateExpiry(ctx context.Context, name string, expireAfter time.Duration) error {
+	expire := any("off")
+	if expireAfter > 0 {
+		expire = int64(expireAfter.Seconds())
+	}
+	cmd := bson.D{{Key: "collMod", Value: name}, {Key: "expireAfterSeconds", Value: expire}}
+	if err := r.database.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to update expiry of time-series collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// SetRetention replaces TimeSeriesExpireAfter on every time-series
+// collection. Only time-series collections support it.
+func (r *mongoRepository) SetRetention(ctx context.Context, retention time.Duration) error {
+	if !r.timeSeries() {
+		return fmt.Errorf("retention requires a time-series collection")
+	}
+	if retention < 0 || (retention > 0 && retention < time.Second) {
+		return fmt.Errorf("retention must be zero or at least one second")
+	}
+
+	r.expireAfter.Store(int64(retention))
+
+	names := []string{r.config.CollectionName}
+	if r.partitioned() || r.tenantCollections() {
+		stored, err := r.storedCollections(ctx)
+		if err != nil {
+			return err
+		}
+		names = stored
+	}
+	for _, name := range names {
+		if err := r.updateExpiry(ctx, name, retention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idFilter returns a filter matching an entry by ID within a time range.
+// Time-series collections have no index on _id, but bucket their entries by
+// time, so bounding the time limits the buckets scanned.
+func idFilter(id primitive.ObjectID, from, to time.Time) bson.M {
+	return bson.M{
+		"_id":       id,
+		"timestamp": bson.M{"$gte": from, "$lt": to},
+	}
+}
+
+// exists reports whether a time-series collection already holds an entry.
+// Time-series collections do not enforce unique IDs, so duplicates are
+// detected by reading before writing.
+func (r *mongoRepository) exists(ctx context.Context, coll *mongo.Collection, entry AuditEntry) (bool, error) {
+	// MongoDB stores timestamps at millisecond precision
+	from := entry.Timestamp.Truncate(time.Millisecond)
+	count, err := coll.CountDocuments(ctx, idFilter(entry.ID, from, from.Add(time.Millisecond)))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
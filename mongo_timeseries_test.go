@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTimeSeriesDocument(t *testing.T) {
+	repo := &mongoRepository{config: &Config{TimeSeries: true}}
+	entry := testEntry("doc1")
+	entry.Actor.Name = "Alice"
+	entry.Actor.SessionID = "session1"
+
+	doc, err := repo.document(entry)
+	if err != nil {
+		t.Fatalf("document failed: %v", err)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Only the IDs and types go into the metaField
+	var stored struct {
+		Meta bson.M `bson:"meta"`
+	}
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := bson.M{
+		"actor":    bson.M{"id": "user123", "type": string(entry.Actor.Type)},
+		"resource": bson.M{"id": "doc1", "type": "document"},
+	}
+	if !reflect.DeepEqual(stored.Meta, want) {
+		t.Errorf("meta = %v, want %v", stored.Meta, want)
+	}
+
+	// The rest of the entry keeps its usual shape
+	var decoded AuditEntry
+	if err := bson.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.Actor != entry.Actor || decoded.Resource != entry.Resource {
+		t.Errorf("read back %+v and %+v", decoded.Actor, decoded.Resource)
+	}
+
+	for name, want := range map[string]string{
+		"actor.id":         "meta.actor.id",
+		"resource.type":    "meta.resource.type",
+		"actor.session_id": "actor.session_id",
+		"action":           "action",
+	} {
+		if got := repo.field(name); got != want {
+			t.Errorf("field(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OutboxRecord represents an audit entry waiting in a transactional outbox
+type OutboxRecord struct {
+	ID    string
+	Entry AuditEntry
+}
+
+// OutboxStore is the storage of a transactional outbox. Entries are written
+// to the outbox in the caller's own transaction and moved to the audit
+// service later by an OutboxRelay.
+type OutboxStore interface {
+	// Pending returns up to limit undelivered records, oldest first
+	Pending(ctx context.Context, limit int) ([]OutboxRecord, error)
+
+	// MarkDelivered marks records as delivered so they are not relayed again
+	MarkDelivered(ctx context.Context, ids []string) error
+}
+
+// OutboxRelayConfig represents the configuration of an outbox relay
+type OutboxRelayConfig struct {
+	BatchSize    int           // records fetched per batch
+	PollInterval time.Duration // wait between batches once the outbox is empty or failing
+
+	// OnError is called for every record that could not be delivered,
+	// including invalid entries that are skipped. Optional.
+	OnError func(record OutboxRecord, err error)
+}
+
+// DefaultOutboxRelayConfig returns a default outbox relay configuration
+func DefaultOutboxRelayConfig() OutboxRelayConfig {
+	return OutboxRelayConfig{
+		BatchSize:    100,
+		PollInterval: time.Second,
+	}
+}
+
+// OutboxRelay moves entries from an outbox into an audit service. Delivery is
+// at least once: entry IDs are fixed when written to the outbox, so entries
+// relayed twice are deduplicated by the repository. Relayed entries are
+// written synchronously by services of this package whatever their failure
+// policy, sampler or coalescer, so that a record is only marked delivered
+// once its entry is stored.
+type OutboxRelay struct {
+	store   OutboxStore
+	service AuditService
+	config  OutboxRelayConfig
+}
+
+// NewOutboxRelay creates a new outbox relay. Zero values in the configuration
+// are replaced by their defaults.
+func NewOutboxRelay(store OutboxStore, service AuditService, config OutboxRelayConfig) *OutboxRelay {
+	defaults := DefaultOutboxRelayConfig()
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+
+	return &OutboxRelay{
+		store:   store,
+		service: service,
+		config:  config,
+	}
+}
+
+// RelayOnce relays one batch of pending records and returns the number of
+// records delivered. Records already in the repository count as delivered;
+// invalid entries are skipped and reported to OnError. The batch stops at the
+// first other error, leaving the failed record and those after it pending.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	records, err := r.store.Pending(ctx, r.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	ctx = withSyncWrite(ctx)
+
+	var delivered []string
+	var relayErr error
+	for _, record := range records {
+		err := r.service.LogAction(ctx, record.Entry)
+		if err != nil && !IsDuplicateEntry(err) {
+			r.report(record, err)
+
+			// An invalid entry will never be accepted, so it is skipped
+			var invalid ErrInvalidEntry
+			if !errors.As(err, &invalid) {
+				relayErr = err
+				break
+			}
+		}
+		delivered = append(delivered, record.ID)
+	}
+
+	if len(delivered) > 0 {
+		if err := r.store.MarkDelivered(ctx, delivered); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox records as delivered: %w", err)
+		}
+	}
+	if relayErr != nil {
+		return len(delivered), fmt.Errorf("failed to relay outbox record: %w", relayErr)
+	}
+
+	return len(delivered), nil
+}
+
+// Run relays records until ctx is done. Full batches are followed by the
+// next one at once; otherwise the relay waits PollInterval between batches.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err == nil && n == r.config.BatchSize {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+// syncWriteKey marks a context whose entries must be written synchronously
+type syncWriteKey struct{}
+
+// withSyncWrite returns a copy of ctx whose entries bypass the failure
+// policy, the sampler and the coalescer of the service
+func withSyncWrite(ctx context.Context) context.Context {
+	return context.WithValue(ctx, syncWriteKey{}, true)
+}
+
+// syncWriteFromContext reports whether entries logged with ctx must be
+// written synchronously
+func syncWriteFromContext(ctx context.Context) bool {
+	sync, _ := ctx.Value(syncWriteKey{}).(bool)
+	return sync
+}
+
+// report passes an undelivered record to the error callback
+func (r *OutboxRelay) report(record OutboxRecord, err error) {
+	if r.config.OnError != nil {
+		r.config.OnError(record, err)
+	}
+}
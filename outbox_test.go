@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository stores entries in memory and rejects duplicate IDs
+type memoryRepository struct {
+	AuditRepository
+
+	mu      sync.Mutex
+	entries []AuditEntry
+	err     error // returned by Insert when set
+}
+
+func (r *memoryRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	for _, stored := range r.entries {
+		if !entry.ID.IsZero() && stored.ID == entry.ID {
+			return ErrDuplicateEntry{ID: entry.ID.Hex()}
+		}
+	}
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *memoryRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// failWith makes later inserts fail with err, or succeed again with nil
+func (r *memoryRepository) failWith(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// stored returns a copy of the stored entries
+func (r *memoryRepository) stored() []AuditEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.entries)
+}
+
+// memoryOutbox is an OutboxStore kept in memory
+type memoryOutbox struct {
+	records   []OutboxRecord
+	delivered map[string]bool
+}
+
+func (o *memoryOutbox) Pending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	var pending []OutboxRecord
+	for _, record := range o.records {
+		if !o.delivered[record.ID] && len(pending) < limit {
+			pending = append(pending, record)
+		}
+	}
+	return pending, nil
+}
+
+func (o *memoryOutbox) MarkDelivered(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		o.delivered[id] = true
+	}
+	return nil
+}
+
+// newMemoryOutbox returns an outbox holding the given entries
+func newMemoryOutbox(entries ...AuditEntry) *memoryOutbox {
+	o := &memoryOutbox{delivered: make(map[string]bool)}
+	for _, entry := range entries {
+		o.records = append(o.records, OutboxRecord{ID: entry.ID.Hex(), Entry: entry})
+	}
+	return o
+}
+
+// testEntry returns a valid entry with a fixed ID
+func testEntry(resourceID string) AuditEntry {
+	entry := NewAuditBuilder().
+		View().
+		User("user123", "").
+		Resource("document", resourceID, "").
+		Success(true).
+		Build()
+	entry.ID = primitive.NewObjectID()
+	entry.Timestamp = time.Now().UTC()
+	return entry
+}
+
+func TestOutboxRelayWritesSynchronously(t *testing.T) {
+	ctx := context.Background()
+	sampler, err := NewSampler(SamplingRule{Action: ActionView, Rate: 0.0001})
+	if err != nil {
+		t.Fatalf("NewSampler failed: %v", err)
+	}
+
+	repo := &memoryRepository{}
+	policy := DefaultAuditPolicy()
+	policy.Default = FailOpen
+	service := NewServiceWithRepository(repo,
+		WithPolicy(policy),
+		WithSampler(sampler),
+		WithCoalescer(NewCoalescer(CoalesceConfig{Window: time.Hour})),
+	)
+	defer service.Close(ctx)
+
+	outbox := newMemoryOutbox(testEntry("doc1"), testEntry("doc1"), testEntry("doc2"))
+	relay := NewOutboxRelay(outbox, service, OutboxRelayConfig{})
+
+	// A failed write keeps the record pending although the policy fails open
+	repo.failWith(errors.New("storage unavailable"))
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("RelayOnce = %d, %v; want an error", n, err)
+	}
+	if len(outbox.delivered) != 0 {
+		t.Fatalf("records marked delivered after a failed write: %v", outbox.delivered)
+	}
+
+	// Relayed entries are neither sampled, coalesced nor queued
+	repo.failWith(nil)
+	n, err := relay.RelayOnce(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("RelayOnce = %d, %v; want 3", n, err)
+	}
+	if stored := repo.stored(); len(stored) != 3 {
+		t.Fatalf("stored %d entries when the relay returned, want 3", len(stored))
+	}
+	if stats := policy.Stats(); stats.Written != 0 {
+		t.Errorf("relayed entries were written asynchronously: %+v", stats)
+	}
+
+	// Records already stored count as delivered
+	outbox.delivered = make(map[string]bool)
+	if n, err := relay.RelayOnce(ctx); err != nil || n != 3 {
+		t.Fatalf("second RelayOnce = %d, %v; want 3", n, err)
+	}
+	if stored := repo.stored(); len(stored) != 3 {
+		t.Errorf("stored %d entries after relaying twice, want 3", len(stored))
+	}
+}
+
+func TestOutboxRelaySkipsInvalidEntries(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryRepository{}
+	service := NewServiceWithRepository(repo)
+
+	invalid := testEntry("doc1")
+	invalid.Action = "publish"
+	valid := testEntry("doc2")
+
+	var reported []string
+	outbox := newMemoryOutbox(invalid, valid)
+	relay := NewOutboxRelay(outbox, service, OutboxRelayConfig{
+		OnError: func(record OutboxRecord, err error) {
+			reported = append(reported, record.ID)
+		},
+	})
+
+	n, err := relay.RelayOnce(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("RelayOnce = %d, %v; want 2", n, err)
+	}
+	if len(reported) != 1 || reported[0] != invalid.ID.Hex() {
+		t.Errorf("reported %v, want the invalid record", reported)
+	}
+	if stored := repo.stored(); len(stored) != 1 || stored[0].ID != valid.ID {
+		t.Errorf("stored %+v, want the valid entry only", stored)
+	}
+}
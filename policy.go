@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureMode defines how the outcome of an audit write affects the caller
+type FailureMode string
+
+const (
+	FailClosed FailureMode = "fail_closed" // written synchronously; write errors are returned to the caller
+	FailOpen   FailureMode = "fail_open"   // written asynchronously; waits for queue space, errors are counted
+	BestEffort FailureMode = "best_effort" // written asynchronously; dropped when the queue is full
+)
+
+// PolicyRule assigns a failure mode to entries matching an action and/or a
+// resource type. Empty fields match anything.
+type PolicyRule struct {
+	Action       AuditAction `json:"action,omitempty" yaml:"action,omitempty"`
+	ResourceType string      `json:"resource_type,omitempty" yaml:"resource_type,omitempty"`
+	Mode         FailureMode `json:"mode" yaml:"mode"`
+}
+
+// AuditPolicy maps entries to failure modes so that callers do not decide ad
+// hoc whether an audit failure must fail the business operation. The most
+// specific matching rule wins: action and resource type, then resource type,
+// then action, then Default. A policy keeps counters and must be used by a
+// single service.
+type AuditPolicy struct {
+	Default FailureMode
+	Rules   []PolicyRule
+
+	// Asynchronous writes
+	QueueSize    int           // capacity of the queue shared by fail-open and best-effort entries
+	Workers      int           // goroutines draining the queue
+	WriteTimeout time.Duration // timeout of a single asynchronous write
+
+	// OnError is called for every asynchronous write that fails and every
+	// entry that is dropped. Optional.
+	OnError func(entry AuditEntry, err error)
+
+	written atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+}
+
+// PolicyStats represents the counters of asynchronous audit writes
+type PolicyStats struct {
+	Written int64 `json:"written"` // asynchronous writes that succeeded
+	Failed  int64 `json:"failed"`  // asynchronous writes that returned an error
+	Dropped int64 `json:"dropped"` // entries discarded because the queue was full or closed
+}
+
+// DefaultAuditPolicy returns a policy where every entry fails closed
+func DefaultAuditPolicy() *AuditPolicy {
+	return &AuditPolicy{
+		Default:      FailClosed,
+		QueueSize:    1000,
+		Workers:      4,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// ModeFor returns the failure mode that applies to an entry
+func (p *AuditPolicy) ModeFor(entry AuditEntry) FailureMode {
+	best, bestScore := p.Default, -1
+	for _, rule := range p.Rules {
+		if rule.Action != "" && rule.Action != entry.Action {
+			continue
+		}
+		if rule.ResourceType != "" && rule.ResourceType != entry.Resource.Type {
+			continue
+		}
+
+		score := 0
+		if rule.ResourceType != "" {
+			score += 2
+		}
+		if rule.Action != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule.Mode, score
+		}
+	}
+
+	if best == "" {
+		return FailClosed
+	}
+	return best
+}
+
+// Stats returns the counters of asynchronous writes
+func (p *AuditPolicy) Stats() PolicyStats {
+	return PolicyStats{
+		Written: p.written.Load(),
+		Failed:  p.failed.Load(),
+		Dropped: p.dropped.Load(),
+	}
+}
+
+// ErrEntryDropped represents an entry discarded without being written
+type ErrEntryDropped struct {
+	Reason string
+}
+
+func (e ErrEntryDropped) Error() string {
+	return "audit entry dropped: " + e.Reason
+}
+
+// WithPolicy applies a failure policy to the service. Fail-open and
+// best-effort entries are written by background workers, which Close drains
+// before closing the repository.
+func WithPolicy(policy *AuditPolicy) ServiceOption {
+	return func(s *auditService) {
+		defaults := DefaultAuditPolicy()
+		if policy.QueueSize <= 0 {
+			policy.QueueSize = defaults.QueueSize
+		}
+		if policy.Workers <= 0 {
+			policy.Workers = defaults.Workers
+		}
+		if policy.WriteTimeout <= 0 {
+			policy.WriteTimeout = defaults.WriteTimeout
+		}
+
+		s.policy = policy
+		s.async = &asyncWriter{
+			queue: make(chan asyncEntry, policy.QueueSize),
+		}
+		for i := 0; i < policy.Workers; i++ {
+			s.async.wg.Add(1)
+			go s.asyncWorker()
+		}
+	}
+}
+
+// asyncWriter holds the queue of entries written in the background
+type asyncWriter struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan asyncEntry
+	wg     sync.WaitGroup
+}
+
+// asyncEntry is a queued entry together with the caller's context
+type asyncEntry struct {
+	ctx   context.Context
+	entry AuditEntry
+}
+
+// enqueue queues an entry for a background write. Fail-open entries wait for
+// queue space until ctx is done; best-effort entries are dropped at once
+// when the queue is full.
+func (s *auditService) enqueue(ctx context.Context, entry AuditEntry, wait bool) {
+	s.async.mu.RLock()
+	defer s.async.mu.RUnlock()
+
+	if s.async.closed {
+		s.dropEntry(entry, "service closed")
+		return
+	}
+
+	// Keep context values such as trace IDs but not the caller's deadline
+	item := asyncEntry{ctx: context.WithoutCancel(ctx), entry: entry}
+
+	if !wait {
+		select {
+		case s.async.queue <- item:
+		default:
+			s.dropEntry(entry, "queue full")
+		}
+		return
+	}
+
+	select {
+	case s.async.queue <- item:
+	case <-ctx.Done():
+		s.dropEntry(entry, "queue full until context done")
+	}
+}
+
+// asyncWorker writes queued entries until the queue is closed
+func (s *auditService) asyncWorker() {
+	defer s.async.wg.Done()
+
+	for item := range s.async.queue {
+		ctx, cancel := context.WithTimeout(item.ctx, s.policy.WriteTimeout)
+		err := s.write(ctx, item.entry)
+		cancel()
+
+		if err != nil {
+			s.policy.failed.Add(1)
+			if s.policy.OnError != nil {
+				s.policy.OnError(item.entry, err)
+			}
+			continue
+		}
+		s.policy.written.Add(1)
+	}
+}
+
+// dropEntry counts and reports a discarded entry
+func (s *auditService) dropEntry(entry AuditEntry, reason string) {
+	s.policy.dropped.Add(1)
+	if s.policy.OnError != nil {
+		s.policy.OnError(entry, ErrEntryDropped{Reason: reason})
+	}
+}
+
+// drain stops accepting asynchronous entries and waits for queued ones
+func (a *asyncWriter) drain() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	a.wg.Wait()
+}
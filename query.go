@@ -0,0 +1,57 @@
+package audit
+
+// matchesQuery reports whether an entry satisfies the filters of a query,
+// with the same semantics as the MongoDB repository's filter. Limit and
+// Offset are ignored.
+func matchesQuery(entry AuditEntry, query AuditQuery) bool {
+	if query.TenantID != "" && entry.TenantID != query.TenantID {
+		return false
+	}
+	if query.ActorID != "" && entry.Actor.ID != query.ActorID {
+		return false
+	}
+	if query.ActorType != "" && entry.Actor.Type != query.ActorType {
+		return false
+	}
+	if query.SessionID != "" && entry.Actor.SessionID != query.SessionID {
+		return false
+	}
+	if len(query.Actions) > 0 && !containsAction(query.Actions, entry.Action) {
+		return false
+	}
+	if query.ResourceType != "" && entry.Resource.Type != query.ResourceType {
+		return false
+	}
+	if query.ResourceID != "" && entry.Resource.ID != query.ResourceID {
+		return false
+	}
+	if query.Success != nil && entry.Success != *query.Success {
+		return false
+	}
+	if query.CorrelationID != "" && entry.CorrelationID != query.CorrelationID {
+		return false
+	}
+	if query.TraceID != "" && entry.TraceID != query.TraceID {
+		return false
+	}
+	if query.ParentID != "" && (entry.ParentID == nil || entry.ParentID.Hex() != query.ParentID) {
+		return false
+	}
+	if query.StartTime != nil && entry.Timestamp.Before(*query.StartTime) {
+		return false
+	}
+	if query.EndTime != nil && entry.Timestamp.After(*query.EndTime) {
+		return false
+	}
+	return true
+}
+
+// containsAction reports whether action is one of actions
+func containsAction(actions []AuditAction, action AuditAction) bool {
+	for _, candidate := range actions {
+		if candidate == action {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResourceState represents the field state of a resource reconstructed from
+// its change history at a point in time
+type ResourceState struct {
+	ResourceType string         `json:"resource_type"`
+	ResourceID   string         `json:"resource_id"`
+	At           time.Time      `json:"at"`
+	Fields       map[string]any `json:"fields"`         // dotted field path -> value
+	Exists       bool           `json:"exists"`         // false before creation and after deletion
+	LastModified time.Time      `json:"last_modified"`  // timestamp of the last replayed entry
+	EntryCount   int            `json:"entry_count"`    // number of entries replayed
+	Gaps         []HistoryGap   `json:"gaps,omitempty"` // inconsistencies found while replaying
+}
+
+// HistoryGapReason describes why a history entry could not be replayed cleanly
+type HistoryGapReason string
+
+const (
+	GapMissingOldValue      HistoryGapReason = "missing_old_value"      // entry has no old value for a known field
+	GapInconsistentOldValue HistoryGapReason = "inconsistent_old_value" // entry's old value differs from the replayed state
+	GapUnknownOldValue      HistoryGapReason = "unknown_old_value"      // entry has an old value for a field never replayed
+)
+
+// HistoryGap represents a change whose old value does not match the state
+// reconstructed from earlier entries, which usually means entries are missing
+type HistoryGap struct {
+	EntryID   primitive.ObjectID `json:"entry_id"`
+	Timestamp time.Time          `json:"timestamp"`
+	Field     string             `json:"field"`
+	Reason    HistoryGapReason   `json:"reason"`
+	Expected  any                `json:"expected,omitempty"` // value according to the replayed state
+	Recorded  any                `json:"recorded,omitempty"` // old value recorded in the entry
+}
+
+// ResourceDiff represents the changes to a resource between two points in time
+type ResourceDiff struct {
+	From    *ResourceState `json:"from"`
+	To      *ResourceState `json:"to"`
+	Changes []FieldChange  `json:"changes,omitempty"`
+	Gaps    []HistoryGap   `json:"gaps,omitempty"` // gaps found in entries between From.At and To.At
+}
+
+// ReconstructResource replays the resource history chronologically and
+// returns the field state of the resource at the given time. Failed entries
+// are skipped; create entries reset the state and delete entries mark the
+// resource as no longer existing while keeping its last known fields.
+func ReconstructResource(ctx context.Context, service AuditService, resourceType, resourceID string, at time.Time) (*ResourceState, error) {
+	entries, err := chronologicalHistory(ctx, service, resourceType, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return replayHistory(resourceType, resourceID, entries, at), nil
+}
+
+// DiffResource reconstructs the resource at two points in time and returns
+// the fields that differ between them
+func DiffResource(ctx context.Context, service AuditService, resourceType, resourceID string, from, to time.Time) (*ResourceDiff, error) {
+	if from.After(to) {
+		return nil, fmt.Errorf("from time cannot be after to time")
+	}
+
+	entries, err := chronologicalHistory(ctx, service, resourceType, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	fromState := replayHistory(resourceType, resourceID, entries, from)
+	toState := replayHistory(resourceType, resourceID, entries, to)
+
+	diff := &ResourceDiff{
+		From: fromState,
+		To:   toState,
+	}
+
+	for _, field := range unionKeys(fromState.Fields, toState.Fields) {
+		oldValue, hadOld := fromState.Fields[field]
+		newValue, hasNew := toState.Fields[field]
+		if hadOld == hasNew && valuesEqual(oldValue, newValue) {
+			continue
+		}
+		diff.Changes = append(diff.Changes, FieldChange{
+			Field:    field,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+
+	for _, gap := range toState.Gaps {
+		if gap.Timestamp.After(from) {
+			diff.Gaps = append(diff.Gaps, gap)
+		}
+	}
+
+	return diff, nil
+}
+
+// chronologicalHistory loads the complete resource history ordered oldest first
+func chronologicalHistory(ctx context.Context, service AuditService, resourceType, resourceID string) ([]AuditEntry, error) {
+	entries, err := service.GetResourceHistory(ctx, resourceType, resourceID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load resource history: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Timestamp.Equal(entries[j].Timestamp) {
+			return entries[i].Timestamp.Before(entries[j].Timestamp)
+		}
+		return entries[i].ID.Hex() < entries[j].ID.Hex()
+	})
+
+	return entries, nil
+}
+
+// replayHistory applies chronologically ordered entries up to and including at
+func replayHistory(resourceType, resourceID string, entries []AuditEntry, at time.Time) *ResourceState {
+	state := &ResourceState{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		At:           at,
+		Fields:       make(map[string]any),
+	}
+
+	for _, entry := range entries {
+		if entry.Timestamp.After(at) {
+			break
+		}
+		if !entry.Success {
+			continue
+		}
+
+		switch entry.Action {
+		case ActionCreate:
+			state.Fields = make(map[string]any)
+			state.Exists = true
+		case ActionDelete:
+			state.Exists = false
+		default:
+			if len(entry.Changes) > 0 {
+				state.Exists = true
+			}
+		}
+
+		for _, change := range entry.Changes {
+			if entry.Action != ActionCreate {
+				if gap, ok := checkOldValue(state.Fields, entry, change); ok {
+					state.Gaps = append(state.Gaps, gap)
+				}
+			}
+			setField(state.Fields, change.Field, change.NewValue)
+		}
+
+		state.LastModified = entry.Timestamp
+		state.EntryCount++
+	}
+
+	return state
+}
+
+// checkOldValue compares a change's recorded old value with the replayed state
+func checkOldValue(fields map[string]any, entry AuditEntry, change FieldChange) (HistoryGap, bool) {
+	gap := HistoryGap{
+		EntryID:   entry.ID,
+		Timestamp: entry.Timestamp,
+		Field:     change.Field,
+		Recorded:  change.OldValue,
+	}
+
+	known, ok := fields[change.Field]
+	if !ok {
+		// A field set for the first time had no value, unless earlier entries
+		// such as the create entry are missing. Values recorded above or below
+		// the field's path cannot be compared with it.
+		if change.OldValue == nil || hasRelatedField(fields, change.Field) {
+			return HistoryGap{}, false
+		}
+		gap.Reason = GapUnknownOldValue
+		return gap, true
+	}
+	gap.Expected = known
+
+	switch {
+	case change.OldValue == nil && known != nil:
+		gap.Reason = GapMissingOldValue
+	case !valuesEqual(known, change.OldValue):
+		gap.Reason = GapInconsistentOldValue
+	default:
+		return HistoryGap{}, false
+	}
+
+	return gap, true
+}
+
+// hasRelatedField reports whether a value is recorded at a parent or child of
+// a dotted path
+func hasRelatedField(fields map[string]any, path string) bool {
+	for key := range fields {
+		if strings.HasPrefix(key, path+".") || strings.HasPrefix(path, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// setField stores a value at a dotted path, replacing any nested values
+// previously recorded below it. A nil value removes the field.
+func setField(fields map[string]any, path string, value any) {
+	prefix := path + "."
+	for key := range fields {
+		if strings.HasPrefix(key, prefix) {
+			delete(fields, key)
+		}
+	}
+
+	if value == nil {
+		delete(fields, path)
+		return
+	}
+	fields[path] = value
+}
+
+// unionKeys returns the sorted union of the keys of two maps
+func unionKeys(a, b map[string]any) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		seen[key] = struct{}{}
+	}
+	for key := range b {
+		seen[key] = struct{}{}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valuesEqual compares two decoded values, treating numbers of different
+// types as equal when they hold the same value
+func valuesEqual(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// toFloat converts any numeric value to float64
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
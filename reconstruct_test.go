@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var historyStart = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// historyEntry returns a successful entry for user/user456 recorded the given
+// number of hours after historyStart
+func historyEntry(action AuditAction, hours int, changes ...FieldChange) AuditEntry {
+	entry := NewAuditBuilder().
+		Action(action).
+		User("user123", "").
+		Resource("user", "user456", "").
+		Success(true).
+		Build()
+	entry.ID = primitive.NewObjectID()
+	entry.Timestamp = historyStart.Add(time.Duration(hours) * time.Hour)
+	entry.Changes = changes
+	return entry
+}
+
+func TestReconstructResource(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestFileRepository(t)
+	service := NewServiceWithRepository(repo)
+
+	failed := historyEntry(ActionUpdate, 3, FieldChange{Field: "email", OldValue: "b@example.com", NewValue: "c@example.com"})
+	failed.Success = false
+	insertEntries(t, repo,
+		historyEntry(ActionCreate, 1,
+			FieldChange{Field: "name", NewValue: "Jane"},
+			FieldChange{Field: "email", NewValue: "a@example.com"}),
+		historyEntry(ActionUpdate, 2, FieldChange{Field: "email", OldValue: "a@example.com", NewValue: "b@example.com"}),
+		failed,
+		historyEntry(ActionDelete, 4),
+	)
+
+	tests := []struct {
+		hours  int
+		exists bool
+		email  any
+		count  int
+	}{
+		{0, false, nil, 0},
+		{1, true, "a@example.com", 1},
+		{3, true, "b@example.com", 2},
+		{5, false, "b@example.com", 3},
+	}
+	for _, tt := range tests {
+		at := historyStart.Add(time.Duration(tt.hours) * time.Hour)
+		state, err := ReconstructResource(ctx, service, "user", "user456", at)
+		if err != nil {
+			t.Fatalf("ReconstructResource failed: %v", err)
+		}
+		if state.Exists != tt.exists || state.Fields["email"] != tt.email || state.EntryCount != tt.count {
+			t.Errorf("state at +%dh = exists %v, email %v, %d entries; want %v, %v, %d",
+				tt.hours, state.Exists, state.Fields["email"], state.EntryCount, tt.exists, tt.email, tt.count)
+		}
+		if len(state.Gaps) != 0 {
+			t.Errorf("state at +%dh has gaps %+v", tt.hours, state.Gaps)
+		}
+	}
+
+	diff, err := DiffResource(ctx, service, "user", "user456", historyStart.Add(time.Hour), historyStart.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("DiffResource failed: %v", err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Field != "email" ||
+		diff.Changes[0].OldValue != "a@example.com" || diff.Changes[0].NewValue != "b@example.com" {
+		t.Errorf("diff changes %+v", diff.Changes)
+	}
+
+	if _, err := DiffResource(ctx, service, "user", "user456", historyStart.Add(time.Hour), historyStart); err == nil {
+		t.Error("expected an error for reversed times")
+	}
+}
+
+func TestReplayHistoryGaps(t *testing.T) {
+	create := historyEntry(ActionCreate, 0,
+		FieldChange{Field: "email", NewValue: "a@example.com"},
+		FieldChange{Field: "address.city", NewValue: "Oslo"})
+
+	tests := []struct {
+		name    string
+		entries []AuditEntry
+		want    []HistoryGapReason
+	}{
+		{
+			name: "consistent",
+			entries: []AuditEntry{create,
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "email", OldValue: "a@example.com", NewValue: "b@example.com"}),
+				historyEntry(ActionUpdate, 2, FieldChange{Field: "phone", NewValue: "555"})},
+		},
+		{
+			name: "missing old value",
+			entries: []AuditEntry{create,
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "email", NewValue: "b@example.com"})},
+			want: []HistoryGapReason{GapMissingOldValue},
+		},
+		{
+			name: "inconsistent old value",
+			entries: []AuditEntry{create,
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "email", OldValue: "x@example.com", NewValue: "b@example.com"})},
+			want: []HistoryGapReason{GapInconsistentOldValue},
+		},
+		{
+			name: "old value for a field never set",
+			entries: []AuditEntry{create,
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "phone", OldValue: "555", NewValue: "556"})},
+			want: []HistoryGapReason{GapUnknownOldValue},
+		},
+		{
+			name: "no create entry",
+			entries: []AuditEntry{
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "email", OldValue: "a@example.com", NewValue: "b@example.com"}),
+				historyEntry(ActionUpdate, 2, FieldChange{Field: "email", OldValue: "b@example.com", NewValue: "c@example.com"})},
+			want: []HistoryGapReason{GapUnknownOldValue},
+		},
+		{
+			name: "nested fields",
+			entries: []AuditEntry{create,
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "address", OldValue: map[string]any{"city": "Oslo"}, NewValue: "unknown"})},
+		},
+		{
+			name: "numbers of different types",
+			entries: []AuditEntry{
+				historyEntry(ActionCreate, 0, FieldChange{Field: "age", NewValue: 30}),
+				historyEntry(ActionUpdate, 1, FieldChange{Field: "age", OldValue: 30.0, NewValue: 31})},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := replayHistory("user", "user456", tt.entries, historyStart.Add(time.Hour*24))
+			if len(state.Gaps) != len(tt.want) {
+				t.Fatalf("gaps %+v, want %v", state.Gaps, tt.want)
+			}
+			for i, gap := range state.Gaps {
+				if gap.Reason != tt.want[i] {
+					t.Errorf("gap %d reason %s, want %s", i, gap.Reason, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds named audit services, e.g. one per tenant or per subsystem.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	services map[string]AuditService
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{services: make(map[string]AuditService)}
+}
+
+// Register adds a service under a name, replacing any service registered
+// under it before. A nil service unregisters the name.
+func (r *Registry) Register(name string, service AuditService) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if service == nil {
+		delete(r.services, name)
+		return
+	}
+	r.services[name] = service
+}
+
+// Unregister removes the service registered under a name and returns it, or
+// nil. The service is not closed.
+func (r *Registry) Unregister(name string) AuditService {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	service := r.services[name]
+	delete(r.services, name)
+	return service
+}
+
+// Service returns the service registered under a name
+func (r *Registry) Service(name string) (AuditService, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	service, ok := r.services[name]
+	if !ok {
+		return nil, ErrServiceNotFound{Name: name}
+	}
+	return service, nil
+}
+
+// Names returns the registered names, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithService returns a copy of ctx bound to the service registered under a
+// name, see ContextWithService
+func (r *Registry) WithService(ctx context.Context, name string) (context.Context, error) {
+	service, err := r.Service(name)
+	if err != nil {
+		return ctx, err
+	}
+	return ContextWithService(ctx, service), nil
+}
+
+// Close unregisters and closes every service
+func (r *Registry) Close(ctx context.Context) error {
+	r.mu.Lock()
+	services := r.services
+	r.services = make(map[string]AuditService)
+	r.mu.Unlock()
+
+	var errs []error
+	for name, service := range services {
+		if err := service.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ErrServiceNotFound represents a lookup of a name no service is registered under
+type ErrServiceNotFound struct {
+	Name string
+}
+
+func (e ErrServiceNotFound) Error() string {
+	return "no audit service registered as " + e.Name
+}
+
+// serviceContextKey is the context key of the bound service
+type serviceContextKey struct{}
+
+// ContextWithService returns a copy of ctx bound to a service. Builders
+// created with NewAuditBuilder and the package-level functions such as
+// LogAction use the service bound to their context instead of the default
+// service.
+func ContextWithService(ctx context.Context, service AuditService) context.Context {
+	return context.WithValue(ctx, serviceContextKey{}, service)
+}
+
+// ServiceFromContext returns the service bound to ctx, if any
+func ServiceFromContext(ctx context.Context) (AuditService, bool) {
+	service, ok := ctx.Value(serviceContextKey{}).(AuditService)
+	return service, ok && service != nil
+}
+
+// serviceHolder lets an interface value be stored atomically
+type serviceHolder struct {
+	service AuditService
+}
+
+// defaultService is the service used when a context is not bound to one
+var defaultService atomic.Pointer[serviceHolder]
+
+// SetDefaultService sets the service used when a context is not bound to one.
+// It is safe for concurrent use; nil unsets it.
+func SetDefaultService(service AuditService) {
+	defaultService.Store(&serviceHolder{service: service})
+}
+
+// DefaultService returns the default service, or nil
+func DefaultService() AuditService {
+	if holder := defaultService.Load(); holder != nil {
+		return holder.service
+	}
+	return nil
+}
+
+// serviceFor returns the service bound to ctx, falling back to the default
+// service
+func serviceFor(ctx context.Context) (AuditService, error) {
+	if ctx != nil {
+		if service, ok := ServiceFromContext(ctx); ok {
+			return service, nil
+		}
+	}
+	if service := DefaultService(); service != nil {
+		return service, nil
+	}
+	return nil, ErrNoServiceConfigured{}
+}
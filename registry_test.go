@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// closeRepository records whether it was closed and fails closing with err
+type closeRepository struct {
+	*memoryRepository
+	closed bool
+	err    error
+}
+
+func (r *closeRepository) Close(ctx context.Context) error {
+	r.closed = true
+	return r.err
+}
+
+// useDefaultService sets the default service for the duration of a test
+func useDefaultService(t *testing.T, service AuditService) {
+	t.Helper()
+
+	previous := DefaultService()
+	SetDefaultService(service)
+	t.Cleanup(func() { SetDefaultService(previous) })
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry()
+
+	repos := map[string]*closeRepository{
+		"billing": {memoryRepository: &memoryRepository{}},
+		"auth":    {memoryRepository: &memoryRepository{}, err: errors.New("close failed")},
+		"search":  {memoryRepository: &memoryRepository{}},
+	}
+	services := map[string]AuditService{}
+	for name, repo := range repos {
+		services[name] = NewServiceWithRepository(repo)
+		registry.Register(name, services[name])
+	}
+
+	if names := registry.Names(); !slices.Equal(names, []string{"auth", "billing", "search"}) {
+		t.Errorf("Names = %v", names)
+	}
+	if service, err := registry.Service("billing"); err != nil || service != services["billing"] {
+		t.Errorf("Service = %v, %v", service, err)
+	}
+
+	// A nil service and Unregister both remove the name
+	registry.Register("search", nil)
+	if service := registry.Unregister("billing"); service != services["billing"] {
+		t.Errorf("Unregister returned %v", service)
+	}
+	if service := registry.Unregister("billing"); service != nil {
+		t.Errorf("second Unregister returned %v", service)
+	}
+	var notFound ErrServiceNotFound
+	if _, err := registry.Service("billing"); !errors.As(err, &notFound) || notFound.Name != "billing" {
+		t.Errorf("Service = %v, want ErrServiceNotFound", err)
+	}
+	if _, err := registry.WithService(ctx, "search"); !errors.As(err, &notFound) {
+		t.Errorf("WithService = %v, want ErrServiceNotFound", err)
+	}
+
+	bound, err := registry.WithService(ctx, "auth")
+	if err != nil {
+		t.Fatalf("WithService failed: %v", err)
+	}
+	if service, ok := ServiceFromContext(bound); !ok || service != services["auth"] {
+		t.Errorf("bound service %v", service)
+	}
+
+	// Close closes the remaining services and reports their errors
+	if err := registry.Close(ctx); err == nil {
+		t.Error("expected the close error of auth")
+	}
+	if !repos["auth"].closed || repos["billing"].closed || repos["search"].closed {
+		t.Errorf("closed auth %v, billing %v, search %v", repos["auth"].closed, repos["billing"].closed, repos["search"].closed)
+	}
+	if names := registry.Names(); len(names) != 0 {
+		t.Errorf("Names after Close = %v", names)
+	}
+}
+
+func TestServiceResolution(t *testing.T) {
+	ctx := context.Background()
+	useDefaultService(t, nil)
+
+	log := func(ctx context.Context, builder *AuditBuilder) error {
+		return builder.Create().User("user123", "").Resource("document", "doc1", "").Success(true).Log(ctx)
+	}
+
+	var noService ErrNoServiceConfigured
+	if err := log(ctx, NewAuditBuilder()); !errors.As(err, &noService) {
+		t.Fatalf("Log = %v, want ErrNoServiceConfigured", err)
+	}
+
+	defaultRepo, boundRepo, ownRepo := &memoryRepository{}, &memoryRepository{}, &memoryRepository{}
+	SetDefaultService(NewServiceWithRepository(defaultRepo))
+	bound := ContextWithService(ctx, NewServiceWithRepository(boundRepo))
+
+	// The builder's own service wins over the context, which wins over the default
+	for _, err := range []error{
+		log(ctx, NewAuditBuilder()),
+		log(bound, NewAuditBuilder()),
+		log(bound, NewAuditBuilderWithService(NewServiceWithRepository(ownRepo))),
+		LogAction(bound, testEntry("doc2")),
+	} {
+		if err != nil {
+			t.Fatalf("Log failed: %v", err)
+		}
+	}
+	for name, tt := range map[string]struct {
+		repo *memoryRepository
+		want int
+	}{
+		"default": {defaultRepo, 1},
+		"bound":   {boundRepo, 2},
+		"own":     {ownRepo, 1},
+	} {
+		if n := len(tt.repo.stored()); n != tt.want {
+			t.Errorf("%s service stored %d entries, want %d", name, n, tt.want)
+		}
+	}
+
+	// A context bound to nil falls back to the default service
+	unbound := ContextWithService(ctx, nil)
+	if _, ok := ServiceFromContext(unbound); ok {
+		t.Error("context bound to nil reports a service")
+	}
+	if err := log(unbound, NewAuditBuilder()); err != nil || len(defaultRepo.stored()) != 2 {
+		t.Errorf("Log = %v, default service stored %d entries, want 2", err, len(defaultRepo.stored()))
+	}
+}
+
+func TestDefaultServiceConcurrent(t *testing.T) {
+	ctx := context.Background()
+	repos := []*memoryRepository{{}, {}}
+	services := []AuditService{NewServiceWithRepository(repos[0]), NewServiceWithRepository(repos[1])}
+	useDefaultService(t, services[0])
+
+	const workers, logs = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < logs; i++ {
+				SetDefaultService(services[(w+i)%2])
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < logs; i++ {
+				err := NewAuditBuilder().View().User("user123", "").Resource("document", "doc1", "").Success(true).Log(ctx)
+				if err != nil {
+					t.Errorf("Log failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(repos[0].stored()) + len(repos[1].stored()); n != workers*logs {
+		t.Errorf("stored %d entries, want %d", n, workers*logs)
+	}
+}
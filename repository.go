@@ -2,6 +2,11 @@ package audit
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // AuditRepository defines the interface for audit data storage
@@ -27,3 +32,87 @@ type AuditRepository interface {
 	// Close closes the repository connection
 	Close(ctx context.Context) error
 }
+
+// SessionRepository is implemented by repositories that can insert an entry
+// as part of a caller's MongoDB transaction
+type SessionRepository interface {
+	// InsertInSession inserts an entry using the session of sc, so that the
+	// insert commits or aborts together with the caller's transaction
+	InsertInSession(sc mongo.SessionContext, entry AuditEntry) error
+}
+
+// ErrTransactionsUnsupported represents a session write on a repository that
+// cannot take part in a MongoDB transaction
+type ErrTransactionsUnsupported struct{}
+
+func (e ErrTransactionsUnsupported) Error() string {
+	return "audit repository does not support transactional writes"
+}
+
+// sessionRepository finds a SessionRepository in a chain of repository
+// wrappers
+func sessionRepository(repo AuditRepository) (SessionRepository, bool) {
+	return findRepository[SessionRepository](repo)
+}
+
+// RetentionRepository is implemented by repositories whose retention can be
+// changed while they are in use
+type RetentionRepository interface {
+	// SetRetention changes how long entries are kept; 0 keeps them forever
+	SetRetention(ctx context.Context, retention time.Duration) error
+}
+
+// RangeRepository is implemented by repositories that can page through a
+// time range by keyset, without counting or skipping entries
+type RangeRepository interface {
+	// FindRange returns up to limit entries with a timestamp in [start, end)
+	// that sort after the cursor, ordered by timestamp then ID. A nil cursor
+	// starts at the beginning of the range.
+	FindRange(ctx context.Context, start, end time.Time, after *RangeCursor, limit int) ([]AuditEntry, error)
+}
+
+// RangeCursor is the position of an entry in timestamp then ID order
+type RangeCursor struct {
+	Timestamp time.Time
+	ID        primitive.ObjectID
+}
+
+// CursorOf returns the position of an entry
+func CursorOf(entry AuditEntry) *RangeCursor {
+	return &RangeCursor{Timestamp: entry.Timestamp, ID: entry.ID}
+}
+
+// findRepository finds a repository implementing T in a chain of repository
+// wrappers. Wrappers expose the repository they wrap with an
+// Unwrap() AuditRepository method.
+func findRepository[T any](repo AuditRepository) (T, bool) {
+	for repo != nil {
+		if found, ok := repo.(T); ok {
+			return found, true
+		}
+		u, ok := repo.(interface{ Unwrap() AuditRepository })
+		if !ok {
+			break
+		}
+		repo = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// ErrDuplicateEntry represents an attempt to insert an entry whose ID already exists
+type ErrDuplicateEntry struct {
+	ID string
+}
+
+func (e ErrDuplicateEntry) Error() string {
+	return "audit entry already exists: " + e.ID
+}
+
+// IsDuplicateEntry reports whether an error was caused by inserting an entry
+// whose ID already exists, either as ErrDuplicateEntry or as a MongoDB
+// duplicate key error
+func IsDuplicateEntry(err error) bool {
+	var dup ErrDuplicateEntry
+	return errors.As(err, &dup) || mongo.IsDuplicateKeyError(err)
+}
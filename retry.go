@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RetryPolicy decides whether and when a failed operation is retried
+type RetryPolicy interface {
+	// NextDelay returns the delay before retry number attempt (starting at
+	// 1), given the time elapsed since the first attempt and the error of
+	// the previous attempt. It returns false if the operation should not be
+	// retried.
+	NextDelay(attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy whose delay grows geometrically from
+// InitialDelay up to MaxDelay, randomised by Jitter
+type ExponentialBackoff struct {
+	InitialDelay   time.Duration
+	MaxDelay       time.Duration    // 0 means no cap
+	Multiplier     float64          // values below 1 are treated as 1
+	Jitter         float64          // fraction of the delay randomised in both directions, 0 to 1
+	MaxRetries     int              // 0 means no retries
+	MaxElapsedTime time.Duration    // 0 means no limit
+	Retryable      func(error) bool // defaults to IsRetryableError
+}
+
+// NextDelay implements RetryPolicy
+func (b ExponentialBackoff) NextDelay(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	retryable := b.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+	if attempt > b.MaxRetries || !retryable(err) {
+		return 0, false
+	}
+
+	multiplier := math.Max(b.Multiplier, 1)
+	delay := float64(b.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+	if jitter := math.Min(math.Max(b.Jitter, 0), 1); jitter > 0 {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+
+	if b.MaxElapsedTime > 0 && elapsed+time.Duration(delay) > b.MaxElapsedTime {
+		return 0, false
+	}
+	return time.Duration(delay), true
+}
+
+// MongoDB server error codes that indicate a transient condition
+var retryableServerCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsRetryableError reports whether an error is transient, i.e. a network
+// error, a timeout or a replica set state change. Cancellation, duplicate
+// keys, validation and other server errors are not retryable.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || IsDuplicateEntry(err) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && labeled.HasErrorLabel("RetryableWriteError") {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		for _, code := range retryableServerCodes {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// retry runs op until it succeeds, the policy gives up or ctx is done, and
+// returns the number of attempts made. Waits between attempts are
+// interrupted by ctx.
+func retry(ctx context.Context, policy RetryPolicy, op func(attempt int) error) (int, error) {
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if err == nil {
+			return attempt, nil
+		}
+		if ctx.Err() != nil {
+			return attempt, err
+		}
+
+		delay, ok := policy.NextDelay(attempt, time.Since(start), err)
+		if !ok {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuntimeConfig represents the settings of a service that can change while
+// it runs. Connection settings are not part of it.
+type RuntimeConfig struct {
+	// Retention is applied to the repository if it supports changing it, see
+	// RetentionRepository; 0 keeps the retention the repository is configured
+	// with, e.g. FileRetention or TimeSeriesExpireAfter
+	Retention time.Duration `json:"retention" yaml:"retention"`
+
+	// Redact lists change fields and metadata keys whose values are replaced
+	// by RedactedValue before entries are written. A name matches a field
+	// path or its last segment, e.g. "password" matches "user.password".
+	Redact []string `json:"redact" yaml:"redact"`
+
+	// MirrorLevel is the level at which WithSlogMirror logs written entries:
+	// "debug", "info", "warn" or "error". Failed writes are always logged at
+	// error level. Defaults to info.
+	MirrorLevel string `json:"mirror_level" yaml:"mirror_level"`
+
+	// Sampling replaces the rules of the sampler given with WithSampler. Nil
+	// keeps the current rules; an empty list removes them all.
+	Sampling []SamplingRule `json:"sampling" yaml:"sampling"`
+}
+
+// Validate validates the runtime configuration
+func (c RuntimeConfig) Validate() error {
+	if c.Retention < 0 {
+		return ErrInvalidConfig{Field: "Retention", Message: "cannot be negative"}
+	}
+	for _, name := range c.Redact {
+		if strings.TrimSpace(name) == "" {
+			return ErrInvalidConfig{Field: "Redact", Message: "cannot contain empty names"}
+		}
+	}
+	if _, err := c.mirrorLevel(); err != nil {
+		return err
+	}
+	for i, rule := range c.Sampling {
+		if err := rule.Validate(); err != nil {
+			var invalid ErrInvalidConfig
+			if !errors.As(err, &invalid) {
+				return fmt.Errorf("Sampling[%d]: %w", i, err)
+			}
+			invalid.Field = fmt.Sprintf("Sampling[%d].%s", i, invalid.Field)
+			return invalid
+		}
+	}
+	return nil
+}
+
+// samplingChanged reports whether a configuration replaces the sampling
+// rules of the previous one
+func (c RuntimeConfig) samplingChanged(old RuntimeConfig) bool {
+	return c.Sampling != nil && (old.Sampling == nil || !slices.Equal(c.Sampling, old.Sampling))
+}
+
+// mirrorLevel parses MirrorLevel
+func (c RuntimeConfig) mirrorLevel() (slog.Level, error) {
+	var level slog.Level
+	if c.MirrorLevel == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(c.MirrorLevel)); err != nil {
+		return 0, ErrInvalidConfig{Field: "MirrorLevel", Message: fmt.Sprintf("unknown level %q", c.MirrorLevel)}
+	}
+	return level, nil
+}
+
+// runtimeState is an applied runtime configuration
+type runtimeState struct {
+	config      RuntimeConfig
+	redact      map[string]bool
+	mirrorLevel slog.Level
+}
+
+// newRuntimeState prepares a validated runtime configuration for use
+func newRuntimeState(config RuntimeConfig) *runtimeState {
+	state := &runtimeState{
+		config: config,
+		redact: make(map[string]bool, len(config.Redact)),
+	}
+	state.mirrorLevel, _ = config.mirrorLevel()
+	for _, name := range config.Redact {
+		state.redact[name] = true
+	}
+	return state
+}
+
+// redacts reports whether the value of a field path must be redacted
+func (s *runtimeState) redacts(path string) bool {
+	if s == nil || len(s.redact) == 0 {
+		return false
+	}
+	if s.redact[path] {
+		return true
+	}
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return s.redact[path[i+1:]]
+	}
+	return false
+}
+
+// RuntimeConfigSource delivers runtime configurations to a service
+type RuntimeConfigSource interface {
+	// Watch calls apply with the current configuration, then with every
+	// change, until ctx is done. apply returns an error for configurations
+	// that were rejected, in which case the previous one stays in effect.
+	Watch(ctx context.Context, apply func(RuntimeConfig) error) error
+}
+
+// RuntimeConfigFunc adapts a function to a RuntimeConfigSource, e.g. to push
+// configurations received from a configuration service
+type RuntimeConfigFunc func(ctx context.Context, apply func(RuntimeConfig) error) error
+
+// Watch calls f
+func (f RuntimeConfigFunc) Watch(ctx context.Context, apply func(RuntimeConfig) error) error {
+	return f(ctx, apply)
+}
+
+// FileRuntimeSource polls a YAML or JSON file, chosen by its extension, and
+// delivers its content whenever it changes. Durations take units, e.g. "720h".
+type FileRuntimeSource struct {
+	Path     string
+	Interval time.Duration // defaults to 10 seconds
+
+	// OnError is called when the file cannot be loaded or its configuration
+	// is rejected. Optional.
+	OnError func(err error)
+}
+
+// NewFileRuntimeSource creates a source polling the given file
+func NewFileRuntimeSource(path string, interval time.Duration) *FileRuntimeSource {
+	return &FileRuntimeSource{Path: path, Interval: interval}
+}
+
+// Watch polls the file until ctx is done
+func (s *FileRuntimeSource) Watch(ctx context.Context, apply func(RuntimeConfig) error) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last [sha256.Size]byte
+	var modTime time.Time
+	var size int64 = -1
+	for {
+		if info, err := os.Stat(s.Path); err != nil {
+			s.report(fmt.Errorf("failed to read runtime config: %w", err))
+		} else if !info.ModTime().Equal(modTime) || info.Size() != size {
+			modTime, size = info.ModTime(), info.Size()
+			s.poll(&last, apply)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll loads the file and applies it if its content changed
+func (s *FileRuntimeSource) poll(last *[sha256.Size]byte, apply func(RuntimeConfig) error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		s.report(fmt.Errorf("failed to read runtime config: %w", err))
+		return
+	}
+	sum := sha256.Sum256(data)
+	if sum == *last {
+		return
+	}
+	*last = sum
+
+	config, err := parseRuntimeConfig(s.Path, data)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	if err := apply(config); err != nil {
+		s.report(err)
+	}
+}
+
+// report passes an error to OnError
+func (s *FileRuntimeSource) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// runtimeConfigFile is the file representation of a RuntimeConfig
+type runtimeConfigFile struct {
+	Retention   string             `json:"retention" yaml:"retention"`
+	Redact      []string           `json:"redact" yaml:"redact"`
+	MirrorLevel string             `json:"mirror_level" yaml:"mirror_level"`
+	Sampling    []samplingRuleFile `json:"sampling" yaml:"sampling"`
+}
+
+// samplingRuleFile is the file representation of a SamplingRule
+type samplingRuleFile struct {
+	Action       AuditAction `json:"action,omitempty" yaml:"action,omitempty"`
+	ResourceType string      `json:"resource_type,omitempty" yaml:"resource_type,omitempty"`
+	ActorType    ActorType   `json:"actor_type,omitempty" yaml:"actor_type,omitempty"`
+	Rate         float64     `json:"rate,omitempty" yaml:"rate,omitempty"`
+	FirstN       int         `json:"first_n,omitempty" yaml:"first_n,omitempty"`
+	Window       string      `json:"window,omitempty" yaml:"window,omitempty"`
+	Limit        float64     `json:"limit,omitempty" yaml:"limit,omitempty"`
+	Burst        int         `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+// file returns the file representation of the configuration, which also
+// shows durations readably in recorded changes
+func (c RuntimeConfig) file() runtimeConfigFile {
+	file := runtimeConfigFile{
+		Retention:   c.Retention.String(),
+		Redact:      c.Redact,
+		MirrorLevel: c.MirrorLevel,
+	}
+	for _, rule := range c.Sampling {
+		ruleFile := samplingRuleFile{
+			Action:       rule.Action,
+			ResourceType: rule.ResourceType,
+			ActorType:    rule.ActorType,
+			Rate:         rule.Rate,
+			FirstN:       rule.FirstN,
+			Limit:        rule.Limit,
+			Burst:        rule.Burst,
+		}
+		if rule.Window != 0 {
+			ruleFile.Window = rule.Window.String()
+		}
+		file.Sampling = append(file.Sampling, ruleFile)
+	}
+	return file
+}
+
+// parseRuntimeConfig decodes a YAML or JSON runtime configuration
+func parseRuntimeConfig(path string, data []byte) (RuntimeConfig, error) {
+	var file runtimeConfigFile
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	default:
+		return RuntimeConfig{}, fmt.Errorf("unsupported runtime config file extension %q: use .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return RuntimeConfig{}, fmt.Errorf("failed to parse runtime config %s: %w", path, err)
+	}
+
+	config := RuntimeConfig{Redact: file.Redact, MirrorLevel: file.MirrorLevel}
+	if file.Retention != "" {
+		config.Retention, err = time.ParseDuration(file.Retention)
+		if err != nil {
+			return RuntimeConfig{}, ErrInvalidConfig{Field: path + ": retention", Message: fmt.Sprintf("invalid duration %q", file.Retention)}
+		}
+	}
+	if file.Sampling != nil {
+		config.Sampling = make([]SamplingRule, 0, len(file.Sampling))
+	}
+	for i, ruleFile := range file.Sampling {
+		rule := SamplingRule{
+			Action:       ruleFile.Action,
+			ResourceType: ruleFile.ResourceType,
+			ActorType:    ruleFile.ActorType,
+			Rate:         ruleFile.Rate,
+			FirstN:       ruleFile.FirstN,
+			Limit:        ruleFile.Limit,
+			Burst:        ruleFile.Burst,
+		}
+		if ruleFile.Window != "" {
+			rule.Window, err = time.ParseDuration(ruleFile.Window)
+			if err != nil {
+				return RuntimeConfig{}, ErrInvalidConfig{Field: fmt.Sprintf("%s: sampling[%d].window", path, i), Message: fmt.Sprintf("invalid duration %q", ruleFile.Window)}
+			}
+		}
+		config.Sampling = append(config.Sampling, rule)
+	}
+	return config, nil
+}
+
+// runtimeConfigWait bounds how long a new service waits for the first
+// runtime configuration, so that early entries are already redacted
+const runtimeConfigWait = 5 * time.Second
+
+// runtimeWatcher runs the runtime configuration source of a service
+type runtimeWatcher struct {
+	source RuntimeConfigSource
+	mu     sync.Mutex // serialises applies
+	state  atomic.Pointer[runtimeState]
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WithRuntimeConfig applies the runtime configurations delivered by source
+// until the service is closed. Each configuration is validated first; when
+// it is applied, a system audit entry records the changed settings. The
+// service is returned once the first configuration was delivered, or after
+// 5 seconds.
+func WithRuntimeConfig(source RuntimeConfigSource) ServiceOption {
+	return func(s *auditService) {
+		s.runtime = &runtimeWatcher{source: source}
+	}
+}
+
+// start watches the source once every option has been applied, and waits
+// for its first configuration
+func (w *runtimeWatcher) start(s *auditService) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	first := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(w.done)
+		defer once.Do(func() { close(first) })
+		w.source.Watch(ctx, func(config RuntimeConfig) error {
+			defer once.Do(func() { close(first) })
+			return s.applyRuntimeConfig(ctx, config)
+		})
+	}()
+
+	timer := time.NewTimer(runtimeConfigWait)
+	defer timer.Stop()
+	select {
+	case <-first:
+	case <-timer.C:
+	}
+}
+
+// runtimeState returns the applied runtime configuration, or nil
+func (s *auditService) runtimeState() *runtimeState {
+	if s.runtime == nil {
+		return nil
+	}
+	return s.runtime.state.Load()
+}
+
+// applyRuntimeConfig validates and applies a runtime configuration, then
+// records the change
+func (s *auditService) applyRuntimeConfig(ctx context.Context, config RuntimeConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	s.runtime.mu.Lock()
+	defer s.runtime.mu.Unlock()
+
+	previous := s.runtime.state.Load()
+	var old RuntimeConfig
+	if previous != nil {
+		old = previous.config
+	}
+
+	// Check that every setting can be applied before changing any of them
+	if config.samplingChanged(old) && s.sampler == nil {
+		return ErrInvalidConfig{Field: "Sampling", Message: "the service has no sampler, see WithSampler"}
+	}
+
+	// Without a retention the repository keeps the one in effect, so an
+	// empty configuration never turns expiry off; the state and the recorded
+	// changes show that retention too
+	if config.Retention == 0 {
+		config.Retention = old.Retention
+	}
+	// Likewise without sampling rules the current ones stay
+	if config.Sampling == nil {
+		config.Sampling = old.Sampling
+	}
+	if config.Retention != old.Retention {
+		repo, ok := findRepository[RetentionRepository](s.repo)
+		if !ok {
+			return ErrInvalidConfig{Field: "Retention", Message: "the repository does not support changing retention"}
+		}
+		if err := repo.SetRetention(ctx, config.Retention); err != nil {
+			return fmt.Errorf("failed to apply retention: %w", err)
+		}
+	}
+	if config.samplingChanged(old) {
+		if err := s.sampler.SetRules(config.Sampling...); err != nil {
+			return err
+		}
+	}
+
+	s.runtime.state.Store(newRuntimeState(config))
+
+	changes := Diff(old.file(), config.file())
+	if len(changes) == 0 {
+		return nil
+	}
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Action:    ActionUpdate,
+		Actor:     Actor{ID: "audit", Type: ActorTypeSystem, Name: "Audit runtime configuration"},
+		Resource:  AuditResource{Type: "audit_config", ID: "runtime"},
+		Changes:   changes,
+		Success:   true,
+	}
+	if err := s.LogAction(ctx, entry); err != nil {
+		return fmt.Errorf("runtime config applied but its change was not audited: %w", err)
+	}
+	return nil
+}
+
+// stop stops watching the source
+func (w *runtimeWatcher) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// redactEntry returns the entry with the values of redacted fields replaced.
+// The caller's changes and metadata are not modified.
+func (s *auditService) redactEntry(entry AuditEntry) AuditEntry {
+	state := s.runtimeState()
+	if state == nil || len(state.redact) == 0 {
+		return entry
+	}
+
+	if len(entry.Changes) > 0 {
+		changes := make([]FieldChange, len(entry.Changes))
+		for i, change := range entry.Changes {
+			if state.redacts(change.Field) {
+				if change.OldValue != nil {
+					change.OldValue = RedactedValue
+				}
+				if change.NewValue != nil {
+					change.NewValue = RedactedValue
+				}
+			}
+			changes[i] = change
+		}
+		entry.Changes = changes
+	}
+
+	if len(entry.Metadata) > 0 {
+		metadata := make(map[string]any, len(entry.Metadata))
+		for key, value := range entry.Metadata {
+			if state.redacts(key) {
+				value = RedactedValue
+			}
+			metadata[key] = value
+		}
+		entry.Metadata = metadata
+	}
+
+	return entry
+}
+
+// mirrorLevel returns the level at which written entries are mirrored
+func (s *auditService) mirrorLevel() slog.Level {
+	if state := s.runtimeState(); state != nil {
+		return state.mirrorLevel
+	}
+	return slog.LevelInfo
+}
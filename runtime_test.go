@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRuntimeRetentionKeepsRepositoryDefault(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.FileDir = t.TempDir()
+	config.FileRetention = 90 * 24 * time.Hour
+	repo, err := OpenFileRepository(config)
+	if err != nil {
+		t.Fatalf("OpenFileRepository failed: %v", err)
+	}
+
+	retentions := []time.Duration{0, 30 * 24 * time.Hour, 0}
+	applied := make(chan time.Duration)
+	source := RuntimeConfigFunc(func(ctx context.Context, apply func(RuntimeConfig) error) error {
+		for _, retention := range retentions {
+			if err := apply(RuntimeConfig{Retention: retention}); err != nil {
+				t.Errorf("apply failed: %v", err)
+			}
+			applied <- time.Duration(repo.retention.Load())
+		}
+		return nil
+	})
+	service := NewServiceWithRepository(repo, WithRuntimeConfig(source))
+	defer service.Close(ctx)
+
+	// A configuration without retention leaves the current one in place
+	for i, want := range []time.Duration{config.FileRetention, retentions[1], retentions[1]} {
+		if got := <-applied; got != want {
+			t.Errorf("retention after configuration %d = %v, want %v", i+1, got, want)
+		}
+	}
+
+	// and records no change
+	recorded, err := repo.FindByResource(ctx, "audit_config", "runtime", 0)
+	if err != nil {
+		t.Fatalf("FindByResource failed: %v", err)
+	}
+	if len(recorded) != 1 || len(recorded[0].Changes) != 1 || recorded[0].Changes[0].NewValue != "720h0m0s" {
+		t.Errorf("recorded changes %+v, want the retention set once", recorded)
+	}
+}
+
+func TestRuntimeSamplingRules(t *testing.T) {
+	ctx := context.Background()
+	sampler, err := NewSampler(SamplingRule{Action: ActionView, FirstN: 1, Window: time.Hour})
+	if err != nil {
+		t.Fatalf("NewSampler failed: %v", err)
+	}
+
+	configs := []RuntimeConfig{
+		{},
+		{Sampling: []SamplingRule{{Action: ActionView, FirstN: 2, Window: time.Hour}}},
+		{Sampling: []SamplingRule{}},
+	}
+	applied := make(chan int)
+	source := RuntimeConfigFunc(func(ctx context.Context, apply func(RuntimeConfig) error) error {
+		for n, config := range configs {
+			if err := apply(config); err != nil {
+				t.Errorf("apply failed: %v", err)
+			}
+			// Count the views of a new actor kept by the current rules
+			kept := 0
+			for i := 0; i < 5; i++ {
+				entry := testEntry("doc1")
+				entry.Actor.ID = fmt.Sprintf("user-%d", n)
+				if _, keep := sampler.sample(entry); keep {
+					kept++
+				}
+			}
+			applied <- kept
+		}
+		return nil
+	})
+	service := NewServiceWithRepository(&memoryRepository{}, WithSampler(sampler), WithRuntimeConfig(source))
+	defer service.Close(ctx)
+
+	// Without sampling the sampler keeps its rules; an empty list removes them
+	for i, want := range []int{1, 2, 5} {
+		if got := <-applied; got != want {
+			t.Errorf("kept %d views after configuration %d, want %d", got, i+1, want)
+		}
+	}
+}
+
+func TestRuntimeSamplingRequiresSampler(t *testing.T) {
+	ctx := context.Background()
+	errs := make(chan error, 1)
+	source := RuntimeConfigFunc(func(ctx context.Context, apply func(RuntimeConfig) error) error {
+		errs <- apply(RuntimeConfig{Sampling: []SamplingRule{{Action: ActionView, Rate: 0.5}}})
+		return nil
+	})
+	service := NewServiceWithRepository(&memoryRepository{}, WithRuntimeConfig(source))
+	defer service.Close(ctx)
+
+	var invalid ErrInvalidConfig
+	if err := <-errs; !errors.As(err, &invalid) || invalid.Field != "Sampling" {
+		t.Errorf("apply = %v, want an invalid Sampling error", err)
+	}
+}
+
+func TestParseRuntimeConfigSampling(t *testing.T) {
+	data := []byte("sampling:\n  - action: view\n    first_n: 10\n    window: 1m\n")
+	config, err := parseRuntimeConfig("runtime.yaml", data)
+	if err != nil {
+		t.Fatalf("parseRuntimeConfig failed: %v", err)
+	}
+	want := []SamplingRule{{Action: ActionView, FirstN: 10, Window: time.Minute}}
+	if !slices.Equal(config.Sampling, want) {
+		t.Errorf("sampling = %+v, want %+v", config.Sampling, want)
+	}
+
+	// An empty list is kept apart from a missing one
+	for data, empty := range map[string]bool{"sampling: []\n": true, "redact: [ssn]\n": false} {
+		config, err := parseRuntimeConfig("runtime.yaml", []byte(data))
+		if err != nil {
+			t.Fatalf("parseRuntimeConfig failed: %v", err)
+		}
+		if (config.Sampling != nil) != empty {
+			t.Errorf("sampling of %q = %#v", data, config.Sampling)
+		}
+	}
+
+	if _, err := parseRuntimeConfig("runtime.json", []byte(`{"sampling": [{"window": "soon"}]}`)); err == nil {
+		t.Error("expected an error for an invalid window")
+	}
+}
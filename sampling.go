@@ -0,0 +1,243 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SamplingRule reduces the volume of entries matching an action, a resource
+// type and/or an actor type. Empty fields match anything. The reductions of
+// a rule are applied in order: fixed-rate sampling, then the per-actor
+// quota, then the rate limit.
+type SamplingRule struct {
+	Action       AuditAction `json:"action,omitempty" yaml:"action,omitempty"`
+	ResourceType string      `json:"resource_type,omitempty" yaml:"resource_type,omitempty"`
+	ActorType    ActorType   `json:"actor_type,omitempty" yaml:"actor_type,omitempty"`
+
+	// Rate is the fraction of entries kept at random, e.g. 0.01 for one in a
+	// hundred. Kept entries record it as their SampleRate. Zero keeps every
+	// entry.
+	Rate float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+
+	// FirstN keeps only the first N entries of each actor per Window. Zero
+	// disables the quota. Kept entries do not record how many were
+	// discarded, so counts cannot be extrapolated from them; see
+	// SamplingStats.OverQuota for the total.
+	FirstN int           `json:"first_n,omitempty" yaml:"first_n,omitempty"`
+	Window time.Duration `json:"window,omitempty" yaml:"window,omitempty"`
+
+	// Limit is the maximum number of entries per second kept across all
+	// actors, with bursts of up to Burst entries. Zero disables the limit.
+	// As with FirstN, kept entries carry no rate; see SamplingStats.Limited.
+	Limit float64 `json:"limit,omitempty" yaml:"limit,omitempty"`
+	Burst int     `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+// Validate validates a sampling rule
+func (r SamplingRule) Validate() error {
+	if r.Rate < 0 || r.Rate > 1 {
+		return ErrInvalidConfig{Field: "Rate", Message: "must be between 0 and 1"}
+	}
+	if r.FirstN < 0 {
+		return ErrInvalidConfig{Field: "FirstN", Message: "cannot be negative"}
+	}
+	if r.FirstN > 0 && r.Window <= 0 {
+		return ErrInvalidConfig{Field: "Window", Message: "must be positive with FirstN"}
+	}
+	if r.Limit < 0 {
+		return ErrInvalidConfig{Field: "Limit", Message: "cannot be negative"}
+	}
+	if r.Burst < 0 {
+		return ErrInvalidConfig{Field: "Burst", Message: "cannot be negative"}
+	}
+	return nil
+}
+
+// specificity counts the fields a rule matches on
+func (r SamplingRule) specificity() int {
+	n := 0
+	for _, set := range []bool{r.Action != "", r.ResourceType != "", r.ActorType != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// matches reports whether a rule applies to an entry
+func (r SamplingRule) matches(entry AuditEntry) bool {
+	return (r.Action == "" || r.Action == entry.Action) &&
+		(r.ResourceType == "" || r.ResourceType == entry.Resource.Type) &&
+		(r.ActorType == "" || r.ActorType == entry.Actor.Type)
+}
+
+// SamplingStats represents the counters of a sampler. Entries matching no
+// rule are not counted.
+type SamplingStats struct {
+	Kept       int64 `json:"kept"`        // entries matching a rule that were logged
+	SampledOut int64 `json:"sampled_out"` // entries discarded by fixed-rate sampling
+	OverQuota  int64 `json:"over_quota"`  // entries discarded by a per-actor quota
+	Limited    int64 `json:"limited"`     // entries discarded by a rate limit
+}
+
+// Sampler discards part of high-volume entries, such as views from read
+// APIs, before they are logged. The matching rule with the most fields set
+// applies; among equally specific rules, the first one. Failed entries are
+// never discarded. A sampler is safe for concurrent use.
+type Sampler struct {
+	rules atomic.Pointer[[]*samplingState]
+
+	kept       atomic.Int64
+	sampledOut atomic.Int64
+	overQuota  atomic.Int64
+	limited    atomic.Int64
+}
+
+// samplingState holds a rule with its rate limiter and per-actor quotas
+type samplingState struct {
+	rule   SamplingRule
+	bucket *tokenBucket // nil without a limit
+
+	mu      sync.Mutex
+	windows map[string]*quotaWindow
+	swept   time.Time
+}
+
+// quotaWindow counts the entries of one actor in the current window
+type quotaWindow struct {
+	start time.Time
+	count int
+}
+
+// NewSampler creates a sampler applying the given rules
+func NewSampler(rules ...SamplingRule) (*Sampler, error) {
+	s := &Sampler{}
+	if err := s.SetRules(rules...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetRules replaces the rules of the sampler. Quotas and rate limits start
+// over; the counters are kept. Invalid rules leave the current ones in place.
+func (s *Sampler) SetRules(rules ...SamplingRule) error {
+	states := make([]*samplingState, 0, len(rules))
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			var invalid ErrInvalidConfig
+			if !errors.As(err, &invalid) {
+				return fmt.Errorf("Rules[%d]: %w", i, err)
+			}
+			invalid.Field = fmt.Sprintf("Rules[%d].%s", i, invalid.Field)
+			return invalid
+		}
+
+		state := &samplingState{rule: rule}
+		if rule.Limit > 0 {
+			state.bucket = newTokenBucket(rule.Limit, rule.Burst)
+		}
+		if rule.FirstN > 0 {
+			state.windows = make(map[string]*quotaWindow)
+		}
+		states = append(states, state)
+	}
+	s.rules.Store(&states)
+	return nil
+}
+
+// WithSampler discards part of the logged entries according to the
+// sampler's rules. Discarded entries are not an error: LogAction returns nil.
+// Entries logged within a transaction are never sampled.
+func WithSampler(sampler *Sampler) ServiceOption {
+	return func(s *auditService) {
+		s.sampler = sampler
+	}
+}
+
+// Stats returns the counters of the sampler
+func (s *Sampler) Stats() SamplingStats {
+	return SamplingStats{
+		Kept:       s.kept.Load(),
+		SampledOut: s.sampledOut.Load(),
+		OverQuota:  s.overQuota.Load(),
+		Limited:    s.limited.Load(),
+	}
+}
+
+// sample decides whether an entry is logged, and records the sampling rate
+// of kept entries
+func (s *Sampler) sample(entry AuditEntry) (AuditEntry, bool) {
+	state := s.ruleFor(entry)
+	if state == nil || !entry.Success {
+		return entry, true
+	}
+	rule := state.rule
+
+	if rule.Rate > 0 && rule.Rate < 1 {
+		if rand.Float64() >= rule.Rate {
+			s.sampledOut.Add(1)
+			return entry, false
+		}
+		entry.SampleRate = rule.Rate
+	}
+	if rule.FirstN > 0 && !state.withinQuota(entry, time.Now()) {
+		s.overQuota.Add(1)
+		return entry, false
+	}
+	if state.bucket != nil && !state.bucket.allow() {
+		s.limited.Add(1)
+		return entry, false
+	}
+
+	s.kept.Add(1)
+	return entry, true
+}
+
+// ruleFor returns the state of the rule applying to an entry, or nil
+func (s *Sampler) ruleFor(entry AuditEntry) *samplingState {
+	rules := s.rules.Load()
+	if rules == nil {
+		return nil
+	}
+
+	var best *samplingState
+	for _, state := range *rules {
+		if !state.rule.matches(entry) {
+			continue
+		}
+		if best == nil || state.rule.specificity() > best.rule.specificity() {
+			best = state
+		}
+	}
+	return best
+}
+
+// withinQuota counts an entry against its actor's quota and reports whether
+// the quota allows it
+func (st *samplingState) withinQuota(entry AuditEntry, now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// Forget the windows of actors that have been idle for a whole window
+	if now.Sub(st.swept) >= st.rule.Window {
+		for key, w := range st.windows {
+			if now.Sub(w.start) >= st.rule.Window {
+				delete(st.windows, key)
+			}
+		}
+		st.swept = now
+	}
+
+	key := entry.TenantID + "\x00" + string(entry.Actor.Type) + "\x00" + entry.Actor.ID
+	w, ok := st.windows[key]
+	if !ok || now.Sub(w.start) >= st.rule.Window {
+		w = &quotaWindow{start: now}
+		st.windows[key] = w
+	}
+	w.count++
+	return w.count <= st.rule.FirstN
+}
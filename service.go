@@ -3,6 +3,10 @@ package audit
 import (
 	"context"
 	"fmt"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // AuditService defines the interface for audit operations
@@ -26,37 +30,162 @@ type AuditService interface {
 	Close(ctx context.Context) error
 }
 
+// SessionService is implemented by services that can log an entry within a
+// caller's MongoDB transaction
+type SessionService interface {
+	// LogActionInSession logs an audit entry using the session of sc, so that
+	// the entry is committed or aborted together with the caller's transaction
+	LogActionInSession(sc mongo.SessionContext, entry AuditEntry) error
+}
+
+// ErrInvalidEntry represents an audit entry rejected by validation
+type ErrInvalidEntry struct {
+	Message string
+}
+
+func (e ErrInvalidEntry) Error() string {
+	return "invalid audit entry: " + e.Message
+}
+
 // auditService implements the AuditService interface
 type auditService struct {
-	repo AuditRepository
+	repo      AuditRepository
+	mirror    *slog.Logger
+	policy    *AuditPolicy
+	async     *asyncWriter
+	runtime   *runtimeWatcher
+	sampler   *Sampler
+	coalescer *Coalescer
+}
+
+// ServiceOption configures optional behaviour of an audit service
+type ServiceOption func(*auditService)
+
+// WithSlogMirror mirrors every logged entry into the given logger, after the
+// entry has been written. Entries that failed to be written are mirrored at
+// error level together with the write error.
+func WithSlogMirror(logger *slog.Logger) ServiceOption {
+	return func(s *auditService) {
+		s.mirror = logger
+	}
 }
 
 // NewService creates a new audit service with the given configuration
-func NewService(config *Config) (AuditService, error) {
+func NewService(config *Config, opts ...ServiceOption) (AuditService, error) {
 	repo, err := NewMongoRepository(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create repository: %w", err)
 	}
 
-	return &auditService{
-		repo: repo,
-	}, nil
+	if config.SpoolDir != "" {
+		spool, err := OpenSpool(config)
+		if err != nil {
+			repo.Close(context.Background())
+			return nil, fmt.Errorf("failed to open spool: %w", err)
+		}
+		// Applied last so the spool sees failures of every other repository wrapper
+		opts = append(opts[:len(opts):len(opts)], WithSpool(spool))
+	}
+
+	return NewServiceWithRepository(repo, opts...), nil
 }
 
 // NewServiceWithRepository creates a new audit service with a custom repository
-func NewServiceWithRepository(repo AuditRepository) AuditService {
-	return &auditService{
+func NewServiceWithRepository(repo AuditRepository, opts ...ServiceOption) AuditService {
+	s := &auditService{
 		repo: repo,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.runtime != nil {
+		s.runtime.start(s)
+	}
+	return s
 }
 
 // LogAction logs an audit entry
 func (s *auditService) LogAction(ctx context.Context, entry AuditEntry) error {
 	if err := s.validateAuditEntry(entry); err != nil {
-		return fmt.Errorf("invalid audit entry: %w", err)
+		return ErrInvalidEntry{Message: err.Error()}
 	}
+	entry = s.redactEntry(entry)
 
-	return s.repo.Insert(ctx, entry)
+	// Relayed entries are already durable elsewhere: they are written at once
+	// so that a nil error means stored
+	if syncWriteFromContext(ctx) {
+		return s.write(ctx, entry)
+	}
+
+	if s.sampler != nil {
+		var keep bool
+		if entry, keep = s.sampler.sample(entry); !keep {
+			return nil
+		}
+	}
+	// Fail-closed entries must be stored when LogAction returns, so they are
+	// never held
+	if s.coalescer != nil && s.modeFor(entry) != FailClosed && s.coalescer.hold(ctx, entry) {
+		return nil
+	}
+
+	return s.dispatch(ctx, entry)
+}
+
+// modeFor returns the failure mode of an entry; without a policy every entry
+// fails closed
+func (s *auditService) modeFor(entry AuditEntry) FailureMode {
+	if s.policy == nil {
+		return FailClosed
+	}
+	return s.policy.ModeFor(entry)
+}
+
+// dispatch writes an entry according to the failure policy
+func (s *auditService) dispatch(ctx context.Context, entry AuditEntry) error {
+	switch s.modeFor(entry) {
+	case FailOpen:
+		s.enqueue(ctx, entry, true)
+		return nil
+	case BestEffort:
+		s.enqueue(ctx, entry, false)
+		return nil
+	default:
+		return s.write(ctx, entry)
+	}
+}
+
+// LogActionInSession logs an audit entry within the caller's transaction. The
+// entry is written synchronously whatever the failure policy, and repository
+// wrappers such as the spool and the circuit breaker are bypassed: a failed
+// write must abort the transaction rather than be deferred.
+func (s *auditService) LogActionInSession(sc mongo.SessionContext, entry AuditEntry) error {
+	if err := s.validateAuditEntry(entry); err != nil {
+		return ErrInvalidEntry{Message: err.Error()}
+	}
+	entry = s.redactEntry(entry)
+
+	repo, ok := sessionRepository(s.repo)
+	if !ok {
+		return ErrTransactionsUnsupported{}
+	}
+
+	err := repo.InsertInSession(sc, entry)
+	if s.mirror != nil {
+		mirrorEntry(sc, s.mirror, s.mirrorLevel(), entry, err)
+	}
+
+	return err
+}
+
+// write inserts an entry into the repository and mirrors it if configured
+func (s *auditService) write(ctx context.Context, entry AuditEntry) error {
+	err := s.repo.Insert(ctx, entry)
+	if s.mirror != nil {
+		mirrorEntry(ctx, s.mirror, s.mirrorLevel(), entry, err)
+	}
+
+	return err
 }
 
 // GetHistory retrieves audit history based on query parameters
@@ -106,6 +235,15 @@ func (s *auditService) GetActorHistory(ctx context.Context, actorID string, acto
 
 // Close closes the service and underlying connections
 func (s *auditService) Close(ctx context.Context) error {
+	if s.runtime != nil {
+		s.runtime.stop()
+	}
+	if s.coalescer != nil {
+		s.coalescer.close()
+	}
+	if s.async != nil {
+		s.async.drain()
+	}
 	return s.repo.Close(ctx)
 }
 
@@ -127,6 +265,15 @@ func (s *auditService) validateAuditEntry(entry AuditEntry) error {
 		return fmt.Errorf("resource ID cannot be empty")
 	}
 
+	if entry.TenantID != "" {
+		if err := ValidateTenantID(entry.TenantID); err != nil {
+			return err
+		}
+	}
+	if entry.SampleRate < 0 || entry.SampleRate > 1 {
+		return fmt.Errorf("sample rate must be between 0 and 1")
+	}
+
 	// Validate actor type
 	switch entry.Actor.Type {
 	case ActorTypeUser, ActorTypeSystem, ActorTypeService, ActorTypeAPI, ActorTypeAdmin:
@@ -154,6 +301,14 @@ func (s *auditService) validateAuditQuery(query AuditQuery) error {
 	if query.Offset < 0 {
 		return fmt.Errorf("offset cannot be negative")
 	}
+	if query.ParentID != "" && !primitive.IsValidObjectID(query.ParentID) {
+		return fmt.Errorf("invalid parent ID: %s", query.ParentID)
+	}
+	if query.TenantID != "" {
+		if err := ValidateTenantID(query.TenantID); err != nil {
+			return err
+		}
+	}
 	if query.StartTime != nil && query.EndTime != nil {
 		if query.StartTime.After(*query.EndTime) {
 			return fmt.Errorf("start time cannot be after end time")
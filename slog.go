@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys recognised by SlogHandler and written by WithSlogMirror.
+// Keys may be given flat ("audit.action") or as groups
+// (slog.Group("audit", slog.String("action", ...))).
+const (
+	SlogKeyAction        = "audit.action"
+	SlogKeyActorID       = "audit.actor.id"
+	SlogKeyActorType     = "audit.actor.type"
+	SlogKeyActorName     = "audit.actor.name"
+	SlogKeySessionID     = "audit.actor.session_id"
+	SlogKeyResourceType  = "audit.resource.type"
+	SlogKeyResourceID    = "audit.resource.id"
+	SlogKeyResourceName  = "audit.resource.name"
+	SlogKeyChanges       = "audit.changes"
+	SlogKeyIPAddress     = "audit.ip_address"
+	SlogKeyUserAgent     = "audit.user_agent"
+	SlogKeySuccess       = "audit.success"
+	SlogKeyError         = "audit.error"
+	SlogKeyCorrelationID = "audit.correlation_id"
+)
+
+// slogAuditPrefix prefixes every designated attribute key
+const slogAuditPrefix = "audit."
+
+// SlogHandlerOptions represents the options of a SlogHandler
+type SlogHandlerOptions struct {
+	// Level is the minimum level of records converted to audit entries.
+	// Defaults to slog.LevelInfo.
+	Level slog.Leveler
+
+	// Next receives every record as well, so the handler can be inserted in
+	// front of an existing handler. Optional.
+	Next slog.Handler
+
+	// ErrorHandler is called when a converted entry cannot be logged. Optional.
+	ErrorHandler func(ctx context.Context, entry AuditEntry, err error)
+}
+
+// SlogHandler is a slog.Handler that converts records carrying an
+// SlogKeyAction attribute into audit entries and logs them with an
+// AuditService. Other attributes of such records are stored as metadata,
+// together with the record message. Records without an action are only
+// passed to the Next handler.
+type SlogHandler struct {
+	service AuditService
+	opts    SlogHandlerOptions
+	attrs   []slog.Attr // attributes added with WithAttrs, keys fully qualified
+	groups  []string
+}
+
+// slogMirrorKey marks contexts of records produced by WithSlogMirror so that
+// they are not converted back into audit entries
+type slogMirrorKey struct{}
+
+// NewSlogHandler creates a new slog handler that writes audit entries
+func NewSlogHandler(service AuditService, opts *SlogHandlerOptions) *SlogHandler {
+	h := &SlogHandler{service: service}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+// Enabled reports whether the handler handles records at the given level
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= h.opts.Level.Level() {
+		return true
+	}
+	return h.opts.Next != nil && h.opts.Next.Enabled(ctx, level)
+}
+
+// Handle converts the record into an audit entry if it carries an action
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.opts.Next != nil && h.opts.Next.Enabled(ctx, record.Level) {
+		if err := h.opts.Next.Handle(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	if record.Level < h.opts.Level.Level() || ctx.Value(slogMirrorKey{}) != nil {
+		return nil
+	}
+
+	values := make(map[string]slog.Value)
+	for _, attr := range h.attrs {
+		flattenAttr(values, "", attr)
+	}
+	prefix := strings.Join(h.groups, ".")
+	record.Attrs(func(attr slog.Attr) bool {
+		flattenAttr(values, prefix, attr)
+		return true
+	})
+
+	if _, ok := values[SlogKeyAction]; !ok {
+		return nil
+	}
+
+	entry := entryFromSlog(record, values)
+	if err := h.service.LogAction(ctx, entry); err != nil {
+		if h.opts.ErrorHandler != nil {
+			h.opts.ErrorHandler(ctx, entry, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// WithAttrs returns a handler whose records carry the given attributes
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	prefix := strings.Join(h.groups, ".")
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		if prefix != "" {
+			attr.Key = prefix + "." + attr.Key
+		}
+		clone.attrs = append(clone.attrs, attr)
+	}
+	if h.opts.Next != nil {
+		clone.opts.Next = h.opts.Next.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+// WithGroup returns a handler that qualifies subsequent attributes with the group name
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups = append(append([]string(nil), h.groups...), name)
+	if h.opts.Next != nil {
+		clone.opts.Next = h.opts.Next.WithGroup(name)
+	}
+	return &clone
+}
+
+// flattenAttr stores an attribute under its dotted key, expanding groups
+func flattenAttr(values map[string]slog.Value, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	key := attr.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	} else if key == "" {
+		key = prefix
+	}
+
+	if value.Kind() == slog.KindGroup {
+		for _, member := range value.Group() {
+			flattenAttr(values, key, member)
+		}
+		return
+	}
+	if key != "" {
+		values[key] = value
+	}
+}
+
+// entryFromSlog builds an audit entry from a record's flattened attributes
+func entryFromSlog(record slog.Record, values map[string]slog.Value) AuditEntry {
+	str := func(key string) string {
+		if v, ok := values[key]; ok {
+			return v.String()
+		}
+		return ""
+	}
+
+	builder := NewAuditBuilder().
+		Action(AuditAction(str(SlogKeyAction))).
+		ActorWithSession(str(SlogKeyActorID), ActorType(str(SlogKeyActorType)), str(SlogKeyActorName), str(SlogKeySessionID)).
+		Resource(str(SlogKeyResourceType), str(SlogKeyResourceID), str(SlogKeyResourceName)).
+		IPAddress(str(SlogKeyIPAddress)).
+		UserAgent(str(SlogKeyUserAgent)).
+		Correlation(str(SlogKeyCorrelationID)).
+		Success(true)
+
+	if !record.Time.IsZero() {
+		builder.Timestamp(record.Time.UTC())
+	}
+	if v, ok := values[SlogKeySuccess]; ok && v.Kind() == slog.KindBool {
+		builder.Success(v.Bool())
+	}
+	if v, ok := values[SlogKeyError]; ok {
+		if err, isErr := v.Any().(error); isErr {
+			builder.Error(err)
+		} else if msg := v.String(); msg != "" {
+			builder.Error(fmt.Errorf("%s", msg))
+		}
+	}
+	if v, ok := values[SlogKeyChanges]; ok {
+		switch changes := v.Any().(type) {
+		case []FieldChange:
+			for _, change := range changes {
+				builder.AddChange(change.Field, change.OldValue, change.NewValue)
+			}
+		case FieldChange:
+			builder.AddChange(changes.Field, changes.OldValue, changes.NewValue)
+		}
+	}
+
+	if record.Message != "" {
+		builder.Metadata("message", record.Message)
+	}
+	for key, value := range values {
+		if !strings.HasPrefix(key, slogAuditPrefix) {
+			builder.Metadata(key, value.Any())
+		}
+	}
+
+	return builder.Build()
+}
+
+// mirrorEntry writes an audit entry to a logger using the designated keys
+func mirrorEntry(ctx context.Context, logger *slog.Logger, level slog.Level, entry AuditEntry, writeErr error) {
+	ctx = context.WithValue(ctx, slogMirrorKey{}, true)
+
+	attrs := []slog.Attr{
+		slog.String(SlogKeyAction, string(entry.Action)),
+		slog.String(SlogKeyActorID, entry.Actor.ID),
+		slog.String(SlogKeyActorType, string(entry.Actor.Type)),
+		slog.String(SlogKeyResourceType, entry.Resource.Type),
+		slog.String(SlogKeyResourceID, entry.Resource.ID),
+		slog.Bool(SlogKeySuccess, entry.Success),
+		slog.Time("audit.timestamp", entry.Timestamp),
+	}
+	if !entry.ID.IsZero() {
+		attrs = append(attrs, slog.String("audit.id", entry.ID.Hex()))
+	}
+	if entry.Actor.SessionID != "" {
+		attrs = append(attrs, slog.String(SlogKeySessionID, entry.Actor.SessionID))
+	}
+	if entry.ErrorMsg != "" {
+		attrs = append(attrs, slog.String(SlogKeyError, entry.ErrorMsg))
+	}
+	if len(entry.Changes) > 0 {
+		attrs = append(attrs, slog.Any(SlogKeyChanges, entry.Changes))
+	}
+	if entry.CorrelationID != "" {
+		attrs = append(attrs, slog.String(SlogKeyCorrelationID, entry.CorrelationID))
+	}
+	if writeErr != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("audit.write_error", writeErr.Error()))
+	}
+
+	logger.LogAttrs(ctx, level, "audit entry", attrs...)
+}
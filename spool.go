@@ -0,0 +1,729 @@
+package audit
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SpoolFullPolicy defines what happens when the spool reaches SpoolMaxBytes
+type SpoolFullPolicy string
+
+const (
+	SpoolFullReject     SpoolFullPolicy = "reject"      // new entries are rejected with ErrSpoolFull
+	SpoolFullDropOldest SpoolFullPolicy = "drop_oldest" // the oldest segments are discarded to make room
+)
+
+const (
+	spoolSegmentPrefix  = "segment-"
+	spoolSegmentSuffix  = ".log"
+	spoolCheckpointFile = "checkpoint.json"
+	spoolRejectedFile   = "rejected.log"
+
+	// spoolHeaderSize is the size of the record header: payload length and CRC32-C
+	spoolHeaderSize = 8
+
+	// spoolMaxRecordSize guards against corrupted length fields; BSON
+	// documents are limited to 16MiB
+	spoolMaxRecordSize = 16 << 20
+
+	// spoolCheckpointEvery bounds how many replayed records may be replayed
+	// again after a crash; duplicates are absorbed by the unique entry ID
+	spoolCheckpointEvery = 100
+)
+
+var spoolCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Spool is a durable write-ahead spool of audit entries on local disk. Entries
+// are appended to checksummed segment files and fsynced before Append
+// returns; Replay forwards them in order to a repository once it is
+// available again. A spool directory must only be used by one process.
+type Spool struct {
+	mu     sync.Mutex
+	dir    string
+	config *Config
+
+	segments   []*spoolSegment // ordered oldest first; the last one is active
+	active     *os.File
+	totalBytes int64
+
+	// Replay position
+	readSeq    uint64
+	readOffset int64
+	readFile   *os.File
+	sinceSave  int
+
+	dropped   int64 // entries discarded by SpoolFullDropOldest
+	rejected  int64 // entries moved to the rejected file during replay
+	corrupted int64 // segments whose tail failed checksum verification
+	closed    bool
+}
+
+// spoolSegment describes one segment file
+type spoolSegment struct {
+	seq     uint64
+	size    int64 // bytes of valid records
+	entries int64 // number of valid records
+}
+
+// spoolCheckpoint is the persisted replay position
+type spoolCheckpoint struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// ErrSpoolFull represents an attempt to append to a spool that reached its size limit
+type ErrSpoolFull struct{}
+
+func (e ErrSpoolFull) Error() string {
+	return "audit spool is full"
+}
+
+// OpenSpool opens the spool in config.SpoolDir, creating it if needed. Torn
+// records at the end of the last segment, left by a crash during a write,
+// are truncated.
+func OpenSpool(config *Config) (*Spool, error) {
+	if config.SpoolDir == "" {
+		return nil, ErrInvalidConfig{Field: "SpoolDir", Message: "cannot be empty"}
+	}
+	if err := config.validateSpool(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.SpoolDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:    config.SpoolDir,
+		config: config,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load scans existing segments and restores the replay checkpoint
+func (s *Spool) load() error {
+	names, err := filepath.Glob(filepath.Join(s.dir, spoolSegmentPrefix+"*"+spoolSegmentSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list spool segments: %w", err)
+	}
+
+	for _, name := range names {
+		seqStr := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), spoolSegmentPrefix), spoolSegmentSuffix)
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &spoolSegment{seq: seq})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	for _, seg := range s.segments {
+		if err := s.scanSegment(seg); err != nil {
+			return err
+		}
+		s.totalBytes += seg.size
+	}
+
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	} else {
+		last := s.segments[len(s.segments)-1]
+		f, err := os.OpenFile(s.segmentPath(last.seq), os.O_RDWR, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open spool segment: %w", err)
+		}
+		// Drop a torn tail so new records are appended after valid data
+		if err := f.Truncate(last.size); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to truncate spool segment: %w", err)
+		}
+		if _, err := f.Seek(last.size, io.SeekStart); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to seek spool segment: %w", err)
+		}
+		s.active = f
+	}
+
+	if err := s.loadRejected(); err != nil {
+		return err
+	}
+	return s.loadCheckpoint()
+}
+
+// loadRejected counts the entries of the rejected file and drops its torn
+// tail, if any
+func (s *Spool) loadRejected() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, spoolRejectedFile), os.O_RDWR, 0o600)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open rejected spool entries: %w", err)
+	}
+	defer f.Close()
+
+	var offset int64
+	for {
+		_, n, err := readSpoolRecord(f, offset)
+		if err != nil {
+			break
+		}
+		offset += n
+		s.rejected++
+	}
+	if err := f.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate rejected spool entries: %w", err)
+	}
+	return nil
+}
+
+// scanSegment counts the valid records of a segment, stopping at the first
+// record that is truncated or fails its checksum
+func (s *Spool) scanSegment(seg *spoolSegment) error {
+	f, err := os.Open(s.segmentPath(seg.seq))
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat spool segment: %w", err)
+	}
+
+	var offset int64
+	for {
+		_, n, err := readSpoolRecord(f, offset)
+		if err != nil {
+			break
+		}
+		offset += n
+		seg.entries++
+	}
+
+	if offset < info.Size() {
+		s.corrupted++
+	}
+	seg.size = offset
+	return nil
+}
+
+// loadCheckpoint restores the replay position, defaulting to the oldest segment
+func (s *Spool) loadCheckpoint() error {
+	s.readSeq = s.segments[0].seq
+	s.readOffset = 0
+
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read spool checkpoint: %w", err)
+	}
+
+	var cp spoolCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		// A damaged checkpoint only causes duplicates, which replay absorbs
+		return nil
+	}
+
+	if seg := s.segment(cp.Segment); seg != nil && cp.Offset <= seg.size {
+		s.readSeq = cp.Segment
+		s.readOffset = cp.Offset
+	}
+
+	// Segments replayed before a crash may not have been removed yet
+	for len(s.segments) > 1 && s.segments[0].seq < s.readSeq {
+		if err := s.removeSegment(s.segments[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append durably appends an entry to the spool. An ID and timestamp are
+// assigned if missing so that replay can deduplicate on the entry ID.
+func (s *Spool) Append(entry AuditEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	payload, err := bson.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode spooled entry: %w", err)
+	}
+
+	record := encodeSpoolRecord(payload)
+	size := int64(len(record))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("audit spool is closed")
+	}
+
+	if err := s.makeRoom(size); err != nil {
+		return err
+	}
+
+	active := s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+size > s.config.SpoolSegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		active = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		return s.discardTail(active, fmt.Errorf("failed to write spooled entry: %w", err))
+	}
+	if err := s.active.Sync(); err != nil {
+		// The entry is reported as failed, so it must not be replayed
+		return s.discardTail(active, fmt.Errorf("failed to sync spooled entry: %w", err))
+	}
+
+	active.size += size
+	active.entries++
+	s.totalBytes += size
+	return nil
+}
+
+// discardTail removes a partially written or unsynced record from the active
+// segment and returns cause. If the segment cannot be rolled back, a new one
+// is started so that later records do not follow the discarded bytes.
+func (s *Spool) discardTail(active *spoolSegment, cause error) error {
+	err := s.active.Truncate(active.size)
+	if err == nil {
+		_, err = s.active.Seek(active.size, io.SeekStart)
+	}
+	if err == nil {
+		return cause
+	}
+
+	err = fmt.Errorf("failed to discard spooled entry: %w", err)
+	if rotateErr := s.rotate(); rotateErr != nil {
+		return errors.Join(cause, err, rotateErr)
+	}
+	return errors.Join(cause, err)
+}
+
+// makeRoom applies the full policy when appending size bytes would exceed SpoolMaxBytes
+func (s *Spool) makeRoom(size int64) error {
+	if s.totalBytes+size <= s.config.SpoolMaxBytes {
+		return nil
+	}
+	if s.config.SpoolFullPolicy != SpoolFullDropOldest {
+		return ErrSpoolFull{}
+	}
+
+	for s.totalBytes+size > s.config.SpoolMaxBytes && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		if oldest.seq == s.readSeq {
+			s.advanceRead()
+		}
+		s.dropped += oldest.entries
+		if err := s.removeSegment(oldest); err != nil {
+			return err
+		}
+	}
+
+	if s.totalBytes+size > s.config.SpoolMaxBytes {
+		return ErrSpoolFull{}
+	}
+	return nil
+}
+
+// rotate seals the active segment and starts a new one
+func (s *Spool) rotate() error {
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	if s.active != nil {
+		s.active.Close()
+	}
+	s.active = f
+	s.segments = append(s.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+// Replay forwards spooled entries in order to the repository and returns how
+// many were delivered. Entries the repository already holds are treated as
+// delivered, so an entry is stored exactly once by its ID even if the spool
+// is replayed again after a crash. Replay stops at the first transient
+// insert error (see NewSpoolRepository). Entries the repository rejects for
+// good, or that cannot be decoded, are moved to the rejected file so they do
+// not block the entries behind them; see RejectedEntries.
+func (s *Spool) Replay(ctx context.Context, repo AuditRepository) (int, error) {
+	replayed := 0
+	defer func() {
+		s.mu.Lock()
+		s.saveCheckpoint()
+		s.mu.Unlock()
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		payload, pos, next, ok, err := s.next()
+		if err != nil || !ok {
+			return replayed, err
+		}
+
+		var entry AuditEntry
+		insertErr := bson.Unmarshal(payload, &entry)
+		if insertErr == nil {
+			insertErr = repo.Insert(ctx, entry)
+			if IsDuplicateEntry(insertErr) {
+				insertErr = nil
+			}
+			if insertErr != nil && (ctx.Err() != nil || spoolable(insertErr)) {
+				return replayed, fmt.Errorf("failed to replay spooled entry: %w", insertErr)
+			}
+		}
+
+		s.mu.Lock()
+		// The position moves only if no append dropped the segment meanwhile
+		if s.readSeq == pos.seq && s.readOffset == pos.offset {
+			if insertErr != nil {
+				if err := s.reject(payload); err != nil {
+					s.mu.Unlock()
+					return replayed, err
+				}
+			}
+			s.readOffset = next
+			s.sinceSave++
+			if s.sinceSave >= spoolCheckpointEvery {
+				s.saveCheckpoint()
+			}
+		}
+		s.mu.Unlock()
+
+		if insertErr == nil {
+			replayed++
+		}
+	}
+}
+
+// reject durably appends a record payload to the rejected file
+func (s *Spool) reject(payload []byte) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, spoolRejectedFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open rejected spool entries: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(encodeSpoolRecord(payload)); err != nil {
+		return fmt.Errorf("failed to write rejected spool entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync rejected spool entry: %w", err)
+	}
+	s.rejected++
+	return nil
+}
+
+// RejectedEntries returns the entries the repository rejected during replay,
+// oldest first. Records that cannot be decoded are skipped.
+func (s *Spool) RejectedEntries() ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(s.dir, spoolRejectedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rejected spool entries: %w", err)
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	var offset int64
+	for {
+		payload, n, err := readSpoolRecord(f, offset)
+		if err != nil {
+			return entries, nil
+		}
+		offset += n
+
+		var entry AuditEntry
+		if err := bson.Unmarshal(payload, &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// spoolPosition is a replay position
+type spoolPosition struct {
+	seq    uint64
+	offset int64
+}
+
+// next reads the payload of the record at the replay position, moving past
+// exhausted segments. It returns the position of the record and the offset
+// following it.
+func (s *Spool) next() ([]byte, spoolPosition, int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, spoolPosition{}, 0, false, fmt.Errorf("audit spool is closed")
+	}
+
+	for {
+		seg := s.segment(s.readSeq)
+		if seg == nil {
+			return nil, spoolPosition{}, 0, false, nil
+		}
+
+		if s.readOffset < seg.size {
+			if s.readFile == nil {
+				f, err := os.Open(s.segmentPath(seg.seq))
+				if err != nil {
+					return nil, spoolPosition{}, 0, false, fmt.Errorf("failed to open spool segment: %w", err)
+				}
+				s.readFile = f
+			}
+
+			payload, n, err := readSpoolRecord(s.readFile, s.readOffset)
+			if err != nil {
+				return nil, spoolPosition{}, 0, false, fmt.Errorf("failed to read spooled entry: %w", err)
+			}
+			return payload, spoolPosition{seq: s.readSeq, offset: s.readOffset}, s.readOffset + n, true, nil
+		}
+
+		// The active segment is exhausted: nothing left to replay
+		if seg == s.segments[len(s.segments)-1] {
+			return nil, spoolPosition{}, 0, false, nil
+		}
+
+		// A sealed segment was fully replayed and can be removed
+		s.advanceRead()
+		s.saveCheckpoint()
+		if err := s.removeSegment(seg); err != nil {
+			return nil, spoolPosition{}, 0, false, err
+		}
+	}
+}
+
+// advanceRead moves the replay position to the start of the following segment
+func (s *Spool) advanceRead() {
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile = nil
+	}
+	for i, seg := range s.segments {
+		if seg.seq == s.readSeq && i+1 < len(s.segments) {
+			s.readSeq = s.segments[i+1].seq
+			s.readOffset = 0
+			return
+		}
+	}
+}
+
+// removeSegment deletes a sealed segment file
+func (s *Spool) removeSegment(seg *spoolSegment) error {
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	for i, candidate := range s.segments {
+		if candidate == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	s.totalBytes -= seg.size
+	return nil
+}
+
+// saveCheckpoint atomically persists the replay position
+func (s *Spool) saveCheckpoint() error {
+	s.sinceSave = 0
+
+	data, err := json.Marshal(spoolCheckpoint{Segment: s.readSeq, Offset: s.readOffset})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, spoolCheckpointFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write spool checkpoint: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write spool checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync spool checkpoint: %w", err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, spoolCheckpointFile)); err != nil {
+		return fmt.Errorf("failed to write spool checkpoint: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+// Pending reports whether the spool holds entries that have not been replayed
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seg := range s.segments {
+		if seg.seq > s.readSeq && seg.size > 0 {
+			return true
+		}
+		if seg.seq == s.readSeq && s.readOffset < seg.size {
+			return true
+		}
+	}
+	return false
+}
+
+// SpoolStats represents counters describing the state of a spool
+type SpoolStats struct {
+	Segments  int   `json:"segments"`
+	Bytes     int64 `json:"bytes"`
+	Dropped   int64 `json:"dropped"`   // entries discarded by SpoolFullDropOldest
+	Rejected  int64 `json:"rejected"`  // entries the repository rejected during replay
+	Corrupted int64 `json:"corrupted"` // segments with a damaged tail found on open
+}
+
+// Stats returns the current spool counters
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SpoolStats{
+		Segments:  len(s.segments),
+		Bytes:     s.totalBytes,
+		Dropped:   s.dropped,
+		Rejected:  s.rejected,
+		Corrupted: s.corrupted,
+	}
+}
+
+// Close persists the replay position and closes the segment files
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.saveCheckpoint()
+	if s.readFile != nil {
+		s.readFile.Close()
+	}
+	if s.active != nil {
+		if closeErr := s.active.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// segment returns the segment with the given sequence number
+func (s *Spool) segment(seq uint64) *spoolSegment {
+	for _, seg := range s.segments {
+		if seg.seq == seq {
+			return seg
+		}
+	}
+	return nil
+}
+
+// segmentPath returns the file path of a segment
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, seq, spoolSegmentSuffix))
+}
+
+// encodeSpoolRecord frames a payload with its length and checksum
+func encodeSpoolRecord(payload []byte) []byte {
+	record := make([]byte, spoolHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, spoolCRCTable))
+	copy(record[spoolHeaderSize:], payload)
+	return record
+}
+
+// readSpoolRecord reads and verifies the record at offset, returning its
+// payload and total size
+func readSpoolRecord(r io.ReaderAt, offset int64) ([]byte, int64, error) {
+	var header [spoolHeaderSize]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > spoolMaxRecordSize {
+		return nil, 0, fmt.Errorf("invalid record length %d at offset %d", length, offset)
+	}
+
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+spoolHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, spoolCRCTable) != checksum {
+		return nil, 0, fmt.Errorf("checksum mismatch at offset %d", offset)
+	}
+
+	return payload, spoolHeaderSize + int64(length), nil
+}
+
+// syncDir fsyncs a directory so that created, renamed and removed files are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
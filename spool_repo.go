@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// spoolRepository wraps an AuditRepository and spools entries to local disk
+// while the wrapped repository rejects writes
+type spoolRepository struct {
+	repo     AuditRepository
+	spool    *Spool
+	interval time.Duration
+
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSpoolRepository wraps a repository with a durable spool. Inserts that
+// fail with a transient error (see IsRetryableError) or ErrCircuitOpen are
+// appended to the spool instead and replayed in order by a background loop
+// once the repository accepts writes again. Other errors are returned as is,
+// so that an entry the repository will never accept does not block replay. While spooled
+// entries are pending, new entries are spooled as well to preserve ordering.
+// Reads are served by the wrapped repository only. Closing the returned
+// repository closes both the spool and the wrapped repository.
+func NewSpoolRepository(repo AuditRepository, spool *Spool) AuditRepository {
+	r := &spoolRepository{
+		repo:     repo,
+		spool:    spool,
+		interval: spool.config.SpoolReplayInterval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go r.replayLoop()
+	return r
+}
+
+// WithSpool wraps the service's repository with a durable spool
+func WithSpool(spool *Spool) ServiceOption {
+	return func(s *auditService) {
+		s.repo = NewSpoolRepository(s.repo, spool)
+	}
+}
+
+// Insert inserts a new audit entry, spooling it if the repository is unavailable
+func (r *spoolRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	// The ID must be fixed before the first attempt so that an insert which
+	// succeeded despite returning an error is deduplicated on replay
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	if !r.spool.Pending() {
+		err := r.repo.Insert(ctx, entry)
+		if err == nil || !spoolable(err) {
+			return err
+		}
+	}
+
+	if err := r.spool.Append(entry); err != nil {
+		return fmt.Errorf("failed to spool audit entry: %w", err)
+	}
+
+	r.trigger()
+	return nil
+}
+
+// spoolable reports whether a failed insert may succeed on replay
+func spoolable(err error) bool {
+	var open ErrCircuitOpen
+	return IsRetryableError(err) || errors.As(err, &open)
+}
+
+// trigger wakes the replay loop without blocking
+func (r *spoolRepository) trigger() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replayLoop periodically replays pending entries into the repository
+func (r *spoolRepository) replayLoop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		case <-r.wake:
+			// Give the backend a moment before retrying right after a failure
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(r.interval):
+			}
+		}
+
+		r.replay()
+	}
+}
+
+// replay drains the spool until it is empty or the repository fails
+func (r *spoolRepository) replay() {
+	if !r.spool.Pending() {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	r.spool.Replay(ctx, r.repo)
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *spoolRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	return r.repo.FindByQuery(ctx, query)
+}
+
+// FindByID finds an audit entry by its ID
+func (r *spoolRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	return r.repo.FindByID(ctx, id)
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *spoolRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	return r.repo.FindByResource(ctx, resourceType, resourceID, limit)
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *spoolRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	return r.repo.FindByActor(ctx, actorID, actorType, limit)
+}
+
+// EnsureIndexes creates necessary database indexes
+func (r *spoolRepository) EnsureIndexes(ctx context.Context) error {
+	return r.repo.EnsureIndexes(ctx)
+}
+
+// Unwrap returns the wrapped repository
+func (r *spoolRepository) Unwrap() AuditRepository {
+	return r.repo
+}
+
+// Close stops the replay loop and closes the spool and the wrapped
+// repository. Later calls return the result of the first one.
+func (r *spoolRepository) Close(ctx context.Context) error {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+
+		spoolErr := r.spool.Close()
+		if err := r.repo.Close(ctx); err != nil {
+			r.closeErr = err
+			return
+		}
+		r.closeErr = spoolErr
+	})
+	return r.closeErr
+}
@@ -0,0 +1,303 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// hookRepository is a memoryRepository that calls before ahead of each
+// insert and fails the insert with its error
+type hookRepository struct {
+	*memoryRepository
+	before func(entry AuditEntry) error
+}
+
+func (r *hookRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	if r.before != nil {
+		if err := r.before(entry); err != nil {
+			return err
+		}
+	}
+	return r.memoryRepository.Insert(ctx, entry)
+}
+
+// testSpoolConfig returns a spool configuration whose segments each hold one
+// test entry and whose size limit is the given number of entries
+func testSpoolConfig(t *testing.T, entries int64) *Config {
+	t.Helper()
+
+	payload, err := bson.Marshal(testEntry("doc1"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	record := int64(spoolHeaderSize + len(payload))
+
+	config := DefaultConfig()
+	config.SpoolDir = t.TempDir()
+	config.SpoolSegmentBytes = record
+	config.SpoolMaxBytes = entries * record
+	config.SpoolReplayInterval = time.Hour
+	return config
+}
+
+// openTestSpool opens a spool that is closed when the test ends
+func openTestSpool(t *testing.T, config *Config) *Spool {
+	t.Helper()
+
+	spool, err := OpenSpool(config)
+	if err != nil {
+		t.Fatalf("OpenSpool failed: %v", err)
+	}
+	t.Cleanup(func() { spool.Close() })
+	return spool
+}
+
+// appendEntries appends entries to a spool
+func appendEntries(t *testing.T, spool *Spool, entries ...AuditEntry) {
+	t.Helper()
+	for _, entry := range entries {
+		if err := spool.Append(entry); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+}
+
+// assertStored checks that a repository holds exactly the given entries, in order
+func assertStored(t *testing.T, repo *memoryRepository, want ...AuditEntry) {
+	t.Helper()
+	stored := repo.stored()
+	if len(stored) != len(want) {
+		t.Fatalf("stored %d entries, want %d", len(stored), len(want))
+	}
+	for i := range want {
+		if stored[i].ID != want[i].ID {
+			t.Errorf("entry %d is %s, want %s", i, stored[i].ID.Hex(), want[i].ID.Hex())
+		}
+	}
+}
+
+func TestSpoolTruncatesTornTail(t *testing.T) {
+	ctx := context.Background()
+	config := testSpoolConfig(t, 10)
+	config.SpoolSegmentBytes = config.SpoolMaxBytes
+
+	spool, err := OpenSpool(config)
+	if err != nil {
+		t.Fatalf("OpenSpool failed: %v", err)
+	}
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3"), testEntry("doc4")}
+	appendEntries(t, spool, entries[:3]...)
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A crash in the middle of the last write leaves part of a record
+	path := spool.segmentPath(1)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	spool = openTestSpool(t, config)
+	if stats := spool.Stats(); stats.Corrupted != 1 {
+		t.Errorf("stats %+v, want 1 corrupted segment", stats)
+	}
+
+	// New records follow the last valid one
+	appendEntries(t, spool, entries[3])
+	repo := &memoryRepository{}
+	if n, err := spool.Replay(ctx, repo); err != nil || n != 3 {
+		t.Fatalf("Replay = %d, %v; want 3", n, err)
+	}
+	assertStored(t, repo, entries[0], entries[1], entries[3])
+}
+
+func TestSpoolResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	config := testSpoolConfig(t, 10)
+	spool, err := OpenSpool(config)
+	if err != nil {
+		t.Fatalf("OpenSpool failed: %v", err)
+	}
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	appendEntries(t, spool, entries...)
+
+	// The backend goes away again before the last entry
+	repo := &hookRepository{memoryRepository: &memoryRepository{}, before: func(entry AuditEntry) error {
+		if entry.ID == entries[2].ID {
+			return ErrCircuitOpen{}
+		}
+		return nil
+	}}
+	if n, err := spool.Replay(ctx, repo); err == nil || n != 2 {
+		t.Fatalf("Replay = %d, %v; want 2 and an error", n, err)
+	}
+	if !spool.Pending() {
+		t.Fatal("spool has no pending entries after a failed replay")
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopening resumes after the delivered entries
+	spool = openTestSpool(t, config)
+	resumed := &memoryRepository{}
+	if n, err := spool.Replay(ctx, resumed); err != nil || n != 1 {
+		t.Fatalf("Replay after reopen = %d, %v; want 1", n, err)
+	}
+	assertStored(t, resumed, entries[2])
+	if spool.Pending() {
+		t.Error("spool still pending after replaying everything")
+	}
+	if stats := spool.Stats(); stats.Segments != 1 {
+		t.Errorf("stats %+v, want replayed segments removed", stats)
+	}
+}
+
+func TestSpoolReplayKeepsPositionWhenSegmentDropped(t *testing.T) {
+	ctx := context.Background()
+	config := testSpoolConfig(t, 2)
+	config.SpoolFullPolicy = SpoolFullDropOldest
+	spool := openTestSpool(t, config)
+
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	appendEntries(t, spool, entries[:2]...)
+
+	// While the first entry is being replayed, an append drops its segment
+	// and the replay position moves to the start of the next one
+	repo := &hookRepository{memoryRepository: &memoryRepository{}}
+	repo.before = func(entry AuditEntry) error {
+		if entry.ID == entries[0].ID {
+			appendEntries(t, spool, entries[2])
+		}
+		return nil
+	}
+
+	if n, err := spool.Replay(ctx, repo); err != nil || n != 3 {
+		t.Fatalf("Replay = %d, %v; want 3", n, err)
+	}
+	assertStored(t, repo.memoryRepository, entries...)
+	if stats := spool.Stats(); stats.Dropped != 1 {
+		t.Errorf("stats %+v, want 1 dropped entry", stats)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	ctx := context.Background()
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+
+	t.Run("reject", func(t *testing.T) {
+		spool := openTestSpool(t, testSpoolConfig(t, 2))
+		appendEntries(t, spool, entries[:2]...)
+
+		var full ErrSpoolFull
+		if err := spool.Append(entries[2]); !errors.As(err, &full) {
+			t.Fatalf("Append = %v, want ErrSpoolFull", err)
+		}
+		repo := &memoryRepository{}
+		if _, err := spool.Replay(ctx, repo); err != nil {
+			t.Fatalf("Replay failed: %v", err)
+		}
+		assertStored(t, repo, entries[:2]...)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		config := testSpoolConfig(t, 2)
+		config.SpoolFullPolicy = SpoolFullDropOldest
+		spool := openTestSpool(t, config)
+		appendEntries(t, spool, entries...)
+
+		if stats := spool.Stats(); stats.Dropped != 1 || stats.Bytes != config.SpoolMaxBytes {
+			t.Errorf("stats %+v, want 1 dropped entry and a full spool", stats)
+		}
+		repo := &memoryRepository{}
+		if _, err := spool.Replay(ctx, repo); err != nil {
+			t.Fatalf("Replay failed: %v", err)
+		}
+		assertStored(t, repo, entries[1:]...)
+	})
+}
+
+func TestSpoolReplayMovesRejectedEntries(t *testing.T) {
+	ctx := context.Background()
+	config := testSpoolConfig(t, 10)
+	spool, err := OpenSpool(config)
+	if err != nil {
+		t.Fatalf("OpenSpool failed: %v", err)
+	}
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	appendEntries(t, spool, entries...)
+
+	// The backend never accepts the second entry
+	repo := &hookRepository{memoryRepository: &memoryRepository{}, before: func(entry AuditEntry) error {
+		if entry.ID == entries[1].ID {
+			return ErrInvalidEntry{Message: "document too large"}
+		}
+		return nil
+	}}
+	if n, err := spool.Replay(ctx, repo); err != nil || n != 2 {
+		t.Fatalf("Replay = %d, %v; want 2", n, err)
+	}
+	assertStored(t, repo.memoryRepository, entries[0], entries[2])
+	if spool.Pending() {
+		t.Error("a rejected entry keeps the spool pending")
+	}
+
+	rejected, err := spool.RejectedEntries()
+	if err != nil || len(rejected) != 1 || rejected[0].ID != entries[1].ID {
+		t.Fatalf("RejectedEntries = %+v, %v; want the second entry", rejected, err)
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	spool = openTestSpool(t, config)
+	if stats := spool.Stats(); stats.Rejected != 1 {
+		t.Errorf("stats after reopen %+v, want 1 rejected entry", stats)
+	}
+}
+
+func TestSpoolRepositorySpoolsTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	spool := openTestSpool(t, testSpoolConfig(t, 10))
+
+	var failure error
+	backend := &hookRepository{memoryRepository: &memoryRepository{}, before: func(AuditEntry) error {
+		return failure
+	}}
+	repo := NewSpoolRepository(backend, spool)
+	defer repo.Close(ctx)
+
+	// Errors that replay cannot fix are returned at once
+	failure = ErrInvalidEntry{Message: "invalid"}
+	if err := repo.Insert(ctx, testEntry("doc1")); err == nil {
+		t.Fatal("expected the insert error to be returned")
+	}
+	if spool.Pending() {
+		t.Fatal("a rejected entry was spooled")
+	}
+
+	// While the circuit is open entries are spooled, and so are the entries
+	// after them until the spool is replayed
+	entries := []AuditEntry{testEntry("doc2"), testEntry("doc3")}
+	failure = ErrCircuitOpen{}
+	insertEntries(t, repo, entries[0])
+	failure = nil
+	insertEntries(t, repo, entries[1])
+	if stored := backend.stored(); len(stored) != 0 {
+		t.Fatalf("stored %d entries ahead of the spooled one", len(stored))
+	}
+
+	if n, err := spool.Replay(ctx, backend); err != nil || n != 2 {
+		t.Fatalf("Replay = %d, %v; want 2", n, err)
+	}
+	assertStored(t, backend.memoryRepository, entries...)
+}
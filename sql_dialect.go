@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SQLDialect identifies the SQL flavour of a database/sql backed store
+type SQLDialect string
+
+const (
+	DialectPostgres SQLDialect = "postgres"
+	DialectSQLite   SQLDialect = "sqlite"
+)
+
+// validate checks that the dialect is supported
+func (d SQLDialect) validate() error {
+	switch d {
+	case DialectPostgres, DialectSQLite:
+		return nil
+	default:
+		return fmt.Errorf("unsupported SQL dialect: %q", d)
+	}
+}
+
+// placeholder returns the bind parameter for the n-th argument, starting at 1
+func (d SQLDialect) placeholder(n int) string {
+	if d == DialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// placeholders returns a comma-separated list of count bind parameters
+// starting at argument start
+func (d SQLDialect) placeholders(start, count int) string {
+	params := make([]string, count)
+	for i := range params {
+		params[i] = d.placeholder(start + i)
+	}
+	return strings.Join(params, ", ")
+}
+
+// jsonType returns the column type used for JSON documents
+func (d SQLDialect) jsonType() string {
+	if d == DialectPostgres {
+		return "JSONB"
+	}
+	return "TEXT"
+}
+
+// timeType returns the column type used for timestamps
+func (d SQLDialect) timeType() string {
+	if d == DialectPostgres {
+		return "TIMESTAMPTZ"
+	}
+	// SQLite has no time type; fixed-width UTC text sorts chronologically
+	return "TEXT"
+}
+
+// sqlTimeLayout is the text form of timestamps in SQLite. Its fixed width
+// keeps lexical and chronological order the same.
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// timeValue converts a timestamp into a bind parameter
+func (d SQLDialect) timeValue(t time.Time) any {
+	if d == DialectPostgres {
+		return t.UTC()
+	}
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+// sqlTime scans a timestamp stored by either dialect
+type sqlTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements sql.Scanner
+func (t *sqlTime) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time = v
+	case string:
+		t.Time, err = time.Parse(time.RFC3339Nano, v)
+	case []byte:
+		t.Time, err = time.Parse(time.RFC3339Nano, string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.Time, t.Valid = t.Time.UTC(), true
+	return nil
+}
+
+// sqlIdentifier matches table names, optionally qualified by a schema
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validateSQLIdentifier checks that a table name can be used unquoted
+func validateSQLIdentifier(name string) error {
+	if !sqlIdentifier.MatchString(name) {
+		return fmt.Errorf("invalid SQL table name: %q", name)
+	}
+	return nil
+}
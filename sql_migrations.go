@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlMigration is a versioned change to the schema of the SQL repository
+type sqlMigration struct {
+	version    int
+	statements func(d SQLDialect, table string) []string
+}
+
+// sqlMigrations lists the schema changes in the order they are applied.
+// Released migrations must never be edited; add a new version instead.
+var sqlMigrations = []sqlMigration{
+	{version: 1, statements: sqlMigrationInitial},
+	{version: 2, statements: sqlMigrationTenants},
+	{version: 3, statements: sqlMigrationSampling},
+	{version: 4, statements: sqlMigrationCoalescing},
+}
+
+// sqlMigrationInitial creates the entries table and the indexes equivalent to
+// those of the MongoDB repository
+func sqlMigrationInitial(d SQLDialect, table string) []string {
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	timestamp %s NOT NULL,
+	action TEXT NOT NULL,
+	actor_id TEXT NOT NULL,
+	actor_type TEXT NOT NULL,
+	actor_name TEXT NOT NULL DEFAULT '',
+	actor_session_id TEXT NOT NULL DEFAULT '',
+	resource_type TEXT NOT NULL,
+	resource_id TEXT NOT NULL,
+	resource_name TEXT NOT NULL DEFAULT '',
+	changes %s,
+	metadata %s,
+	ip_address TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	success BOOLEAN NOT NULL,
+	error_msg TEXT NOT NULL DEFAULT '',
+	correlation_id TEXT NOT NULL DEFAULT '',
+	trace_id TEXT NOT NULL DEFAULT '',
+	span_id TEXT NOT NULL DEFAULT '',
+	parent_id TEXT
+)`, table, d.timeType(), d.jsonType(), d.jsonType()),
+	}
+
+	indexes := [][]string{
+		{"actor_id", "timestamp"},
+		{"actor_type", "timestamp"},
+		{"actor_session_id", "timestamp"},
+		{"resource_type", "resource_id", "timestamp"},
+		{"action", "timestamp"},
+		{"timestamp"},
+		{"actor_id", "actor_type", "timestamp"},
+		{"correlation_id", "timestamp"},
+		{"trace_id", "timestamp"},
+		{"parent_id"},
+	}
+	for _, columns := range indexes {
+		statements = append(statements, sqlCreateIndex(table, columns...))
+	}
+
+	return statements
+}
+
+// sqlMigrationTenants adds the tenant of entries, indexed for the shared
+// multi-tenant mode
+func sqlMigrationTenants(d SQLDialect, table string) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN tenant_id TEXT NOT NULL DEFAULT ''", table),
+		sqlCreateIndex(table, "tenant_id", "timestamp"),
+	}
+}
+
+// sqlMigrationSampling adds the sampling rate of entries
+func sqlMigrationSampling(d SQLDialect, table string) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN sample_rate DOUBLE PRECISION NOT NULL DEFAULT 0", table),
+	}
+}
+
+// sqlMigrationCoalescing adds the occurrences of coalesced entries
+func sqlMigrationCoalescing(d SQLDialect, table string) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN occurrences INTEGER NOT NULL DEFAULT 0", table),
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN last_occurrence %s", table, d.timeType()),
+	}
+}
+
+// sqlCreateIndex returns the statement creating an index on the given
+// columns. Timestamp columns are indexed in descending order, like the
+// MongoDB indexes.
+func sqlCreateIndex(table string, columns ...string) string {
+	name := strings.ReplaceAll(table, ".", "_") + "_" + strings.Join(columns, "_") + "_idx"
+
+	keys := make([]string, len(columns))
+	for i, column := range columns {
+		keys[i] = column
+		if column == "timestamp" {
+			keys[i] += " DESC"
+		}
+	}
+
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, strings.Join(keys, ", "))
+}
+
+// migrateSQL applies the pending migrations of the entries table. Applied
+// versions are recorded in the <table>_schema_migrations table, and each
+// migration runs in its own transaction.
+func migrateSQL(ctx context.Context, db *sql.DB, d SQLDialect, table string) error {
+	versions := table + "_schema_migrations"
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version INTEGER PRIMARY KEY,
+	applied_at %s NOT NULL
+)`, versions, d.timeType())
+	if _, err := db.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM "+versions).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, migration := range sqlMigrations {
+		if int64(migration.version) <= current.Int64 {
+			continue
+		}
+		if err := applySQLMigration(ctx, db, d, table, versions, migration); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", migration.version, err)
+		}
+	}
+
+	return nil
+}
+
+// applySQLMigration runs a migration and records its version atomically
+func applySQLMigration(ctx context.Context, db *sql.DB, d SQLDialect, table, versions string, migration sqlMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range migration.statements(d, table) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	record := fmt.Sprintf("INSERT INTO %s (version, applied_at) VALUES (%s)", versions, d.placeholders(1, 2))
+	if _, err := tx.ExecContext(ctx, record, migration.version, d.timeValue(time.Now())); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SQLExecutor is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// SQLOutbox is an OutboxStore kept in a table of the caller's SQL database,
+// so that entries are committed in the same transaction as the business
+// change they describe
+type SQLOutbox struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLOutbox creates an outbox on the given table. Call Migrate to create
+// the table if it does not exist.
+func NewSQLOutbox(db *sql.DB, dialect SQLDialect, table string) (*SQLOutbox, error) {
+	if err := dialect.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateSQLIdentifier(table); err != nil {
+		return nil, err
+	}
+
+	return &SQLOutbox{
+		db:      db,
+		dialect: dialect,
+		table:   table,
+	}, nil
+}
+
+// Migrate creates the outbox table and its index if they do not exist
+func (o *SQLOutbox) Migrate(ctx context.Context) error {
+	index := strings.ReplaceAll(o.table, ".", "_") + "_pending_idx"
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	entry %s NOT NULL,
+	created_at %s NOT NULL,
+	delivered_at %s
+)`, o.table, o.dialect.jsonType(), o.dialect.timeType(), o.dialect.timeType()),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (delivered_at, created_at)`, index, o.table),
+	}
+
+	for _, stmt := range statements {
+		if _, err := o.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate outbox table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write adds an entry to the outbox using exec, normally the caller's
+// *sql.Tx. The entry ID and timestamp are set here so that relaying the
+// entry more than once is idempotent.
+func (o *SQLOutbox) Write(ctx context.Context, exec SQLExecutor, entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, entry, created_at) VALUES (%s)",
+		o.table, o.dialect.placeholders(1, 3))
+	if _, err := exec.ExecContext(ctx, query, entry.ID.Hex(), string(data), o.dialect.timeValue(time.Now())); err != nil {
+		return fmt.Errorf("failed to write audit entry to outbox: %w", err)
+	}
+
+	return nil
+}
+
+// Pending returns up to limit undelivered records, oldest first
+func (o *SQLOutbox) Pending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	query := fmt.Sprintf("SELECT id, entry FROM %s WHERE delivered_at IS NULL ORDER BY created_at, id LIMIT %s",
+		o.table, o.dialect.placeholder(1))
+
+	rows, err := o.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find outbox records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []OutboxRecord
+	for rows.Next() {
+		var record OutboxRecord
+		var data []byte
+		if err := rows.Scan(&record.ID, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox record: %w", err)
+		}
+		if err := json.Unmarshal(data, &record.Entry); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox record %s: %w", record.ID, err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox records: %w", err)
+	}
+
+	return records, nil
+}
+
+// MarkDelivered marks records as delivered
+func (o *SQLOutbox) MarkDelivered(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, o.dialect.timeValue(time.Now()))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET delivered_at = %s WHERE id IN (%s)",
+		o.table, o.dialect.placeholder(1), o.dialect.placeholders(2, len(ids)))
+	if _, err := o.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to mark outbox records as delivered: %w", err)
+	}
+
+	return nil
+}
+
+// Purge deletes records delivered before the given time and returns the
+// number of records deleted
+func (o *SQLOutbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE delivered_at IS NOT NULL AND delivered_at < %s",
+		o.table, o.dialect.placeholder(1))
+
+	result, err := o.db.ExecContext(ctx, query, o.dialect.timeValue(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox records: %w", err)
+	}
+
+	return result.RowsAffected()
+}
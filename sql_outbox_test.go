@@ -0,0 +1,208 @@
+//go:build cgo
+
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens a SQLite database in a temporary directory
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQLite: %v", err)
+	}
+	// A single connection avoids "database is locked" between writers
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestSQLOutbox returns a migrated outbox on a fresh SQLite database
+func newTestSQLOutbox(t *testing.T) (*SQLOutbox, *sql.DB) {
+	t.Helper()
+
+	db := openSQLite(t)
+	outbox, err := NewSQLOutbox(db, DialectSQLite, "audit_outbox")
+	if err != nil {
+		t.Fatalf("NewSQLOutbox failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := outbox.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate #%d failed: %v", i+1, err)
+		}
+	}
+	return outbox, db
+}
+
+func TestNewSQLOutboxValidation(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := NewSQLOutbox(db, "oracle", "audit_outbox"); err == nil {
+		t.Error("expected an error for an unsupported dialect")
+	}
+	if _, err := NewSQLOutbox(db, DialectSQLite, "outbox; DROP TABLE users"); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+}
+
+func TestSQLOutboxWriteInTransaction(t *testing.T) {
+	ctx := context.Background()
+	outbox, db := newTestSQLOutbox(t)
+
+	// Entries written in a rolled back transaction are not pending
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if err := outbox.Write(ctx, tx, testEntry("doc1")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if pending, err := outbox.Pending(ctx, 10); err != nil || len(pending) != 0 {
+		t.Fatalf("Pending after rollback = %d, %v; want none", len(pending), err)
+	}
+
+	// Committed entries are pending in write order, with their ID and
+	// timestamp fixed
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	unset := testEntry("doc2")
+	unset.ID = [12]byte{}
+	unset.Timestamp = time.Time{}
+	written := []AuditEntry{testEntry("doc1"), unset}
+	for _, entry := range written {
+		if err := outbox.Write(ctx, tx, entry); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	pending, err := outbox.Pending(ctx, 10)
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("Pending = %d records, want 2", len(pending))
+	}
+	if pending[0].ID != written[0].ID.Hex() || pending[0].Entry.ID != written[0].ID {
+		t.Errorf("first record %s, want %s", pending[0].ID, written[0].ID.Hex())
+	}
+	if pending[0].Entry.Resource.ID != "doc1" || !pending[0].Entry.Timestamp.Equal(written[0].Timestamp) {
+		t.Errorf("first record decoded as %+v", pending[0].Entry)
+	}
+	if pending[1].Entry.ID.IsZero() || pending[1].ID != pending[1].Entry.ID.Hex() || pending[1].Entry.Timestamp.IsZero() {
+		t.Errorf("record without ID or timestamp: %+v", pending[1])
+	}
+
+	if limited, err := outbox.Pending(ctx, 1); err != nil || len(limited) != 1 || limited[0].ID != pending[0].ID {
+		t.Errorf("Pending(1) = %+v, %v", limited, err)
+	}
+
+	// A duplicate ID is rejected by the primary key
+	if err := outbox.Write(ctx, db, written[0]); err == nil {
+		t.Error("expected an error writing a duplicate ID")
+	}
+}
+
+func TestSQLOutboxMarkDeliveredAndPurge(t *testing.T) {
+	ctx := context.Background()
+	outbox, db := newTestSQLOutbox(t)
+
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	for _, entry := range entries {
+		if err := outbox.Write(ctx, db, entry); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	if err := outbox.MarkDelivered(ctx, nil); err != nil {
+		t.Fatalf("MarkDelivered without IDs failed: %v", err)
+	}
+	if err := outbox.MarkDelivered(ctx, []string{entries[0].ID.Hex(), entries[2].ID.Hex()}); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	pending, err := outbox.Pending(ctx, 10)
+	if err != nil || len(pending) != 1 || pending[0].ID != entries[1].ID.Hex() {
+		t.Fatalf("Pending = %+v, %v; want the undelivered record", pending, err)
+	}
+
+	// Only delivered records older than the cutoff are purged
+	if n, err := outbox.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Purge before delivery = %d, %v; want 0", n, err)
+	}
+	if n, err := outbox.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("Purge = %d, %v; want 2", n, err)
+	}
+
+	var rows int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_outbox").Scan(&rows); err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if rows != 1 {
+		t.Errorf("%d rows left after purge, want 1", rows)
+	}
+}
+
+func TestSQLOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	outbox, db := newTestSQLOutbox(t)
+
+	entries := []AuditEntry{testEntry("doc1"), testEntry("doc2"), testEntry("doc3")}
+	for _, entry := range entries {
+		if err := outbox.Write(ctx, db, entry); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	repo := &memoryRepository{}
+	policy := DefaultAuditPolicy()
+	policy.Default = FailOpen
+	service := NewServiceWithRepository(repo, WithPolicy(policy))
+	defer service.Close(ctx)
+
+	relay := NewOutboxRelay(outbox, service, OutboxRelayConfig{BatchSize: 2})
+
+	// A failed write leaves the batch pending
+	repo.failWith(ErrCircuitOpen{})
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("RelayOnce = %d, %v; want an error", n, err)
+	}
+	if pending, _ := outbox.Pending(ctx, 10); len(pending) != 3 {
+		t.Fatalf("%d records pending after a failed relay, want 3", len(pending))
+	}
+
+	repo.failWith(nil)
+	for _, want := range []int{2, 1, 0} {
+		if n, err := relay.RelayOnce(ctx); err != nil || n != want {
+			t.Fatalf("RelayOnce = %d, %v; want %d", n, err, want)
+		}
+	}
+
+	stored := repo.stored()
+	if len(stored) != len(entries) {
+		t.Fatalf("stored %d entries, want %d", len(stored), len(entries))
+	}
+	for i, entry := range stored {
+		if entry.ID != entries[i].ID {
+			t.Errorf("entry %d is %s, want %s", i, entry.ID.Hex(), entries[i].ID.Hex())
+		}
+	}
+	if pending, _ := outbox.Pending(ctx, 10); len(pending) != 0 {
+		t.Errorf("%d records pending after relaying, want 0", len(pending))
+	}
+}
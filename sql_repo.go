@@ -0,0 +1,402 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlRepository implements the AuditRepository interface over database/sql
+type sqlRepository struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	config  *Config
+}
+
+// sqlColumns lists the columns of the entries table in scan order
+const sqlColumns = "id, timestamp, action, actor_id, actor_type, actor_name, actor_session_id, " +
+	"resource_type, resource_id, resource_name, changes, metadata, ip_address, user_agent, " +
+	"success, error_msg, correlation_id, trace_id, span_id, parent_id, " +
+	"tenant_id, sample_rate, occurrences, last_occurrence"
+
+// NewSQLRepository creates a repository storing entries in the table named by
+// config.CollectionName of a PostgreSQL or SQLite database. The MongoDB
+// settings of config are ignored. When config.EnableIndexes is set the schema
+// migrations are applied at once; otherwise call EnsureIndexes. Close does
+// not close db.
+func NewSQLRepository(db *sql.DB, dialect SQLDialect, config *Config) (AuditRepository, error) {
+	if err := dialect.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateSQLIdentifier(config.CollectionName); err != nil {
+		return nil, ErrInvalidConfig{Field: "CollectionName", Message: err.Error()}
+	}
+	if config.TenantMode == TenantCollection {
+		return nil, ErrInvalidConfig{Field: "TenantMode", Message: "the collection-per-tenant mode is only supported by MongoDB"}
+	}
+
+	repo := &sqlRepository{
+		db:      db,
+		dialect: dialect,
+		table:   config.CollectionName,
+		config:  config,
+	}
+
+	if config.EnableIndexes {
+		if err := repo.EnsureIndexes(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to migrate schema: %w", err)
+		}
+	}
+
+	return repo, nil
+}
+
+// Insert inserts a new audit entry
+func (r *sqlRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if r.config.TenantMode != TenantNone && entry.TenantID == "" {
+		return ErrTenantRequired{Operation: "insert an audit entry"}
+	}
+
+	args, err := r.entryArgs(entry)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (id) DO NOTHING",
+		r.table, sqlColumns, r.dialect.placeholders(1, len(args)))
+
+	attempts, err := retry(ctx, r.config.retryPolicy(), func(attempt int) error {
+		result, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		// A duplicate ID on a retry means an earlier attempt was applied
+		// even though it reported an error
+		if n, err := result.RowsAffected(); err == nil && n == 0 && attempt == 1 {
+			return ErrDuplicateEntry{ID: entry.ID.Hex()}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry after %d attempts: %w", attempts, err)
+	}
+
+	return nil
+}
+
+// entryArgs returns the column values of an entry in sqlColumns order
+func (r *sqlRepository) entryArgs(entry AuditEntry) ([]any, error) {
+	changes, err := sqlJSON(entry.Changes, len(entry.Changes) == 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode changes: %w", err)
+	}
+	metadata, err := sqlJSON(entry.Metadata, len(entry.Metadata) == 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	var parentID any
+	if entry.ParentID != nil {
+		parentID = entry.ParentID.Hex()
+	}
+	var lastOccurrence any
+	if entry.LastOccurrence != nil {
+		lastOccurrence = r.dialect.timeValue(*entry.LastOccurrence)
+	}
+
+	return []any{
+		entry.ID.Hex(), r.dialect.timeValue(entry.Timestamp), string(entry.Action),
+		entry.Actor.ID, string(entry.Actor.Type), entry.Actor.Name, entry.Actor.SessionID,
+		entry.Resource.Type, entry.Resource.ID, entry.Resource.Name, changes, metadata,
+		entry.IPAddress, entry.UserAgent, entry.Success, entry.ErrorMsg,
+		entry.CorrelationID, entry.TraceID, entry.SpanID, parentID,
+		entry.TenantID, entry.SampleRate, entry.Occurrences, lastOccurrence,
+	}, nil
+}
+
+// sqlJSON encodes a value for a JSON column, or NULL if empty
+func sqlJSON(v any, empty bool) (any, error) {
+	if empty {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// scanEntry decodes the current row into an audit entry
+func scanEntry(row interface{ Scan(dest ...any) error }) (AuditEntry, error) {
+	var entry AuditEntry
+	var id string
+	var timestamp sqlTime
+	var changes, metadata []byte
+	var parentID sql.NullString
+	var lastOccurrence sqlTime
+
+	err := row.Scan(&id, &timestamp, &entry.Action,
+		&entry.Actor.ID, &entry.Actor.Type, &entry.Actor.Name, &entry.Actor.SessionID,
+		&entry.Resource.Type, &entry.Resource.ID, &entry.Resource.Name, &changes, &metadata,
+		&entry.IPAddress, &entry.UserAgent, &entry.Success, &entry.ErrorMsg,
+		&entry.CorrelationID, &entry.TraceID, &entry.SpanID, &parentID,
+		&entry.TenantID, &entry.SampleRate, &entry.Occurrences, &lastOccurrence)
+	if err != nil {
+		return entry, err
+	}
+
+	if entry.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return entry, fmt.Errorf("invalid stored ID %q: %w", id, err)
+	}
+	entry.Timestamp = timestamp.Time
+	if len(changes) > 0 {
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return entry, fmt.Errorf("failed to decode changes of %s: %w", id, err)
+		}
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &entry.Metadata); err != nil {
+			return entry, fmt.Errorf("failed to decode metadata of %s: %w", id, err)
+		}
+	}
+	if parentID.Valid {
+		parent, err := primitive.ObjectIDFromHex(parentID.String)
+		if err != nil {
+			return entry, fmt.Errorf("invalid stored parent ID %q: %w", parentID.String, err)
+		}
+		entry.ParentID = &parent
+	}
+	if lastOccurrence.Valid {
+		entry.LastOccurrence = &lastOccurrence.Time
+	}
+
+	return entry, nil
+}
+
+// findEntries runs a SELECT over the entries table, newest first
+func (r *sqlRepository) findEntries(ctx context.Context, where string, args []any, limit, offset int) ([]AuditEntry, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY timestamp DESC, id DESC", sqlColumns, r.table, where)
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT " + r.dialect.placeholder(len(args))
+	} else if offset > 0 && r.dialect == DialectSQLite {
+		// SQLite does not accept OFFSET without LIMIT
+		query += " LIMIT -1"
+	}
+	if offset > 0 {
+		args = append(args, offset)
+		query += " OFFSET " + r.dialect.placeholder(len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// FindByQuery finds audit entries based on query parameters
+func (r *sqlRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	where, args := r.buildWhere(query)
+
+	var total int64
+	count := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", r.table, where)
+	if err := r.db.QueryRowContext(ctx, count, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count entries: %w", err)
+	}
+
+	entries, err := r.findEntries(ctx, where, args, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	hasMore := false
+	if query.Limit > 0 && int64(query.Offset+len(entries)) < total {
+		hasMore = true
+	}
+
+	return &AuditQueryResult{
+		Entries: entries,
+		Total:   total,
+		HasMore: hasMore,
+	}, nil
+}
+
+// FindRange finds the entries of [start, end) after a cursor, oldest first
+func (r *sqlRepository) FindRange(ctx context.Context, start, end time.Time, after *RangeCursor, limit int) ([]AuditEntry, error) {
+	d := r.dialect
+	args := []any{d.timeValue(start), d.timeValue(end)}
+	where := fmt.Sprintf(" WHERE timestamp >= %s AND timestamp < %s", d.placeholder(1), d.placeholder(2))
+	if after != nil {
+		args = append(args, d.timeValue(after.Timestamp), d.timeValue(after.Timestamp), after.ID.Hex())
+		where += fmt.Sprintf(" AND (timestamp > %s OR (timestamp = %s AND id > %s))",
+			d.placeholder(3), d.placeholder(4), d.placeholder(5))
+	}
+	if tenantID := tenantFromContext(ctx); tenantID != "" {
+		args = append(args, tenantID)
+		where += " AND tenant_id = " + d.placeholder(len(args))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY timestamp, id", sqlColumns, r.table, where)
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT " + d.placeholder(len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find entries: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// FindByID finds an audit entry by its ID
+func (r *sqlRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("invalid ID format: %w", err)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = %s", sqlColumns, r.table, r.dialect.placeholder(1))
+	entry, err := scanEntry(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find audit entry: %w", err)
+	}
+
+	return &entry, nil
+}
+
+// FindByResource finds audit entries for a specific resource
+func (r *sqlRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	where, args := r.buildWhere(AuditQuery{TenantID: tenantFromContext(ctx), ResourceType: resourceType, ResourceID: resourceID})
+
+	entries, err := r.findEntries(ctx, where, args, limit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// FindByActor finds audit entries for a specific actor
+func (r *sqlRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	where, args := r.buildWhere(AuditQuery{TenantID: tenantFromContext(ctx), ActorID: actorID, ActorType: actorType})
+
+	entries, err := r.findEntries(ctx, where, args, limit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actor history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// EnsureIndexes applies pending schema migrations, which create the table
+// and its indexes
+func (r *sqlRepository) EnsureIndexes(ctx context.Context) error {
+	return migrateSQL(ctx, r.db, r.dialect, r.table)
+}
+
+// Close does nothing: the database handle belongs to the caller
+func (r *sqlRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// buildWhere builds the WHERE clause and its arguments from query parameters
+func (r *sqlRepository) buildWhere(query AuditQuery) (string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, values ...any) {
+		params := make([]any, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			params[i] = r.dialect.placeholder(len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, params...))
+	}
+
+	if query.TenantID != "" {
+		add("tenant_id = %s", query.TenantID)
+	}
+	if query.ActorID != "" {
+		add("actor_id = %s", query.ActorID)
+	}
+	if query.ActorType != "" {
+		add("actor_type = %s", string(query.ActorType))
+	}
+	if query.SessionID != "" {
+		add("actor_session_id = %s", query.SessionID)
+	}
+	if len(query.Actions) > 0 {
+		actions := make([]any, len(query.Actions))
+		for i, action := range query.Actions {
+			actions[i] = string(action)
+		}
+		add("action IN ("+strings.Repeat(", %s", len(actions))[2:]+")", actions...)
+	}
+	if query.ResourceType != "" {
+		add("resource_type = %s", query.ResourceType)
+	}
+	if query.ResourceID != "" {
+		add("resource_id = %s", query.ResourceID)
+	}
+	if query.Success != nil {
+		add("success = %s", *query.Success)
+	}
+	if query.CorrelationID != "" {
+		add("correlation_id = %s", query.CorrelationID)
+	}
+	if query.TraceID != "" {
+		add("trace_id = %s", query.TraceID)
+	}
+	if query.ParentID != "" {
+		add("parent_id = %s", query.ParentID)
+	}
+	if query.StartTime != nil {
+		add("timestamp >= %s", r.dialect.timeValue(*query.StartTime))
+	}
+	if query.EndTime != nil {
+		add("timestamp <= %s", r.dialect.timeValue(*query.EndTime))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,385 @@
+//go:build cgo
+
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestSQLRepository returns a migrated repository on a fresh SQLite database
+func newTestSQLRepository(t *testing.T) (AuditRepository, *sql.DB) {
+	t.Helper()
+
+	db := openSQLite(t)
+	config := DefaultConfig()
+	repo, err := NewSQLRepository(db, DialectSQLite, config)
+	if err != nil {
+		t.Fatalf("NewSQLRepository failed: %v", err)
+	}
+	return repo, db
+}
+
+// sqlColumnNames returns the columns of a table
+func sqlColumnNames(t *testing.T, db *sql.DB, table string) map[string]bool {
+	t.Helper()
+
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		t.Fatalf("failed to list columns: %v", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("failed to scan column: %v", err)
+		}
+		columns[name] = true
+	}
+	return columns
+}
+
+// sqlSchemaVersions returns the applied migration versions in order
+func sqlSchemaVersions(t *testing.T, db *sql.DB, table string) []int {
+	t.Helper()
+
+	rows, err := db.Query("SELECT version FROM " + table + "_schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatalf("failed to list versions: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatalf("failed to scan version: %v", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+func TestSQLMigrations(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestSQLRepository(t)
+
+	want := []int{1, 2, 3, 4}
+	if versions := sqlSchemaVersions(t, db, "audit_logs"); !reflect.DeepEqual(versions, want) {
+		t.Fatalf("applied versions %v, want %v", versions, want)
+	}
+	columns := sqlColumnNames(t, db, "audit_logs")
+	for _, column := range []string{"id", "timestamp", "parent_id", "tenant_id", "sample_rate", "occurrences", "last_occurrence"} {
+		if !columns[column] {
+			t.Errorf("missing column %s", column)
+		}
+	}
+
+	// Migrating again is a no-op
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("second EnsureIndexes failed: %v", err)
+	}
+	if versions := sqlSchemaVersions(t, db, "audit_logs"); !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions after migrating again %v, want %v", versions, want)
+	}
+}
+
+func TestSQLMigrationsUpgrade(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	// A table created by the first release, holding one entry
+	if err := migrateSQLTo(ctx, db, "audit_logs", 1); err != nil {
+		t.Fatalf("failed to apply the first migration: %v", err)
+	}
+	id := primitive.NewObjectID()
+	_, err := db.ExecContext(ctx, `INSERT INTO audit_logs
+	(id, timestamp, action, actor_id, actor_type, resource_type, resource_id, success)
+	VALUES (?, ?, 'update', 'user123', 'user', 'document', 'doc1', TRUE)`,
+		id.Hex(), DialectSQLite.timeValue(time.Now()))
+	if err != nil {
+		t.Fatalf("failed to insert a legacy row: %v", err)
+	}
+
+	config := DefaultConfig()
+	repo, err := NewSQLRepository(db, DialectSQLite, config)
+	if err != nil {
+		t.Fatalf("NewSQLRepository failed: %v", err)
+	}
+	if versions := sqlSchemaVersions(t, db, "audit_logs"); !reflect.DeepEqual(versions, []int{1, 2, 3, 4}) {
+		t.Fatalf("applied versions %v", versions)
+	}
+
+	entry, err := repo.FindByID(ctx, id.Hex())
+	if err != nil || entry == nil {
+		t.Fatalf("FindByID = %v, %v", entry, err)
+	}
+	if entry.TenantID != "" || entry.SampleRate != 0 || entry.Occurrences != 0 || entry.LastOccurrence != nil {
+		t.Errorf("legacy row read with %+v", entry)
+	}
+	if entry.Weight() != 1 {
+		t.Errorf("legacy row weight %v, want 1", entry.Weight())
+	}
+}
+
+// migrateSQLTo applies the migrations up to version
+func migrateSQLTo(ctx context.Context, db *sql.DB, table string, version int) error {
+	saved := sqlMigrations
+	defer func() { sqlMigrations = saved }()
+
+	sqlMigrations = saved[:version]
+	return migrateSQL(ctx, db, DialectSQLite, table)
+}
+
+func TestSQLRepositoryInsert(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestSQLRepository(t)
+
+	parent := primitive.NewObjectID()
+	last := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)
+	entry := NewAuditBuilder().
+		Update().
+		User("user123", "John Doe").
+		Session("session1").
+		Resource("document", "doc1", "Report").
+		AddChange("title", "Draft", "Final").
+		Metadata("pages", 12).
+		IPAddress("10.0.0.1").
+		UserAgent("test").
+		Correlation("corr1").
+		Trace("trace1", "span1").
+		Success(true).
+		Build()
+	entry.ID = primitive.NewObjectID()
+	entry.Timestamp = time.Date(2024, 5, 1, 10, 0, 0, 987654321, time.UTC)
+	entry.ParentID = &parent
+	entry.SampleRate = 0.25
+	entry.Occurrences = 3
+	entry.LastOccurrence = &last
+
+	if err := repo.Insert(ctx, entry); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	stored, err := repo.FindByID(ctx, entry.ID.Hex())
+	if err != nil || stored == nil {
+		t.Fatalf("FindByID = %v, %v", stored, err)
+	}
+	// JSON decodes numbers as float64
+	entry.Metadata = map[string]any{"pages": float64(12)}
+	if !reflect.DeepEqual(*stored, entry) {
+		t.Errorf("stored entry\n%+v\nwant\n%+v", *stored, entry)
+	}
+
+	// The same ID is reported as a duplicate and not stored twice
+	if err := repo.Insert(ctx, entry); !IsDuplicateEntry(err) {
+		t.Errorf("second Insert = %v, want a duplicate entry error", err)
+	}
+	if result, err := repo.FindByQuery(ctx, AuditQuery{}); err != nil || result.Total != 1 {
+		t.Errorf("FindByQuery after duplicate = %+v, %v; want one entry", result, err)
+	}
+
+	// The ID and timestamp of new entries are set on insert
+	unset := testEntry("doc2")
+	unset.ID = primitive.NilObjectID
+	unset.Timestamp = time.Time{}
+	if err := repo.Insert(ctx, unset); err != nil {
+		t.Fatalf("Insert without ID failed: %v", err)
+	}
+	result, err := repo.FindByQuery(ctx, AuditQuery{ResourceID: "doc2"})
+	if err != nil || len(result.Entries) != 1 {
+		t.Fatalf("FindByQuery = %+v, %v", result, err)
+	}
+	if result.Entries[0].ID.IsZero() || result.Entries[0].Timestamp.IsZero() {
+		t.Errorf("entry stored without ID or timestamp: %+v", result.Entries[0])
+	}
+
+	if missing, err := repo.FindByID(ctx, primitive.NewObjectID().Hex()); missing != nil || err != nil {
+		t.Errorf("FindByID of a missing entry = %v, %v; want nil, nil", missing, err)
+	}
+	if _, err := repo.FindByID(ctx, "not-an-id"); err == nil {
+		t.Error("expected an error for an invalid ID")
+	}
+}
+
+func TestSQLRepositoryFindByQuery(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestSQLRepository(t)
+
+	// Six entries one minute apart: a root and five children, alternating
+	// success, with the last two in another correlation
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	for i := 0; i < 6; i++ {
+		entry := testEntry("doc1")
+		entry.Action = ActionUpdate
+		entry.Timestamp = base.Add(time.Duration(i) * time.Minute)
+		entry.Success = i%2 == 0
+		if !entry.Success {
+			entry.ErrorMsg = "denied"
+		}
+		entry.CorrelationID = "corr1"
+		if i >= 4 {
+			entry.CorrelationID = "corr2"
+		}
+		if i > 0 {
+			entry.ParentID = &entries[0].ID
+		}
+		entries = append(entries, entry)
+	}
+	entries[5].Action = ActionDelete
+	entries[5].Actor = Actor{ID: "admin1", Type: ActorTypeAdmin}
+	for _, entry := range entries {
+		if err := repo.Insert(ctx, entry); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	at := func(minutes int) *time.Time {
+		t := base.Add(time.Duration(minutes) * time.Minute)
+		return &t
+	}
+	succeeded, failed := true, false
+
+	tests := []struct {
+		name    string
+		query   AuditQuery
+		want    []int // indexes into entries, newest first
+		total   int64
+		hasMore bool
+	}{
+		{name: "all", query: AuditQuery{}, want: []int{5, 4, 3, 2, 1, 0}, total: 6},
+		{name: "start time inclusive", query: AuditQuery{StartTime: at(3)}, want: []int{5, 4, 3}, total: 3},
+		{name: "end time inclusive", query: AuditQuery{EndTime: at(1)}, want: []int{1, 0}, total: 2},
+		{name: "time range", query: AuditQuery{StartTime: at(1), EndTime: at(3)}, want: []int{3, 2, 1}, total: 3},
+		{name: "parent", query: AuditQuery{ParentID: entries[0].ID.Hex()}, want: []int{5, 4, 3, 2, 1}, total: 5},
+		{name: "unknown parent", query: AuditQuery{ParentID: primitive.NewObjectID().Hex()}, want: []int{}, total: 0},
+		{name: "correlation", query: AuditQuery{CorrelationID: "corr2"}, want: []int{5, 4}, total: 2},
+		{name: "trace", query: AuditQuery{TraceID: "missing"}, want: []int{}, total: 0},
+		{name: "success", query: AuditQuery{Success: &succeeded}, want: []int{4, 2, 0}, total: 3},
+		{name: "failure", query: AuditQuery{Success: &failed}, want: []int{5, 3, 1}, total: 3},
+		{name: "actions", query: AuditQuery{Actions: []AuditAction{ActionDelete, ActionCreate}}, want: []int{5}, total: 1},
+		{name: "actor", query: AuditQuery{ActorID: "admin1", ActorType: ActorTypeAdmin}, want: []int{5}, total: 1},
+		{name: "resource", query: AuditQuery{ResourceType: "document", ResourceID: "doc1", Limit: 1}, want: []int{5}, total: 6, hasMore: true},
+		{name: "limit", query: AuditQuery{Limit: 2}, want: []int{5, 4}, total: 6, hasMore: true},
+		{name: "offset and limit", query: AuditQuery{Offset: 2, Limit: 2}, want: []int{3, 2}, total: 6, hasMore: true},
+		{name: "last page", query: AuditQuery{Offset: 4, Limit: 2}, want: []int{1, 0}, total: 6},
+		{name: "offset without limit", query: AuditQuery{Offset: 4}, want: []int{1, 0}, total: 6},
+		{name: "offset past the end", query: AuditQuery{Offset: 10, Limit: 2}, want: []int{}, total: 6},
+		{name: "combined", query: AuditQuery{CorrelationID: "corr1", Success: &failed, StartTime: at(2), Limit: 1}, want: []int{3}, total: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := repo.FindByQuery(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("FindByQuery failed: %v", err)
+			}
+
+			got := make([]int, 0, len(result.Entries))
+			for _, entry := range result.Entries {
+				for i := range entries {
+					if entries[i].ID == entry.ID {
+						got = append(got, i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+			if result.Total != tt.total || result.HasMore != tt.hasMore {
+				t.Errorf("total %d, has more %v; want %d, %v", result.Total, result.HasMore, tt.total, tt.hasMore)
+			}
+		})
+	}
+
+	history, err := repo.FindByResource(ctx, "document", "doc1", 2)
+	if err != nil || len(history) != 2 || history[0].ID != entries[5].ID {
+		t.Errorf("FindByResource = %d entries, %v", len(history), err)
+	}
+	history, err = repo.FindByActor(ctx, "user123", ActorTypeUser, 0)
+	if err != nil || len(history) != 5 || history[0].ID != entries[4].ID {
+		t.Errorf("FindByActor = %d entries, %v", len(history), err)
+	}
+}
+
+func TestSQLRepositoryTenants(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	config := DefaultConfig()
+	config.TenantMode = TenantShared
+	repo, err := NewSQLRepository(db, DialectSQLite, config)
+	if err != nil {
+		t.Fatalf("NewSQLRepository failed: %v", err)
+	}
+
+	if err := repo.Insert(ctx, testEntry("doc1")); err == nil {
+		t.Fatal("expected an error inserting an entry without a tenant")
+	}
+	for _, tenant := range []string{"acme", "globex"} {
+		entry := testEntry("doc1")
+		entry.TenantID = tenant
+		if err := repo.Insert(ctx, entry); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	history, err := repo.FindByResource(withTenant(ctx, "acme"), "document", "doc1", 0)
+	if err != nil || len(history) != 1 || history[0].TenantID != "acme" {
+		t.Errorf("FindByResource for acme = %+v, %v", history, err)
+	}
+
+	config.TenantMode = TenantCollection
+	if _, err := NewSQLRepository(db, DialectSQLite, config); err == nil {
+		t.Error("expected an error for the collection-per-tenant mode")
+	}
+}
+
+func TestSQLRepositoryFindRange(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestSQLRepository(t)
+	ranged := repo.(RangeRepository)
+
+	// Entries sharing a timestamp are paged by ID
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		entry := testEntry("doc1")
+		entry.Timestamp = base.Add(time.Duration(i/3) * time.Hour)
+		insertEntries(t, repo, entry)
+	}
+
+	var got []AuditEntry
+	var after *RangeCursor
+	for {
+		batch, err := ranged.FindRange(ctx, base, base.Add(2*time.Hour), after, 2)
+		if err != nil {
+			t.Fatalf("FindRange failed: %v", err)
+		}
+		got = append(got, batch...)
+		if len(batch) < 2 {
+			break
+		}
+		after = CursorOf(batch[len(batch)-1])
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("read %d entries, want 5", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		prev, cur := got[i-1], got[i]
+		if cur.Timestamp.Before(prev.Timestamp) || (cur.Timestamp.Equal(prev.Timestamp) && cur.ID.Hex() <= prev.ID.Hex()) {
+			t.Errorf("entry %d is out of order", i)
+		}
+	}
+
+	if batch, err := ranged.FindRange(ctx, base, base.Add(time.Hour), nil, 0); err != nil || len(batch) != 3 {
+		t.Errorf("FindRange of the first hour = %d entries, %v; want 3", len(batch), err)
+	}
+}
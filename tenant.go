@@ -0,0 +1,262 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantMode defines how a repository separates the entries of tenants
+type TenantMode string
+
+const (
+	TenantNone       TenantMode = ""           // entries may carry a tenant ID but none is required
+	TenantShared     TenantMode = "shared"     // every entry needs a tenant ID; indexes start with it
+	TenantCollection TenantMode = "collection" // every tenant has its own collection, e.g. audit_logs_acme; MongoDB only
+)
+
+// tenantIDPattern restricts tenant IDs to characters valid in collection names
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateTenantID validates a tenant ID: 1 to 64 letters, digits, '_' or '-'
+func ValidateTenantID(tenantID string) error {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return ErrInvalidTenant{TenantID: tenantID}
+	}
+	return nil
+}
+
+// ErrInvalidTenant represents a malformed tenant ID
+type ErrInvalidTenant struct {
+	TenantID string
+}
+
+func (e ErrInvalidTenant) Error() string {
+	return fmt.Sprintf("invalid tenant ID %q: use 1 to 64 letters, digits, '_' or '-'", e.TenantID)
+}
+
+// ErrTenantRequired represents an operation that needs a tenant ID on a
+// multi-tenant repository
+type ErrTenantRequired struct {
+	Operation string
+}
+
+func (e ErrTenantRequired) Error() string {
+	return "tenant ID required to " + e.Operation
+}
+
+// ErrTenantMismatch represents an entry of another tenant given to a
+// tenant-scoped handle
+type ErrTenantMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func (e ErrTenantMismatch) Error() string {
+	return fmt.Sprintf("audit entry belongs to tenant %q, not %q", e.Actual, e.Expected)
+}
+
+// tenantContextKey is the context key of the tenant of a scoped handle
+type tenantContextKey struct{}
+
+// withTenant returns a copy of ctx carrying the tenant of a scoped handle,
+// so that repositories can route lookups that take no query
+func withTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// tenantFromContext returns the tenant of a scoped handle, or ""
+func tenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantID
+}
+
+// stampTenant assigns an entry to a tenant, rejecting entries of another one
+func stampTenant(entry AuditEntry, tenantID string) (AuditEntry, error) {
+	if entry.TenantID != "" && entry.TenantID != tenantID {
+		return entry, ErrTenantMismatch{Expected: tenantID, Actual: entry.TenantID}
+	}
+	entry.TenantID = tenantID
+	return entry, nil
+}
+
+// tenantRepository restricts a repository to the entries of one tenant
+type tenantRepository struct {
+	repo     AuditRepository
+	tenantID string
+}
+
+// ForTenantRepository returns a handle to repo restricted to one tenant:
+// inserted entries are assigned to the tenant and reads only return its
+// entries. The handle deliberately does not expose the repository it wraps,
+// and closing it does not close that repository.
+func ForTenantRepository(repo AuditRepository, tenantID string) (AuditRepository, error) {
+	if err := ValidateTenantID(tenantID); err != nil {
+		return nil, err
+	}
+	return &tenantRepository{repo: repo, tenantID: tenantID}, nil
+}
+
+// Insert inserts an entry of the tenant
+func (r *tenantRepository) Insert(ctx context.Context, entry AuditEntry) error {
+	entry, err := stampTenant(entry, r.tenantID)
+	if err != nil {
+		return err
+	}
+	return r.repo.Insert(withTenant(ctx, r.tenantID), entry)
+}
+
+// InsertInSession inserts an entry of the tenant within the caller's session
+func (r *tenantRepository) InsertInSession(sc mongo.SessionContext, entry AuditEntry) error {
+	repo, ok := sessionRepository(r.repo)
+	if !ok {
+		return ErrTransactionsUnsupported{}
+	}
+	entry, err := stampTenant(entry, r.tenantID)
+	if err != nil {
+		return err
+	}
+	return repo.InsertInSession(sc, entry)
+}
+
+// FindByQuery finds the tenant's entries matching the query
+func (r *tenantRepository) FindByQuery(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	query.TenantID = r.tenantID
+	return r.repo.FindByQuery(withTenant(ctx, r.tenantID), query)
+}
+
+// FindByID finds an entry of the tenant by its ID. Entries of other tenants
+// are reported as not found.
+func (r *tenantRepository) FindByID(ctx context.Context, id string) (*AuditEntry, error) {
+	entry, err := r.repo.FindByID(withTenant(ctx, r.tenantID), id)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if entry.TenantID != r.tenantID {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// FindByResource finds the tenant's entries for a specific resource
+func (r *tenantRepository) FindByResource(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	result, err := r.FindByQuery(ctx, AuditQuery{ResourceType: resourceType, ResourceID: resourceID, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource history: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// FindByActor finds the tenant's entries for a specific actor
+func (r *tenantRepository) FindByActor(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	result, err := r.FindByQuery(ctx, AuditQuery{ActorID: actorID, ActorType: actorType, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actor history: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// EnsureIndexes creates the indexes of the wrapped repository
+func (r *tenantRepository) EnsureIndexes(ctx context.Context) error {
+	return r.repo.EnsureIndexes(withTenant(ctx, r.tenantID))
+}
+
+// Close does nothing: the wrapped repository is shared with other tenants
+func (r *tenantRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// tenantService restricts a service to the entries of one tenant
+type tenantService struct {
+	service  AuditService
+	tenantID string
+}
+
+// ForTenant returns a handle to service restricted to one tenant: logged
+// entries are assigned to the tenant, entries of another tenant are rejected
+// and every read only returns the tenant's entries. The handle deliberately
+// does not expose the service it wraps, and closing it does not close that
+// service.
+func ForTenant(service AuditService, tenantID string) (AuditService, error) {
+	if err := ValidateTenantID(tenantID); err != nil {
+		return nil, err
+	}
+	return &tenantService{service: service, tenantID: tenantID}, nil
+}
+
+// LogAction logs an entry of the tenant
+func (s *tenantService) LogAction(ctx context.Context, entry AuditEntry) error {
+	entry, err := stampTenant(entry, s.tenantID)
+	if err != nil {
+		return err
+	}
+	return s.service.LogAction(withTenant(ctx, s.tenantID), entry)
+}
+
+// LogActionInSession logs an entry of the tenant within the caller's transaction
+func (s *tenantService) LogActionInSession(sc mongo.SessionContext, entry AuditEntry) error {
+	service, ok := s.service.(SessionService)
+	if !ok {
+		return ErrTransactionsUnsupported{}
+	}
+	entry, err := stampTenant(entry, s.tenantID)
+	if err != nil {
+		return err
+	}
+	return service.LogActionInSession(sc, entry)
+}
+
+// GetHistory retrieves the tenant's audit history
+func (s *tenantService) GetHistory(ctx context.Context, query AuditQuery) (*AuditQueryResult, error) {
+	query.TenantID = s.tenantID
+	return s.service.GetHistory(withTenant(ctx, s.tenantID), query)
+}
+
+// GetByID retrieves an entry of the tenant by its ID. Entries of other
+// tenants are reported as not found.
+func (s *tenantService) GetByID(ctx context.Context, id string) (*AuditEntry, error) {
+	entry, err := s.service.GetByID(withTenant(ctx, s.tenantID), id)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if entry.TenantID != s.tenantID {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// GetResourceHistory retrieves the tenant's audit history for a specific resource
+func (s *tenantService) GetResourceHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]AuditEntry, error) {
+	if resourceType == "" {
+		return nil, fmt.Errorf("resource type cannot be empty")
+	}
+	if resourceID == "" {
+		return nil, fmt.Errorf("resource ID cannot be empty")
+	}
+
+	result, err := s.GetHistory(ctx, AuditQuery{ResourceType: resourceType, ResourceID: resourceID, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// GetActorHistory retrieves the tenant's audit history for a specific actor
+func (s *tenantService) GetActorHistory(ctx context.Context, actorID string, actorType ActorType, limit int) ([]AuditEntry, error) {
+	if actorID == "" {
+		return nil, fmt.Errorf("actor ID cannot be empty")
+	}
+
+	result, err := s.GetHistory(ctx, AuditQuery{ActorID: actorID, ActorType: actorType, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// Close does nothing: the wrapped service is shared with other tenants
+func (s *tenantService) Close(ctx context.Context) error {
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 // AuditEntry represents a single audit log entry
 type AuditEntry struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID  string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
 	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 	Action    AuditAction        `bson:"action" json:"action"`
 	Actor     Actor              `bson:"actor" json:"actor"`
@@ -19,6 +20,33 @@ type AuditEntry struct {
 	UserAgent string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
 	Success   bool               `bson:"success" json:"success"`
 	ErrorMsg  string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+
+	// Linkage between entries produced by the same request or workflow
+	CorrelationID string              `bson:"correlation_id,omitempty" json:"correlation_id,omitempty"`
+	TraceID       string              `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	SpanID        string              `bson:"span_id,omitempty" json:"span_id,omitempty"`
+	ParentID      *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+
+	// Volume reduction, see Weight. SampleRate is the fraction of similar
+	// entries kept by fixed-rate sampling; Occurrences counts the identical
+	// entries merged into this one by coalescing, the last of which happened
+	// at LastOccurrence. All are zero if the entry was logged as is.
+	SampleRate     float64    `bson:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	Occurrences    int        `bson:"occurrences,omitempty" json:"occurrences,omitempty"`
+	LastOccurrence *time.Time `bson:"last_occurrence,omitempty" json:"last_occurrence,omitempty"`
+}
+
+// Weight returns the number of logged entries an entry stands for, so that
+// counts of sampled and coalesced entries can be extrapolated
+func (e AuditEntry) Weight() float64 {
+	weight := 1.0
+	if e.Occurrences > 1 {
+		weight = float64(e.Occurrences)
+	}
+	if e.SampleRate > 0 && e.SampleRate < 1 {
+		weight /= e.SampleRate
+	}
+	return weight
 }
 
 // Actor represents who/what performed the action
@@ -69,17 +97,21 @@ type FieldChange struct {
 
 // AuditQuery represents query parameters for searching audit logs
 type AuditQuery struct {
-	ActorID      string        `json:"actor_id,omitempty"`
-	ActorType    ActorType     `json:"actor_type,omitempty"`
-	SessionID    string        `json:"session_id,omitempty"`
-	Actions      []AuditAction `json:"actions,omitempty"`
-	ResourceType string        `json:"resource_type,omitempty"`
-	ResourceID   string        `json:"resource_id,omitempty"`
-	StartTime    *time.Time    `json:"start_time,omitempty"`
-	EndTime      *time.Time    `json:"end_time,omitempty"`
-	Success      *bool         `json:"success,omitempty"`
-	Limit        int           `json:"limit,omitempty"`
-	Offset       int           `json:"offset,omitempty"`
+	TenantID      string        `json:"tenant_id,omitempty"`
+	ActorID       string        `json:"actor_id,omitempty"`
+	ActorType     ActorType     `json:"actor_type,omitempty"`
+	SessionID     string        `json:"session_id,omitempty"`
+	Actions       []AuditAction `json:"actions,omitempty"`
+	ResourceType  string        `json:"resource_type,omitempty"`
+	ResourceID    string        `json:"resource_id,omitempty"`
+	StartTime     *time.Time    `json:"start_time,omitempty"`
+	EndTime       *time.Time    `json:"end_time,omitempty"`
+	Success       *bool         `json:"success,omitempty"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	TraceID       string        `json:"trace_id,omitempty"`
+	ParentID      string        `json:"parent_id,omitempty"`
+	Limit         int           `json:"limit,omitempty"`
+	Offset        int           `json:"offset,omitempty"`
 }
 
 // AuditQueryResult represents the result of an audit query